@@ -7,24 +7,32 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/b4o4/conference-backend/internal/config"
 	"github.com/b4o4/conference-backend/internal/routes"
-	"github.com/joho/godotenv"
+	"github.com/b4o4/conference-backend/internal/tracing"
+	"github.com/b4o4/conference-backend/internal/websockets"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 // nolint
 var (
 	port string
+
+	// adminAddr moves the management endpoints to a listener of their own, empty keeps them on port
+	adminAddr string
+
+	shutdownTimeout time.Duration
 )
 
 func init() {
-	if err := godotenv.Load(); err != nil {
-		log.Print("No .env file found")
-	}
-
 	envPort, exist := os.LookupEnv("PORT")
 
 	if !exist {
@@ -32,11 +40,74 @@ func init() {
 	} else {
 		port = envPort
 	}
+
+	if adminPort := config.String("ADMIN_PORT", ""); adminPort != "" {
+		adminAddr = fmt.Sprintf("%s:%s", config.String("ADMIN_BIND", "127.0.0.1"), adminPort)
+	}
+
+	shutdownTimeout = config.Duration("SHUTDOWN_TIMEOUT", 10*time.Second)
 }
 
 func main() {
+	if err := websockets.LoadRooms(); err != nil {
+		log.Fatalf("ROOM_STORE_FILE: %v", err)
+	}
+
 	router := routes.NewRouter()
 
+	var adminServer *http.Server
+	if adminAddr != "" {
+		router = routes.NewPublicRouter()
+		adminServer = routes.NewServer(adminAddr, routes.NewAdminRouter())
+	}
+
+	server := routes.NewServer(fmt.Sprintf(":%s", port), router)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+
+		<-ctx.Done()
+		log.Print("Shutting down")
+
+		// Hijacked websockets aren't tracked by Shutdown, peers are told and closed first
+		websockets.CloseAll()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println(err)
+		}
+
+		if adminServer != nil {
+			if err := adminServer.Shutdown(shutdownCtx); err != nil {
+				log.Println(err)
+			}
+		}
+
+		if err := tracing.Shutdown(shutdownCtx); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if adminServer != nil {
+		log.Printf("Admin endpoints listen on %s", adminAddr)
+
+		go func() {
+			if err := adminServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	// start HTTP server
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), router)) // nolint:gosec
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+
+	<-shutdownDone
 }
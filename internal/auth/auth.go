@@ -0,0 +1,86 @@
+// Package auth resolves who is behind a request. The scheme is pluggable so
+// every deployment can authenticate the way it needs to
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity is the authenticated caller
+type Identity struct {
+	Subject string `json:"subject,omitempty"`
+	Name    string `json:"name,omitempty"`
+
+	// TokenID is the jti of the token the caller came with, SingleUse says
+	// it opens one websocket only, see SingleUse. ExpiresAt is when it lapses
+	TokenID   string    `json:"-"`
+	SingleUse bool      `json:"-"`
+	ExpiresAt time.Time `json:"-"`
+
+	// Room is the only room the token lets the caller join, empty allows any
+	Room string `json:"-"`
+}
+
+// Authenticator resolves the identity behind a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// TokenIssuer is implemented by authenticators that can mint the tokens they accept
+type TokenIssuer interface {
+	Issue(identity Identity, ttl time.Duration) (string, time.Time, error)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the identity
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext returns the identity stored by Middleware
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	return identity, ok
+}
+
+// Middleware rejects requests the authenticator refuses with 401 and stores
+// the resolved identity in the request context for the next handler
+func Middleware(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, err := a.Authenticate(r)
+			if err != nil {
+				log.Printf("auth: %s %s: %v", r.Method, r.URL.Path, err)
+				http.Error(w, ErrUnauthenticated.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+		})
+	}
+}
+
+// NoopAuthenticator lets everybody in anonymously
+type NoopAuthenticator struct{}
+
+func (NoopAuthenticator) Authenticate(*http.Request) (Identity, error) {
+	return Identity{}, nil
+}
+
+// bearerToken returns the token from the Authorization header, or from the
+// token query parameter since browsers can't set headers on websockets
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+
+	return r.URL.Query().Get("token")
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fixedAuthenticator resolves every request to the same identity or error
+type fixedAuthenticator struct {
+	identity Identity
+	err      error
+}
+
+func (a fixedAuthenticator) Authenticate(*http.Request) (Identity, error) {
+	return a.identity, a.err
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen Identity
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = FromContext(r.Context())
+	})
+
+	w := httptest.NewRecorder()
+	Middleware(fixedAuthenticator{identity: Identity{Subject: "user-1"}})(next).
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || seen.Subject != "user-1" {
+		t.Fatalf("got %d with identity %+v, want 200 with user-1", w.Code, seen)
+	}
+
+	seen = Identity{}
+	w = httptest.NewRecorder()
+	Middleware(fixedAuthenticator{err: errors.New("bad token")})(next).
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusUnauthorized || seen.Subject != "" {
+		t.Fatalf("got %d with identity %+v, want 401 without reaching the handler", w.Code, seen)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		target string
+		want   string
+	}{
+		{name: "header", header: "Bearer abc", target: "/", want: "abc"},
+		{name: "query", target: "/?token=abc", want: "abc"},
+		{name: "header wins", header: "Bearer abc", target: "/?token=def", want: "abc"},
+		{name: "other scheme", header: "Basic abc", target: "/", want: ""},
+		{name: "none", target: "/", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			if got := bearerToken(r); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+// JWTAuthenticator accepts HS256 tokens signed with a shared secret
+type JWTAuthenticator struct {
+	Secret []byte
+}
+
+type claims struct {
+	Name string `json:"name,omitempty"`
+
+	// SingleUse tokens are one-time invites, see SingleUse
+	SingleUse bool `json:"single_use,omitempty"`
+
+	// Room binds the token to one room, see Identity.Room
+	Room string `json:"room,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (a JWTAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	raw := bearerToken(r)
+	if raw == "" {
+		return Identity{}, errors.New("missing token")
+	}
+
+	parsed := &claims{}
+	if _, err := jwt.ParseWithClaims(raw, parsed, func(*jwt.Token) (interface{}, error) {
+		return a.Secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name})); err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{
+		Subject:   parsed.Subject,
+		Name:      parsed.Name,
+		TokenID:   parsed.ID,
+		SingleUse: parsed.SingleUse,
+		Room:      parsed.Room,
+	}
+	if parsed.ExpiresAt != nil {
+		identity.ExpiresAt = parsed.ExpiresAt.Time
+	}
+
+	return identity, nil
+}
+
+// Issue signs a token for the identity valid for ttl, single use and bound
+// to a room if the identity says so. Every token gets its own jti
+func (a JWTAuthenticator) Issue(identity Identity, ttl time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		Name:      identity.Name,
+		SingleUse: identity.SingleUse,
+		Room:      identity.Room,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   identity.Subject,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}).SignedString(a.Secret)
+
+	return token, expiresAt, err
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-secret")
+
+// signed signs the claims with the method and secret given
+func signed(t *testing.T, method jwt.SigningMethod, secret interface{}, c claims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, c).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// bearerRequest is a request carrying the token in the Authorization header
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestJWTAuthenticatorIssued(t *testing.T) {
+	a := JWTAuthenticator{Secret: testSecret}
+
+	token, expiresAt, err := a.Issue(Identity{Subject: "user-1", Name: "Alice", SingleUse: true, Room: "room-1"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := map[string]*http.Request{
+		"header": bearerRequest(token),
+		"query":  httptest.NewRequest(http.MethodGet, "/?token="+token, nil),
+	}
+	for name, r := range requests {
+		t.Run(name, func(t *testing.T) {
+			identity, err := a.Authenticate(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if identity.Subject != "user-1" || identity.Name != "Alice" || !identity.SingleUse || identity.Room != "room-1" {
+				t.Fatalf("got identity %+v", identity)
+			}
+			if identity.TokenID == "" {
+				t.Fatal("the issued token has no jti")
+			}
+			if !identity.ExpiresAt.Equal(expiresAt.Truncate(time.Second)) {
+				t.Fatalf("got expiry %v, want %v", identity.ExpiresAt, expiresAt.Truncate(time.Second))
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorRejects(t *testing.T) {
+	a := JWTAuthenticator{Secret: testSecret}
+	valid := claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}}
+	expired := claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+	}}
+	notYetValid := claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "user-1",
+		NotBefore: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}
+
+	tests := map[string]string{
+		"missing token":      "",
+		"malformed token":    "not.a.token",
+		"wrong secret":       signed(t, jwt.SigningMethodHS256, []byte("other-secret"), valid),
+		"expired":            signed(t, jwt.SigningMethodHS256, testSecret, expired),
+		"not yet valid":      signed(t, jwt.SigningMethodHS256, testSecret, notYetValid),
+		"other HMAC":         signed(t, jwt.SigningMethodHS512, testSecret, valid),
+		"unsigned":           signed(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid),
+		"tampered signature": signed(t, jwt.SigningMethodHS256, testSecret, valid) + "x",
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if token != "" {
+				r = bearerRequest(token)
+			}
+
+			if identity, err := a.Authenticate(r); err == nil {
+				t.Fatalf("accepted as %+v", identity)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrTokenUsed = errors.New("token already used")
+
+// consumedSweepInterval is how often the lapsed tokens are dropped from the
+// consumed set, they would be refused as expired anyway
+const consumedSweepInterval = time.Minute
+
+// consumed holds the jti of the single use tokens already spent, until they
+// expire. It lives in memory, so each instance keeps its own
+var consumed = &consumedTokens{ids: make(map[string]time.Time)}
+
+type consumedTokens struct {
+	mu        sync.Mutex
+	ids       map[string]time.Time
+	nextSweep time.Time
+}
+
+// consume spends the token, false if it was spent before
+func (c *consumedTokens) consume(id string, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now := time.Now(); now.After(c.nextSweep) {
+		for consumedID, expiry := range c.ids {
+			// Tokens without expiry are kept as long as the set lives
+			if !expiry.IsZero() && now.After(expiry) {
+				delete(c.ids, consumedID)
+			}
+		}
+		c.nextSweep = now.Add(consumedSweepInterval)
+	}
+
+	if _, spent := c.ids[id]; spent {
+		return false
+	}
+
+	c.ids[id] = expiresAt
+
+	return true
+}
+
+// spent tells if the token was consumed already
+func (c *consumedTokens) spent(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, spent := c.ids[id]
+
+	return spent
+}
+
+type spendKey struct{}
+
+// spender consumes the token of one request, at most once
+type spender struct {
+	once     sync.Once
+	err      error
+	identity Identity
+}
+
+func (s *spender) spend() error {
+	s.once.Do(func() {
+		if !consumed.consume(s.identity.TokenID, s.identity.ExpiresAt) {
+			s.err = ErrTokenUsed
+		}
+	})
+
+	return s.err
+}
+
+// SingleUse refuses with 401 a single use token presented a second time. It
+// goes after Middleware on the routes that spend a token, the websocket
+// joins, other requests may carry the token as often as they like. The token
+// is only consumed when the handler calls Spend, so a refused join leaves it
+// good for another try
+func SingleUse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := FromContext(r.Context())
+		if !identity.SingleUse {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Without a jti the token can't be told apart from its copies
+		if identity.TokenID == "" || consumed.spent(identity.TokenID) {
+			log.Printf("auth: %s %s: %v", r.Method, r.URL.Path, ErrTokenUsed)
+			http.Error(w, ErrTokenUsed.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), spendKey{}, &spender{identity: identity})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Spend consumes the single use token of the request once it got what it was
+// for, ErrTokenUsed if a concurrent request spent it first. Later calls for
+// the same request return the first result, and requests without a single
+// use token always get nil
+func Spend(ctx context.Context) error {
+	s, ok := ctx.Value(spendKey{}).(*spender)
+	if !ok {
+		return nil
+	}
+
+	return s.spend()
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSingleUse(t *testing.T) {
+	// The handler spends the token when the request asks for it, like a join that went through
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("spend") == "" {
+			return
+		}
+		for i := 0; i < 2; i++ {
+			if err := Spend(r.Context()); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		}
+	})
+
+	identity := Identity{SingleUse: true, TokenID: "jti-1", ExpiresAt: time.Now().Add(time.Minute)}
+	send := func(identity Identity, target string) int {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		SingleUse(next).ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+		return w.Code
+	}
+
+	tests := []struct {
+		name     string
+		identity Identity
+		target   string
+		want     int
+	}{
+		{name: "refused attempt", identity: identity, target: "/", want: http.StatusOK},
+		{name: "attempt that spends", identity: identity, target: "/?spend=1", want: http.StatusOK},
+		{name: "spent token", identity: identity, target: "/", want: http.StatusUnauthorized},
+		{name: "token without jti", identity: Identity{SingleUse: true}, target: "/", want: http.StatusUnauthorized},
+		{name: "reusable token", identity: Identity{TokenID: "jti-1"}, target: "/?spend=1", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if got := send(tt.identity, tt.target); got != tt.want {
+			t.Fatalf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSpendWithoutSingleUse(t *testing.T) {
+	if err := Spend(context.Background()); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+}
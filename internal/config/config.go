@@ -0,0 +1,187 @@
+// Package config reads settings from the environment, loading the .env file
+// and the CONFIG_FILE first
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted replaces the value of sensitive settings in Effective
+const redacted = "[redacted]"
+
+var (
+	// effective is every setting read so far with the value in use, defaults included
+	effective   = map[string]interface{}{}
+	effectiveMu sync.Mutex
+)
+
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Print("No .env file found")
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadFile(path); err != nil {
+			log.Fatalf("CONFIG_FILE: %v", err)
+		}
+	}
+}
+
+// loadFile reads a flat YAML or JSON object of settings named like the
+// environment variables, e.g. PORT: 8080. Variables already set win over the file
+func loadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	settings := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &settings)
+	case ".json":
+		// Numbers are kept as written, 1000000 mustn't turn into 1e+06
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		err = decoder.Decode(&settings)
+	default:
+		return fmt.Errorf("%s: unknown format, use .yaml, .yml or .json", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for key, value := range settings {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return fmt.Errorf("%s: %s must be a single value", path, key)
+		}
+
+		if _, exist := os.LookupEnv(key); exist {
+			continue
+		}
+
+		if value == nil {
+			value = ""
+		}
+
+		if err = os.Setenv(key, fmt.Sprint(value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// String reads a string from the environment, falling back to def when unset
+func String(key, def string) string {
+	value, exist := os.LookupEnv(key)
+	if !exist || value == "" {
+		value = def
+	}
+
+	record(key, value)
+	return value
+}
+
+// Bool reads a boolean such as "true" or "1" from the environment, falling back to def when unset
+func Bool(key string, def bool) bool {
+	value, exist := os.LookupEnv(key)
+	if !exist || value == "" {
+		record(key, def)
+		return def
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be a boolean, got %q", key, value)
+	}
+
+	record(key, b)
+	return b
+}
+
+// Int reads a non-negative integer from the environment, falling back to def when unset
+func Int(key string, def int) int {
+	value, exist := os.LookupEnv(key)
+	if !exist || value == "" {
+		record(key, def)
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Fatalf("%s must be a non-negative integer, got %q", key, value)
+	}
+
+	record(key, n)
+	return n
+}
+
+// Duration reads a duration such as "15s" from the environment, falling back to def when unset
+func Duration(key string, def time.Duration) time.Duration {
+	value, exist := os.LookupEnv(key)
+	if !exist || value == "" {
+		record(key, def.String())
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Fatalf("%s must be a non-negative duration, got %q", key, value)
+	}
+
+	record(key, d.String())
+	return d
+}
+
+func record(key string, value interface{}) {
+	effectiveMu.Lock()
+	defer effectiveMu.Unlock()
+
+	effective[key] = value
+}
+
+// Effective returns the settings read so far with the values in use, those
+// holding secrets, credentials or key files redacted when set
+func Effective() map[string]interface{} {
+	effectiveMu.Lock()
+	defer effectiveMu.Unlock()
+
+	settings := make(map[string]interface{}, len(effective))
+	for key, value := range effective {
+		if sensitive(key) && value != "" {
+			value = redacted
+		}
+		settings[key] = value
+	}
+
+	return settings
+}
+
+// sensitive tells the settings whose value mustn't leave the server, such as
+// ADMIN_API_KEY, JWT_SECRET, TURN_CREDENTIAL or a TLS_KEY_FILE path
+func sensitive(key string) bool {
+	if key == "TURN_USERNAME" {
+		return true
+	}
+
+	for _, suffix := range []string{"_SECRET", "_CREDENTIAL", "_PASSWORD", "_KEY", "_KEY_FILE"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a settings file in a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// unsetAfter removes the variables a file loaded once the test is done
+func unsetAfter(t *testing.T, keys ...string) {
+	t.Cleanup(func() {
+		for _, key := range keys {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "TEST_PORT: 8080\nTEST_ENABLED: true\nTEST_LIMIT: 1000000\nTEST_EMPTY:\n",
+		},
+		{
+			name:    "yml",
+			file:    "config.yml",
+			content: "TEST_PORT: 8080\nTEST_ENABLED: true\nTEST_LIMIT: 1000000\nTEST_EMPTY: null\n",
+		},
+		{
+			name:    "json",
+			file:    "config.json",
+			content: `{"TEST_PORT": 8080, "TEST_ENABLED": true, "TEST_LIMIT": 1000000, "TEST_EMPTY": null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetAfter(t, "TEST_PORT", "TEST_ENABLED", "TEST_LIMIT", "TEST_EMPTY")
+
+			if err := loadFile(writeFile(t, tt.file, tt.content)); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]string{"TEST_PORT": "8080", "TEST_ENABLED": "true", "TEST_LIMIT": "1000000", "TEST_EMPTY": ""}
+			for key, value := range want {
+				if got, exist := os.LookupEnv(key); !exist || got != value {
+					t.Errorf("%s: got %q, want %q", key, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadFileEnvironmentWins(t *testing.T) {
+	t.Setenv("TEST_PORT", "9000")
+
+	if err := loadFile(writeFile(t, "config.yaml", "TEST_PORT: 8080\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := os.Getenv("TEST_PORT"); got != "9000" {
+		t.Fatalf("got %q, want the environment's 9000", got)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{name: "unknown format", file: "config.toml", content: "TEST_PORT = 8080", want: "unknown format"},
+		{name: "malformed yaml", file: "config.yaml", content: "TEST_PORT: [", want: "config.yaml"},
+		{name: "malformed json", file: "config.json", content: "{", want: "config.json"},
+		{name: "nested object", file: "config.yaml", content: "TEST_TURN:\n  URL: turn:example.com\n", want: "TEST_TURN must be a single value"},
+		{name: "list", file: "config.json", content: `{"TEST_SERVERS": ["a", "b"]}`, want: "TEST_SERVERS must be a single value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadFile(writeFile(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+
+	if err := loadFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("a missing file was loaded")
+	}
+}
+
+func TestReaders(t *testing.T) {
+	t.Setenv("TEST_STRING", "value")
+	t.Setenv("TEST_BOOL", "1")
+	t.Setenv("TEST_INT", "42")
+	t.Setenv("TEST_DURATION", "1m30s")
+	t.Setenv("TEST_BLANK", "")
+
+	if got := String("TEST_STRING", "def"); got != "value" {
+		t.Errorf("String: got %q, want value", got)
+	}
+	if got := String("TEST_BLANK", "def"); got != "def" {
+		t.Errorf("String of a blank variable: got %q, want def", got)
+	}
+	if got := Bool("TEST_BOOL", false); !got {
+		t.Error("Bool: got false, want true")
+	}
+	if got := Bool("TEST_UNSET_BOOL", true); !got {
+		t.Error("Bool default: got false, want true")
+	}
+	if got := Int("TEST_INT", 0); got != 42 {
+		t.Errorf("Int: got %d, want 42", got)
+	}
+	if got := Int("TEST_BLANK", 7); got != 7 {
+		t.Errorf("Int of a blank variable: got %d, want 7", got)
+	}
+	if got := Duration("TEST_DURATION", 0); got != 90*time.Second {
+		t.Errorf("Duration: got %v, want 1m30s", got)
+	}
+	if got := Duration("TEST_UNSET_DURATION", time.Second); got != time.Second {
+		t.Errorf("Duration default: got %v, want 1s", got)
+	}
+
+	settings := Effective()
+	if settings["TEST_INT"] != 42 || settings["TEST_DURATION"] != "1m30s" || settings["TEST_BLANK"] != 7 {
+		t.Errorf("Effective doesn't hold the values in use: %v", settings)
+	}
+}
+
+func TestSensitive(t *testing.T) {
+	tests := map[string]bool{
+		"ADMIN_API_KEY":     true,
+		"JWT_SECRET":        true,
+		"TURN_CREDENTIAL":   true,
+		"TURN_USERNAME":     true,
+		"SMTP_PASSWORD":     true,
+		"TLS_KEY_FILE":      true,
+		"PORT":              false,
+		"ICE_SERVERS":       false,
+		"TLS_CERT_FILE":     false,
+		"KEYFRAME_INTERVAL": false,
+		"SECRET_MODE":       false,
+	}
+
+	for key, want := range tests {
+		if got := sensitive(key); got != want {
+			t.Errorf("sensitive(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestEffectiveRedacts(t *testing.T) {
+	t.Setenv("TEST_SECRET", "hunter2")
+	String("TEST_SECRET", "")
+	String("TEST_UNSET_SECRET", "")
+	String("TEST_PLAIN", "visible")
+
+	settings := Effective()
+	if settings["TEST_SECRET"] != redacted {
+		t.Errorf("got %v for a set secret, want it redacted", settings["TEST_SECRET"])
+	}
+	if settings["TEST_UNSET_SECRET"] != "" {
+		t.Errorf("got %v for an unset secret, want it shown as empty", settings["TEST_UNSET_SECRET"])
+	}
+	if settings["TEST_PLAIN"] != "visible" {
+		t.Errorf("got %v for a plain setting, want visible", settings["TEST_PLAIN"])
+	}
+}
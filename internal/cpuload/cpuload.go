@@ -0,0 +1,43 @@
+package cpuload
+
+import (
+	"math"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Sampler measures the share of the machine's CPUs the process used over
+// the last interval, from 0 to 1
+type Sampler struct {
+	load atomic.Uint64
+}
+
+// NewSampler starts sampling every interval for the life of the process
+func NewSampler(interval time.Duration) *Sampler {
+	s := &Sampler{}
+	go s.run(interval)
+
+	return s
+}
+
+// Load returns the last measured load, 0 until the first interval is over
+// or where the process CPU time can't be read
+func (s *Sampler) Load() float64 {
+	return math.Float64frombits(s.load.Load())
+}
+
+func (s *Sampler) run(interval time.Duration) {
+	lastCPU, lastWall := processCPUTime(), time.Now()
+
+	for range time.Tick(interval) {
+		cpu, wall := processCPUTime(), time.Now()
+
+		if elapsed := wall.Sub(lastWall); elapsed > 0 {
+			load := float64(cpu-lastCPU) / float64(elapsed) / float64(runtime.NumCPU())
+			s.load.Store(math.Float64bits(math.Min(math.Max(load, 0), 1)))
+		}
+
+		lastCPU, lastWall = cpu, wall
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package cpuload
+
+import "time"
+
+func processCPUTime() time.Duration {
+	return 0
+}
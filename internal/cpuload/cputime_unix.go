@@ -0,0 +1,18 @@
+//go:build unix
+
+package cpuload
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime returns the user and system time the process used so far
+func processCPUTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
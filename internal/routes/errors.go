@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"errors"
+	"github.com/b4o4/conference-backend/internal/websockets"
+	"log"
+	"net/http"
+)
+
+// errPeerIDRequired answers the moderation requests that don't name a peer
+var errPeerIDRequired = errors.New("peerId is required")
+
+// errorStatuses maps the websockets errors and those of the handlers to HTTP
+// statuses, checked in order so the more specific errors come first
+var errorStatuses = []struct {
+	err    error
+	status int
+}{
+	{websockets.ErrRoomNotFound, http.StatusNotFound},
+	{websockets.ErrPeerNotFound, http.StatusNotFound},
+	{websockets.ErrAnnouncementNotFound, http.StatusNotFound},
+	{websockets.ErrNotHost, http.StatusForbidden},
+	{websockets.ErrUnauthorized, http.StatusUnauthorized},
+	{websockets.ErrRoomFull, http.StatusServiceUnavailable},
+	{websockets.ErrTooManyRooms, http.StatusServiceUnavailable},
+	{websockets.ErrTooManyTracks, http.StatusServiceUnavailable},
+	{websockets.ErrRoomIDExhausted, http.StatusServiceUnavailable},
+	{websockets.ErrRoomLocked, http.StatusLocked},
+	{websockets.ErrRoomExpired, http.StatusGone},
+	{websockets.ErrAlreadyRecording, http.StatusConflict},
+	{websockets.ErrNotRecording, http.StatusConflict},
+	{websockets.ErrRoomExists, http.StatusConflict},
+	{websockets.ErrRoomNameTaken, http.StatusConflict},
+	{websockets.ErrTrackKindNotAllowed, http.StatusConflict},
+	{websockets.ErrInvalidAnnouncement, http.StatusBadRequest},
+	{websockets.ErrNoMediaAllowed, http.StatusBadRequest},
+	{websockets.ErrUnknownTemplate, http.StatusBadRequest},
+	{websockets.ErrInvalidMaxDuration, http.StatusBadRequest},
+	{websockets.ErrInvalidICEServer, http.StatusBadRequest},
+	{websockets.ErrICEServerForbidden, http.StatusBadRequest},
+	{websockets.ErrInvalidLimit, http.StatusBadRequest},
+	{websockets.ErrInvalidNotification, http.StatusBadRequest},
+	{websockets.ErrInvalidSnapshot, http.StatusBadRequest},
+	{websockets.ErrInvalidConsentPolicy, http.StatusBadRequest},
+	{errPeerIDRequired, http.StatusBadRequest},
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// httpError answers with the status matching err and its message as JSON.
+// Unexpected errors are logged and answered with 500
+func httpError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	for _, e := range errorStatuses {
+		if errors.Is(err, e.err) {
+			status = e.status
+			break
+		}
+	}
+
+	if status == http.StatusInternalServerError {
+		log.Println(err)
+	}
+
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/b4o4/conference-backend/internal/websockets"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "room not found", err: websockets.ErrRoomNotFound, want: http.StatusNotFound},
+		{name: "peer not found", err: websockets.ErrPeerNotFound, want: http.StatusNotFound},
+		{name: "announcement not found", err: websockets.ErrAnnouncementNotFound, want: http.StatusNotFound},
+		{name: "not host", err: websockets.ErrNotHost, want: http.StatusForbidden},
+		{name: "unauthorized", err: websockets.ErrUnauthorized, want: http.StatusUnauthorized},
+		{name: "room full", err: websockets.ErrRoomFull, want: http.StatusServiceUnavailable},
+		{name: "too many rooms", err: websockets.ErrTooManyRooms, want: http.StatusServiceUnavailable},
+		{name: "too many tracks", err: websockets.ErrTooManyTracks, want: http.StatusServiceUnavailable},
+		{name: "room IDs exhausted", err: websockets.ErrRoomIDExhausted, want: http.StatusServiceUnavailable},
+		{name: "room locked", err: websockets.ErrRoomLocked, want: http.StatusLocked},
+		{name: "room expired", err: websockets.ErrRoomExpired, want: http.StatusGone},
+		{name: "already recording", err: websockets.ErrAlreadyRecording, want: http.StatusConflict},
+		{name: "not recording", err: websockets.ErrNotRecording, want: http.StatusConflict},
+		{name: "room exists", err: websockets.ErrRoomExists, want: http.StatusConflict},
+		{name: "room name taken", err: websockets.ErrRoomNameTaken, want: http.StatusConflict},
+		{name: "track kind not allowed", err: websockets.ErrTrackKindNotAllowed, want: http.StatusConflict},
+		{name: "invalid announcement", err: websockets.ErrInvalidAnnouncement, want: http.StatusBadRequest},
+		{name: "no media allowed", err: websockets.ErrNoMediaAllowed, want: http.StatusBadRequest},
+		{name: "unknown template", err: websockets.ErrUnknownTemplate, want: http.StatusBadRequest},
+		{name: "invalid max duration", err: websockets.ErrInvalidMaxDuration, want: http.StatusBadRequest},
+		{name: "invalid ICE server", err: websockets.ErrInvalidICEServer, want: http.StatusBadRequest},
+		{name: "ICE server forbidden", err: websockets.ErrICEServerForbidden, want: http.StatusBadRequest},
+		{name: "invalid limit", err: websockets.ErrInvalidLimit, want: http.StatusBadRequest},
+		{name: "invalid notification", err: websockets.ErrInvalidNotification, want: http.StatusBadRequest},
+		{name: "invalid snapshot", err: websockets.ErrInvalidSnapshot, want: http.StatusBadRequest},
+		{name: "invalid consent policy", err: websockets.ErrInvalidConsentPolicy, want: http.StatusBadRequest},
+		{name: "peer ID required", err: errPeerIDRequired, want: http.StatusBadRequest},
+		{name: "wrapped", err: fmt.Errorf("room abc: %w", websockets.ErrRoomLocked), want: http.StatusLocked},
+		{name: "unexpected", err: errors.New("disk on fire"), want: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			httpError(w, tt.err)
+
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d", w.Code, tt.want)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Fatalf("got Content-Type %q, want application/json", contentType)
+			}
+
+			body := errorResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error != tt.err.Error() {
+				t.Fatalf("got error %q, want %q", body.Error, tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestHandlersAnswerJSONErrors(t *testing.T) {
+	router := newTestRouter(t)
+
+	roomUUID, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{name: "events of an unknown room", method: http.MethodGet, target: "/api/rooms/no-such-room/events", want: http.StatusNotFound},
+		{name: "join-info of an unknown room", method: http.MethodGet, target: "/api/rooms/no-such-room/join-info", want: http.StatusNotFound},
+		{name: "pause without a peer", method: http.MethodPost, target: "/api/rooms/" + roomUUID + "/pause", body: "{}", want: http.StatusBadRequest},
+		{name: "transfer-host without a peer", method: http.MethodPost, target: "/api/rooms/" + roomUUID + "/transfer-host", body: "{}", want: http.StatusBadRequest},
+		{name: "renegotiate without a peer", method: http.MethodPost, target: "/api/rooms/" + roomUUID + "/renegotiate", body: "{}", want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, tt.method, tt.target, tt.body, nil)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			body := errorResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == "" {
+				t.Fatalf("got body %q, want a JSON error", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package routes
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// baseURL is where clients reach the server, set by PUBLIC_BASE_URL or built
+// from HOST and SCHEMA. Every link handed out starts with it
+var baseURL *url.URL
+
+// parseBaseURL accepts an absolute http or https URL, optionally with the
+// path a proxy serves the server under
+func parseBaseURL(raw string) (*url.URL, error) {
+	base, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, errors.New("must be an absolute http or https URL")
+	}
+
+	if base.RawQuery != "" || base.Fragment != "" {
+		return nil, errors.New("must not have a query or fragment")
+	}
+
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawPath = ""
+
+	return base, nil
+}
+
+// link builds an absolute URL to path under the base URL
+func link(scheme, path string, query url.Values) string {
+	u := *baseURL
+	u.Scheme = scheme
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// pageURL is the link to the conference page of a room
+func pageURL(roomUUID string, query url.Values) string {
+	return link(baseURL.Scheme, "/room/"+roomUUID, query)
+}
+
+// websocketURL is the link the conference page of a room connects to
+func websocketURL(roomUUID string, query url.Values) string {
+	scheme := "ws"
+	if baseURL.Scheme == "https" {
+		scheme = "wss"
+	}
+
+	return link(scheme, "/websocket/"+roomUUID+"/join", query)
+}
@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/b4o4/conference-backend/internal/websockets"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useBaseURL makes raw the public base URL for the test
+func useBaseURL(t *testing.T, raw string) {
+	t.Helper()
+
+	base, err := parseBaseURL(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := baseURL
+	baseURL = base
+	t.Cleanup(func() { baseURL = previous })
+}
+
+func TestParseBaseURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{name: "host only", raw: "https://meet.example.com", want: "https://meet.example.com"},
+		{name: "path with a trailing slash", raw: "https://example.com/conf/", want: "https://example.com/conf"},
+		{name: "plain http with a port", raw: "http://localhost:8080", want: "http://localhost:8080"},
+		{name: "websocket scheme", raw: "wss://meet.example.com", wantErr: true},
+		{name: "relative", raw: "/conf", wantErr: true},
+		{name: "query", raw: "https://meet.example.com/?a=b", wantErr: true},
+		{name: "fragment", raw: "https://meet.example.com/#top", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBaseURL(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %s, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinksUseTheBaseURL(t *testing.T) {
+	useBaseURL(t, "https://meet.example.com/conf/")
+	router := newTestRouter(t)
+
+	roomUUID, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("join info", func(t *testing.T) {
+		w := serve(router, http.MethodGet, "/api/rooms/"+roomUUID+"/join-info", "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d, want %d", w.Code, http.StatusOK)
+		}
+
+		response := joinInfoResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if want := "https://meet.example.com/conf/room/" + roomUUID; response.JoinURL != want {
+			t.Errorf("got join URL %s, want %s", response.JoinURL, want)
+		}
+		if want := "wss://meet.example.com/conf/websocket/" + roomUUID + "/join"; response.WebsocketURL != want {
+			t.Errorf("got websocket URL %s, want %s", response.WebsocketURL, want)
+		}
+	})
+
+	t.Run("created room redirect", func(t *testing.T) {
+		w := serve(router, http.MethodGet, "/conference/create", "", nil)
+		if location := w.Header().Get("Location"); !strings.HasPrefix(location, "https://meet.example.com/conf/room/") {
+			t.Fatalf("got %d to %q, want a redirect under the base URL", w.Code, location)
+		}
+	})
+
+	t.Run("conference page", func(t *testing.T) {
+		w := serve(router, http.MethodGet, "/room/"+roomUUID+"?name=Alice", "", nil)
+
+		// The page has the URL in a script, where slashes are escaped
+		page := strings.ReplaceAll(w.Body.String(), `\/`, "/")
+		if want := "wss://meet.example.com/conf/websocket/" + roomUUID + "/join?name=Alice"; !strings.Contains(page, want) {
+			t.Fatalf("the page doesn't connect to %s", want)
+		}
+	})
+}
@@ -0,0 +1,16 @@
+package routes
+
+import "os"
+
+// The package init needs HOST and SCHEMA, package variables are
+// initialized before it runs so the defaults are set here
+var _ = setTestEnv()
+
+func setTestEnv() bool {
+	for key, value := range map[string]string{"HOST": "localhost", "SCHEMA": "http"} {
+		if _, exist := os.LookupEnv(key); !exist {
+			os.Setenv(key, value)
+		}
+	}
+	return true
+}
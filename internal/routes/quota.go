@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// creationQuota bounds the rooms each client IP creates over a sliding
+// window, nil when ROOM_CREATION_QUOTA is 0
+var creationQuota *ipQuota
+
+// trustForwardedFor takes the client IP from X-Forwarded-For, only safe
+// behind a proxy that sets it
+var trustForwardedFor bool
+
+func init() {
+	if limit := config.Int("ROOM_CREATION_QUOTA", 0); limit > 0 {
+		creationQuota = newIPQuota(limit, config.Duration("ROOM_CREATION_WINDOW", time.Hour))
+	}
+	trustForwardedFor = config.Bool("TRUST_FORWARDED_FOR", false)
+}
+
+// ipQuota is a sliding window counter per IP: the times of the last limit
+// events of each IP, those older than the window no longer count
+type ipQuota struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	events    map[string][]time.Time
+	nextSweep time.Time
+}
+
+func newIPQuota(limit int, window time.Duration) *ipQuota {
+	return &ipQuota{limit: limit, window: window, events: make(map[string][]time.Time)}
+}
+
+// take counts an event of the IP. When the IP is over its quota nothing is
+// counted and the wait until the next one is allowed is returned instead
+func (q *ipQuota) take(ip string) (time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+
+	// IPs quiet for a whole window are forgotten
+	if now.After(q.nextSweep) {
+		for key, times := range q.events {
+			if len(times) == 0 || now.Sub(times[len(times)-1]) >= q.window {
+				delete(q.events, key)
+			}
+		}
+		q.nextSweep = now.Add(q.window)
+	}
+
+	times := q.events[ip]
+	for len(times) > 0 && now.Sub(times[0]) >= q.window {
+		times = times[1:]
+	}
+
+	if len(times) >= q.limit {
+		q.events[ip] = times
+		return times[0].Add(q.window).Sub(now), false
+	}
+
+	q.events[ip] = append(times, now)
+
+	return 0, true
+}
+
+// refund takes back the last event of the IP, for a creation that failed
+func (q *ipQuota) refund(ip string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	times := q.events[ip]
+	switch len(times) {
+	case 0:
+	case 1:
+		delete(q.events, ip)
+	default:
+		q.events[ip] = times[:len(times)-1]
+	}
+}
+
+// takeCreationQuota counts a room creation against the caller's IP. When it
+// is over quota the request is answered with 429 and false is returned,
+// otherwise refund must be called if the room couldn't be created after all
+func takeCreationQuota(w http.ResponseWriter, r *http.Request) (refund func(), ok bool) {
+	if creationQuota == nil {
+		return func() {}, true
+	}
+
+	ip := clientIP(r)
+	wait, ok := creationQuota.take(ip)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Too many rooms created from this address", http.StatusTooManyRequests)
+		return nil, false
+	}
+
+	return func() { creationQuota.refund(ip) }, true
+}
+
+// clientIP returns the address of the caller. With TRUST_FORWARDED_FOR it is
+// the last X-Forwarded-For entry, the one the proxy in front of us added
+func clientIP(r *http.Request) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			entries := strings.Split(forwarded, ",")
+			return strings.TrimSpace(entries[len(entries)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
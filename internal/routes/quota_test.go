@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPQuotaTake(t *testing.T) {
+	q := newIPQuota(2, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if _, ok := q.take("10.0.0.1"); !ok {
+			t.Fatalf("creation %d refused within the quota", i+1)
+		}
+	}
+
+	wait, ok := q.take("10.0.0.1")
+	if ok {
+		t.Fatal("creation over the quota allowed")
+	}
+	if wait <= 0 || wait > time.Hour {
+		t.Errorf("wait = %s, want within the window", wait)
+	}
+
+	if _, ok := q.take("10.0.0.2"); !ok {
+		t.Error("another IP is held to the first one's quota")
+	}
+}
+
+func TestIPQuotaWindowSlides(t *testing.T) {
+	q := newIPQuota(1, time.Hour)
+	q.events["10.0.0.1"] = []time.Time{time.Now().Add(-2 * time.Hour)}
+
+	if _, ok := q.take("10.0.0.1"); !ok {
+		t.Error("creation outside the window still counted")
+	}
+}
+
+func TestIPQuotaRefund(t *testing.T) {
+	q := newIPQuota(1, time.Hour)
+
+	q.take("10.0.0.1")
+	q.refund("10.0.0.1")
+
+	if _, exist := q.events["10.0.0.1"]; exist {
+		t.Error("refunding the only creation left an entry behind")
+	}
+
+	if _, ok := q.take("10.0.0.1"); !ok {
+		t.Error("refunded creation still counted")
+	}
+}
+
+func TestIPQuotaSweepEmptyEntry(t *testing.T) {
+	q := newIPQuota(1, time.Hour)
+	q.events["10.0.0.1"] = []time.Time{}
+
+	// The sweep runs on the first take, it must not trip over the empty entry
+	if _, ok := q.take("10.0.0.2"); !ok {
+		t.Fatal("creation refused")
+	}
+
+	if _, exist := q.events["10.0.0.1"]; exist {
+		t.Error("empty entry not swept")
+	}
+}
@@ -1,87 +1,923 @@
 package routes
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/b4o4/conference-backend/internal/auth"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/b4o4/conference-backend/internal/cpuload"
 	"github.com/b4o4/conference-backend/internal/websockets"
+	"github.com/b4o4/conference-backend/templates"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
+	"github.com/pion/webrtc/v3"
+	"html/template"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
+	"runtime"
 	"strings"
-	"text/template"
+	"time"
 )
 
+// cpuSampleInterval is the window the load reported by /api/capacity is averaged over
+const cpuSampleInterval = 5 * time.Second
+
 var (
-	host          string
-	websocketType string
-	path          string
-	indexTemplate = &template.Template{}
+	joinTokenTTL time.Duration
+
+	// joinTokenSingleUse makes the tokens of join-info good for one join
+	joinTokenSingleUse bool
+
+	// creationSlots bounds the room creations in flight, nil leaves them unbounded
+	creationSlots chan struct{}
+	authenticator auth.Authenticator
+	adminKeyHash  []byte
+	pprofEnabled  bool
+	serverName    string
+	cpuSampler    *cpuload.Sampler
+	pages         *template.Template
 )
 
 func init() {
-	if err := godotenv.Load(); err != nil {
-		log.Print("No .env file found")
-	}
+	if publicBaseURL := config.String("PUBLIC_BASE_URL", ""); publicBaseURL != "" {
+		base, err := parseBaseURL(publicBaseURL)
+		if err != nil {
+			log.Fatalf("PUBLIC_BASE_URL: %v", err)
+		}
+		baseURL = base
+	} else {
+		envHost, exist := os.LookupEnv("HOST")
 
-	envHost, exist := os.LookupEnv("HOST")
+		if !exist {
+			log.Fatal("HOST not write in .env")
+		}
 
-	if !exist {
-		log.Fatal("HOST not write in .env")
-	} else {
-		host = envHost
-	}
+		envSchema, exist := os.LookupEnv("SCHEMA")
+
+		if !exist {
+			log.Fatal("SCHEMA not write in .env")
+		}
 
-	envSchema, exist := os.LookupEnv("SCHEMA")
+		baseURL = &url.URL{Scheme: "http", Host: envHost}
+		if strings.ToLower(envSchema) == "https" {
+			baseURL.Scheme = "https"
+		}
+	}
 
-	if !exist {
-		log.Fatal("SCHEMA not write in .env")
+	if adminKey := config.String("ADMIN_API_KEY", ""); adminKey != "" {
+		sum := sha256.Sum256([]byte(adminKey))
+		adminKeyHash = sum[:]
 	} else {
-		if strings.ToLower(envSchema) == "https" {
-			websocketType = "wss://"
-		} else {
-			websocketType = "ws://"
+		log.Print("ADMIN_API_KEY not write in .env, admin endpoints are disabled")
+	}
+
+	switch mode := strings.ToLower(config.String("AUTH_MODE", "none")); mode {
+	case "none":
+		authenticator = auth.NoopAuthenticator{}
+	case "jwt":
+		secret := config.String("JWT_SECRET", "")
+		if secret == "" {
+			log.Fatal("JWT_SECRET not write in .env")
 		}
+		authenticator = auth.JWTAuthenticator{Secret: []byte(secret)}
+	default:
+		log.Fatalf("AUTH_MODE must be none or jwt, got %q", mode)
 	}
 
-	pwd, err := os.Getwd()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	pprofEnabled = config.Bool("PPROF_ENABLED", false)
+	serverName = config.String("SERVER_NAME", "Conference")
+	joinTokenTTL = config.Duration("JOIN_TOKEN_TTL", 10*time.Minute)
+	joinTokenSingleUse = config.Bool("JOIN_TOKEN_SINGLE_USE", false)
+
+	if limit := config.Int("MAX_CONCURRENT_ROOM_CREATIONS", 16); limit > 0 {
+		creationSlots = make(chan struct{}, limit)
 	}
 
-	path = pwd
+	cpuSampler = cpuload.NewSampler(cpuSampleInterval)
 
+	// Pages are checked now rather than on the first request that needs them
+	var pageFS fs.FS = templates.FS
+	if dir := config.String("TEMPLATES_DIR", ""); dir != "" {
+		pageFS = os.DirFS(dir)
+	}
+
+	var err error
+	if pages, err = loadPages(pageFS); err != nil {
+		log.Fatalf("templates: %v", err)
+	}
+}
+
+// requiredPages are the templates the handlers render
+var requiredPages = []string{"index.html", "lobby.html"}
+
+// loadPages parses the HTML templates, failing with every required page
+// that is missing rather than only the first
+func loadPages(fsys fs.FS) (*template.Template, error) {
+	var missing []string
+	for _, name := range requiredPages {
+		if _, err := fs.Stat(fsys, name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+
+	return template.ParseFS(fsys, "*.html")
 }
 
+// NewRouter serves the public and the management endpoints together
 func NewRouter() http.Handler {
 	router := mux.NewRouter()
+	addPublicRoutes(router)
+	addAdminRoutes(router)
 
+	return router
+}
+
+// NewPublicRouter serves only what clients use, the management endpoints are
+// left to NewAdminRouter on a listener of their own
+func NewPublicRouter() http.Handler {
+	router := mux.NewRouter()
+	addPublicRoutes(router)
+
+	return router
+}
+
+// NewAdminRouter serves the admin, metrics and pprof endpoints
+func NewAdminRouter() http.Handler {
+	router := mux.NewRouter()
+	addAdminRoutes(router)
+
+	return router
+}
+
+func addPublicRoutes(router *mux.Router) {
 	router.HandleFunc("/room/{uuid}", indexHandler)
-	router.HandleFunc("/websocket/{uuid}/join", websockets.Handler)
+	router.Handle("/websocket/{uuid}/join", auth.Middleware(authenticator)(auth.SingleUse(http.HandlerFunc(websockets.Handler))))
+	router.Handle("/websocket/multiplex", auth.Middleware(authenticator)(auth.SingleUse(http.HandlerFunc(websockets.MultiplexHandler))))
 	router.HandleFunc("/", conferenceHandler)
 	router.HandleFunc("/conference/create", createConferenceHandler)
+	router.HandleFunc("/healthz", healthzHandler).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", readyzHandler).Methods(http.MethodGet)
 
-	return router
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(auth.Middleware(authenticator), gzipResponses)
+	api.HandleFunc("/capacity", capacityHandler).Methods(http.MethodGet)
+	api.HandleFunc("/rooms", createRoomHandler).Methods(http.MethodPost)
+	api.HandleFunc("/rooms/{uuid}/exists", roomExistsHandler).Methods(http.MethodGet)
+
+	// Banners are pushed by operators, to rooms of any tenant
+	api.Handle("/rooms/{uuid}/notify", adminOnly(http.HandlerFunc(notifyRoomHandler))).Methods(http.MethodPost)
+	api.Handle("/broadcast", adminOnly(http.HandlerFunc(broadcastHandler))).Methods(http.MethodPost)
+
+	// The rooms of other tenants answer as unknown ones, exists does it itself
+	room := api.PathPrefix("/rooms/{uuid}").Subrouter()
+	room.Use(tenantScoped)
+	room.HandleFunc("", renameRoomHandler).Methods(http.MethodPatch)
+	room.HandleFunc("/join-info", joinInfoHandler).Methods(http.MethodGet)
+	room.HandleFunc("/events", roomEventsHandler).Methods(http.MethodGet)
+	room.HandleFunc("/participants", participantsHandler).Methods(http.MethodGet)
+	room.HandleFunc("/stats", roomStatsHandler).Methods(http.MethodGet)
+	room.HandleFunc("/stats/history", roomStatsHistoryHandler).Methods(http.MethodGet)
+	room.HandleFunc("/tracks", tracksHandler).Methods(http.MethodGet)
+	room.HandleFunc("/hands", handsHandler).Methods(http.MethodGet)
+	room.HandleFunc("/hands", clearHandsHandler).Methods(http.MethodDelete)
+	room.HandleFunc("/lock", lockRoomHandler(true)).Methods(http.MethodPost)
+	room.HandleFunc("/unlock", lockRoomHandler(false)).Methods(http.MethodPost)
+	room.HandleFunc("/pause", pausePublisherHandler(true)).Methods(http.MethodPost)
+	room.HandleFunc("/resume", pausePublisherHandler(false)).Methods(http.MethodPost)
+	room.HandleFunc("/transfer-host", transferHostHandler).Methods(http.MethodPost)
+	room.HandleFunc("/renegotiate", renegotiateHandler).Methods(http.MethodPost)
+	room.HandleFunc("/announce", announceHandler).Methods(http.MethodPost)
+	room.HandleFunc("/record", recordHandler(websockets.StartRecording)).Methods(http.MethodPost)
+	room.HandleFunc("/record", recordHandler(websockets.StopRecording)).Methods(http.MethodDelete)
+	room.HandleFunc("/recording/consent", recordingConsentHandler).Methods(http.MethodGet)
+	room.HandleFunc("/recording/consent", consentPolicyHandler).Methods(http.MethodPut)
+	room.HandleFunc("/snapshot", snapshotHandler).Methods(http.MethodGet)
 }
 
-func conferenceHandler(w http.ResponseWriter, r *http.Request) {
+func addAdminRoutes(router *mux.Router) {
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(auth.Middleware(authenticator), adminOnly)
+	admin.HandleFunc("/drain", drainHandler).Methods(http.MethodPost)
+	admin.HandleFunc("/undrain", undrainHandler).Methods(http.MethodPost)
+	admin.HandleFunc("/debug/stats", debugStatsHandler).Methods(http.MethodGet)
+	admin.HandleFunc("/config", configHandler).Methods(http.MethodGet)
+	admin.HandleFunc("/metrics", metricsHandler).Methods(http.MethodGet)
+	admin.HandleFunc("/rooms/restore", restoreRoomHandler).Methods(http.MethodPost)
+
+	// Profiles expose internals and cost CPU, so they stay off unless asked for
+	// and need the admin key like the other management endpoints
+	if pprofEnabled {
+		debug := router.PathPrefix("/debug/pprof").Subrouter()
+		debug.Use(adminOnly)
+		debug.HandleFunc("/cmdline", pprof.Cmdline)
+		debug.HandleFunc("/profile", pprof.Profile)
+		debug.HandleFunc("/symbol", pprof.Symbol)
+		debug.HandleFunc("/trace", pprof.Trace)
+		debug.PathPrefix("/").HandlerFunc(pprof.Index)
+	}
+}
+
+// tenantScoped answers 404 for the rooms of another tenant, as if they didn't
+// exist, before any handler looks at them
+func tenantScoped(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if roomUUID, ok := mux.Vars(r)["uuid"]; ok && websockets.ForeignRoom(roomUUID, websockets.Tenant(r)) {
+			httpError(w, websockets.ErrRoomNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminOnly guards management endpoints with the shared ADMIN_API_KEY passed
+// in X-Admin-Key. Hashes are compared so neither content nor length leaks
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.Header.Get("X-Admin-Key")))
+
+		if adminKeyHash == nil || subtle.ConstantTimeCompare(sum[:], adminKeyHash) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquireCreationSlot takes one of the room creation slots without waiting.
+// The caller must call release once done if it got one
+func acquireCreationSlot() (release func(), ok bool) {
+	if creationSlots == nil {
+		return func() {}, true
+	}
+
+	select {
+	case creationSlots <- struct{}{}:
+		return func() { <-creationSlots }, true
+	default:
+		return nil, false
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+type createRoomResponse struct {
+	UUID    string `json:"uuid"`
+	HostKey string `json:"hostKey"`
+}
+
+// createRoomHandler creates a room from the JSON config in the body, omitted options keep their defaults
+func createRoomHandler(w http.ResponseWriter, r *http.Request) {
+	// ?validate=true checks the config and returns it normalized, without creating the room
+	if r.URL.Query().Get("validate") == "true" {
+		validateRoomConfig(w, r)
+		return
+	}
+
+	if websockets.IsDraining() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	release, ok := acquireCreationSlot()
+	if !ok {
+		http.Error(w, "Too many rooms being created", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	roomConfig, err := decodeRoomConfig(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	refund, ok := takeCreationQuota(w, r)
+	if !ok {
+		return
+	}
+
+	roomUUID, hostKey, err := websockets.AddRoomUUID(websockets.Tenant(r), roomConfig)
+	if err != nil {
+		refund()
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, createRoomResponse{UUID: roomUUID, HostKey: hostKey})
+}
+
+// validateRoomConfig answers with the config a room would be created with,
+// or the reason it would be refused
+func validateRoomConfig(w http.ResponseWriter, r *http.Request) {
+	roomConfig, err := decodeRoomConfig(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if roomConfig, err = websockets.NormalizeRoomConfig(roomConfig); err != nil {
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, roomConfig)
+}
+
+// decodeRoomConfig builds the room config from the request body: the named
+// template, or the defaults, with the options given in the body on top
+func decodeRoomConfig(r *http.Request) (websockets.RoomConfig, error) {
+	roomConfig := websockets.DefaultRoomConfig()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil || len(body) == 0 {
+		return roomConfig, err
+	}
+
+	var request struct {
+		Template string `json:"template"`
+	}
+	if err = json.Unmarshal(body, &request); err != nil {
+		return roomConfig, err
+	}
+
+	if request.Template != "" {
+		if roomConfig, err = websockets.RoomTemplate(request.Template); err != nil {
+			return roomConfig, err
+		}
+	}
+
+	err = json.Unmarshal(body, &roomConfig)
+	return roomConfig, err
+}
+
+type roomExistsResponse struct {
+	Exists       bool `json:"exists"`
+	Participants *int `json:"participants,omitempty"`
+}
+
+func roomExistsHandler(w http.ResponseWriter, r *http.Request) {
+	roomUUID := mux.Vars(r)["uuid"]
+
+	participants, ok := websockets.RoomParticipants(roomUUID)
+	if !ok || websockets.ForeignRoom(roomUUID, websockets.Tenant(r)) {
+		writeJSON(w, http.StatusOK, roomExistsResponse{})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, roomExistsResponse{Exists: true, Participants: &participants})
+}
+
+type joinInfoResponse struct {
+	RoomUUID       string             `json:"roomUuid"`
+	Token          string             `json:"token,omitempty"`
+	TokenExpiresAt *time.Time         `json:"tokenExpiresAt,omitempty"`
+	ICEServers     []webrtc.ICEServer `json:"iceServers"`
+	JoinURL        string             `json:"joinUrl"`
+	WebsocketURL   string             `json:"websocketUrl"`
+}
+
+// joinInfoHandler returns everything a client needs to join the room in one
+// call. The token is issued for the caller when the auth mode can mint one
+func joinInfoHandler(w http.ResponseWriter, r *http.Request) {
+	roomUUID := mux.Vars(r)["uuid"]
+
+	if err := websockets.CheckJoinable(roomUUID); err != nil {
+		httpError(w, err)
+		return
+	}
+
+	servers, ok := websockets.RoomICEServers(roomUUID)
+	if !ok {
+		httpError(w, websockets.ErrRoomNotFound)
+		return
+	}
+
+	response := joinInfoResponse{
+		RoomUUID:   roomUUID,
+		ICEServers: servers,
+	}
+
+	query := url.Values{}
+	if issuer, ok := authenticator.(auth.TokenIssuer); ok {
+		identity, _ := auth.FromContext(r.Context())
+		identity.SingleUse = joinTokenSingleUse
+		identity.Room = roomUUID
+
+		token, expiresAt, err := issuer.Issue(identity, joinTokenTTL)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Can't issue token", http.StatusInternalServerError)
+			return
+		}
+
+		response.Token, response.TokenExpiresAt = token, &expiresAt
+		query.Set("token", token)
+	}
+
+	response.JoinURL = pageURL(roomUUID, query)
+	response.WebsocketURL = websocketURL(roomUUID, query)
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func participantsHandler(w http.ResponseWriter, r *http.Request) {
+	participants, err := websockets.ListParticipants(mux.Vars(r)["uuid"])
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, participants)
+}
+
+// tracksHandler lists what the room forwards, for debugging missing media
+func tracksHandler(w http.ResponseWriter, r *http.Request) {
+	tracks, err := websockets.ListTracks(mux.Vars(r)["uuid"])
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tracks)
+}
+
+func roomStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := websockets.GetRoomStats(mux.Vars(r)["uuid"])
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// roomStatsHistoryHandler returns the samples of the room taken every STATS_SAMPLE_INTERVAL
+func roomStatsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	history, err := websockets.GetRoomStatsHistory(mux.Vars(r)["uuid"])
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+func roomEventsHandler(w http.ResponseWriter, r *http.Request) {
+	events, ok := websockets.RoomEvents(mux.Vars(r)["uuid"])
+	if !ok {
+		httpError(w, websockets.ErrRoomNotFound)
+		return
+	}
 
-	lobbyHTML, _ := os.ReadFile(path + "/templates/lobby.html")
+	writeJSON(w, http.StatusOK, events)
+}
+
+// lockRoomHandler locks or unlocks the room, the caller proves host rights with X-Host-Key
+func lockRoomHandler(locked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := websockets.SetRoomLocked(mux.Vars(r)["uuid"], r.Header.Get("X-Host-Key"), locked)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// pausePublisherHandler stops or restarts forwarding the media of the peer
+// given in the body, the caller proves host rights with X-Host-Key
+func pausePublisherHandler(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			PeerID string `json:"peerId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PeerID == "" {
+			httpError(w, errPeerIDRequired)
+			return
+		}
+
+		err := websockets.SetPublisherPaused(mux.Vars(r)["uuid"], r.Header.Get("X-Host-Key"), request.PeerID, paused)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handsHandler lists the raised hands of the room, oldest first
+func handsHandler(w http.ResponseWriter, r *http.Request) {
+	hands, err := websockets.ListHands(mux.Vars(r)["uuid"])
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, hands)
+}
+
+// clearHandsHandler lowers every hand, the caller proves host rights with X-Host-Key
+func clearHandsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := websockets.ClearHands(mux.Vars(r)["uuid"], r.Header.Get("X-Host-Key")); err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// transferHostHandler makes another participant the host, the caller proves
+// host rights with X-Host-Key
+func transferHostHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PeerID string `json:"peerId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PeerID == "" {
+		httpError(w, errPeerIDRequired)
+		return
+	}
+
+	err := websockets.TransferHost(mux.Vars(r)["uuid"], r.Header.Get("X-Host-Key"), request.PeerID)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// renegotiateHandler sends a fresh offer to one participant whose tracks are
+// out of sync, the caller proves host rights with X-Host-Key
+func renegotiateHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PeerID string `json:"peerId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PeerID == "" {
+		httpError(w, errPeerIDRequired)
+		return
+	}
+
+	err := websockets.Renegotiate(mux.Vars(r)["uuid"], r.Header.Get("X-Host-Key"), request.PeerID)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// announceHandler plays one of the ANNOUNCEMENTS_DIR files into the room, the
+// caller proves host rights with X-Host-Key
+func announceHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	trackID, err := websockets.Announce(mux.Vars(r)["uuid"], r.Header.Get("X-Host-Key"), request.Name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, struct {
+		TrackID string `json:"trackId"`
+	}{trackID})
+}
+
+// recordHandler starts or stops the room recording and returns its manifest,
+// the caller proves host rights with X-Host-Key
+func recordHandler(action func(roomUUID, hostKey string) (websockets.RecordingManifest, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		manifest, err := action(mux.Vars(r)["uuid"], r.Header.Get("X-Host-Key"))
+
+		// The recording starts by itself once the participants consent
+		if errors.Is(err, websockets.ErrAwaitingConsent) {
+			writeJSON(w, http.StatusAccepted, errorResponse{Error: err.Error()})
+			return
+		}
+
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, manifest)
+	}
+}
+
+// recordingConsentHandler tells whether the room may be recorded and who agreed
+func recordingConsentHandler(w http.ResponseWriter, r *http.Request) {
+	consent, err := websockets.GetRecordingConsent(mux.Vars(r)["uuid"])
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, consent)
+}
+
+// consentPolicyHandler sets the consent policy of the room, the caller proves
+// host rights with X-Host-Key
+func consentPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Policy string `json:"policy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "policy is required", http.StatusBadRequest)
+		return
+	}
+
+	err := websockets.SetConsentPolicy(mux.Vars(r)["uuid"], r.Header.Get("X-Host-Key"), request.Policy)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// renameRoomHandler changes the room name, the caller proves host rights with X-Host-Key
+func renameRoomHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name *string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Name == nil {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	err := websockets.RenameRoom(mux.Vars(r)["uuid"], r.Header.Get("X-Host-Key"), *request.Name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
-	tmp, _ := template.New("").Parse(string(lobbyHTML))
+	if websockets.IsDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("draining"))
+		return
+	}
+
+	_, _ = w.Write([]byte("ok"))
+}
+
+type readyzResponse struct {
+	Ready      bool                         `json:"ready"`
+	Draining   bool                         `json:"draining"`
+	ICEServers []websockets.ICEServerStatus `json:"iceServers,omitempty"`
+}
+
+// readyzHandler is the deeper check of healthz: with READYZ_CHECK_ICE the
+// STUN and TURN servers are probed too, an unreachable TURN server answers 503
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	servers, reachable := websockets.ICEReadiness()
+	response := readyzResponse{
+		Ready:      reachable && !websockets.IsDraining(),
+		Draining:   websockets.IsDraining(),
+		ICEServers: servers,
+	}
+
+	status := http.StatusOK
+	if !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, response)
+}
+
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+	websockets.SetDraining(true)
+	log.Print("Draining: new rooms and joins are rejected")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func undrainHandler(w http.ResponseWriter, r *http.Request) {
+	websockets.SetDraining(false)
+	log.Print("Drain mode disabled")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notifyRoomHandler pushes a banner to the participants of one room
+func notifyRoomHandler(w http.ResponseWriter, r *http.Request) {
+	var notification websockets.Notification
+	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+		http.Error(w, "Invalid notification", http.StatusBadRequest)
+		return
+	}
+
+	if err := websockets.NotifyRoom(mux.Vars(r)["uuid"], notification); err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// broadcastHandler pushes a banner to the participants of every room
+func broadcastHandler(w http.ResponseWriter, r *http.Request) {
+	var notification websockets.Notification
+	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+		http.Error(w, "Invalid notification", http.StatusBadRequest)
+		return
+	}
+
+	rooms, err := websockets.NotifyAll(notification)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Rooms int `json:"rooms"`
+	}{rooms})
+}
+
+// snapshotHandler exports the shell of the room for a standby server, the
+// caller proves host rights with X-Host-Key
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := websockets.SnapshotRoom(mux.Vars(r)["uuid"], r.Header.Get("X-Host-Key"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// restoreRoomHandler recreates a room from a snapshot taken on another server,
+// clients then reconnect with the links they already have
+func restoreRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if websockets.IsDraining() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	var snapshot websockets.RoomSnapshot
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		http.Error(w, "Invalid snapshot", http.StatusBadRequest)
+		return
+	}
 
-	if err := tmp.Execute(w, "Conference - Lobby"); err != nil {
-		log.Fatal(err)
+	if err := websockets.RestoreRoom(snapshot); err != nil {
+		httpError(w, err)
+		return
 	}
 
+	writeJSON(w, http.StatusCreated, createRoomResponse{UUID: snapshot.UUID, HostKey: snapshot.HostKey})
+}
+
+type memoryStats struct {
+	Alloc        uint64 `json:"alloc"`
+	TotalAlloc   uint64 `json:"totalAlloc"`
+	Sys          uint64 `json:"sys"`
+	HeapInuse    uint64 `json:"heapInuse"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	NumGC        uint32 `json:"numGC"`
+	PauseTotalNs uint64 `json:"pauseTotalNs"`
+}
+
+type debugStatsResponse struct {
+	Goroutines int         `json:"goroutines"`
+	Memory     memoryStats `json:"memory"`
+	websockets.ServerStats
+}
+
+// configHandler returns the settings the server runs with, secrets redacted,
+// so support can check them without shell access
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, config.Effective())
+}
+
+// debugStatsHandler returns a runtime snapshot, cheap enough to poll for goroutine leaks
+func debugStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	writeJSON(w, http.StatusOK, debugStatsResponse{
+		Goroutines: runtime.NumGoroutine(),
+		Memory: memoryStats{
+			Alloc:        m.Alloc,
+			TotalAlloc:   m.TotalAlloc,
+			Sys:          m.Sys,
+			HeapInuse:    m.HeapInuse,
+			HeapObjects:  m.HeapObjects,
+			NumGC:        m.NumGC,
+			PauseTotalNs: m.PauseTotalNs,
+		},
+		ServerStats: websockets.GetServerStats(),
+	})
+}
+
+type capacityResponse struct {
+	Rooms           int     `json:"rooms"`
+	Peers           int     `json:"peers"`
+	MaxRooms        int     `json:"maxRooms"`
+	MaxParticipants int     `json:"maxParticipants"`
+	CPULoad         float64 `json:"cpuLoad"`
+}
+
+// capacityHandler reports the load of the instance so clients or DNS can pick
+// the least loaded one. Limits of 0 are unlimited, cpuLoad goes from 0 to 1
+func capacityHandler(w http.ResponseWriter, r *http.Request) {
+	stats := websockets.GetServerStats()
+	limits := websockets.GetLimits()
+
+	writeJSON(w, http.StatusOK, capacityResponse{
+		Rooms:           stats.Rooms,
+		Peers:           stats.Peers,
+		MaxRooms:        limits.MaxRooms,
+		MaxParticipants: limits.MaxParticipants,
+		CPULoad:         cpuSampler.Load(),
+	})
+}
+
+// metricsHandler serves the metrics for Prometheus, which must send X-Admin-Key
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	if err := websockets.WriteMetrics(w); err != nil {
+		log.Println(err)
+	}
+}
+
+// LobbyData is what the lobby page is rendered with
+type LobbyData struct {
+	ServerName string
+	Rooms      int
+	Peers      int
+}
+
+func conferenceHandler(w http.ResponseWriter, r *http.Request) {
+	stats := websockets.GetServerStats()
+
+	renderPage(w, "lobby.html", LobbyData{
+		ServerName: serverName,
+		Rooms:      stats.Rooms,
+		Peers:      stats.Peers,
+	})
 }
 
 func createConferenceHandler(w http.ResponseWriter, r *http.Request) {
+	if websockets.IsDraining() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
 
-	roomUUID := websockets.AddRoomUUID()
+	release, ok := acquireCreationSlot()
+	if !ok {
+		http.Error(w, "Too many rooms being created", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
 
-	http.Redirect(w, r, "/room/"+roomUUID, 302)
+	refund, ok := takeCreationQuota(w, r)
+	if !ok {
+		return
+	}
+
+	roomUUID, hostKey, err := websockets.AddRoomUUID(websockets.Tenant(r), websockets.DefaultRoomConfig())
+	if err != nil {
+		refund()
+		httpError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, pageURL(roomUUID, url.Values{"host": {hostKey}}), 302)
 }
 
 func indexHandler(w http.ResponseWriter, r *http.Request) {
@@ -92,14 +928,29 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Идентификатор комнаты отсутствует")
 	}
 
-	indexHTML, err := os.ReadFile(path + "/templates/index.html")
-	if err != nil {
-		panic(err)
+	// Pass the host key, display name, auth token and metadata given to the page on to the websocket
+	query := url.Values{}
+	for _, key := range []string{"host", "name", "token", "metadata"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			query.Set(key, value)
+		}
 	}
 
-	indexTemplate = template.Must(template.New("").Parse(string(indexHTML)))
+	renderPage(w, "index.html", websocketURL(roomUUID, query))
+}
+
+// renderPage writes the page, or a 500 if its template fails. The page is
+// rendered in full first so a failure can't leave half of it sent
+func renderPage(w http.ResponseWriter, name string, data interface{}) {
+	page := &bytes.Buffer{}
+	if err := pages.ExecuteTemplate(page, name, data); err != nil {
+		log.Printf("render %s: %v", name, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 
-	if err := indexTemplate.Execute(w, websocketType+host+"/websocket/"+roomUUID+"/join"); err != nil {
-		log.Fatal(err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := page.WriteTo(w); err != nil {
+		log.Println(err)
 	}
 }
@@ -0,0 +1,1038 @@
+package routes
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/b4o4/conference-backend/internal/auth"
+	"github.com/b4o4/conference-backend/internal/cpuload"
+	"github.com/b4o4/conference-backend/internal/websockets"
+	"github.com/b4o4/conference-backend/templates"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const testAdminKey = "test-admin-key"
+
+// newTestRouter serves every route with testAdminKey as the admin key
+func newTestRouter(t *testing.T) http.Handler {
+	t.Helper()
+
+	useTestAdminKey(t)
+	return NewRouter()
+}
+
+// useTestAdminKey makes testAdminKey the admin key for the test
+func useTestAdminKey(t *testing.T) {
+	t.Helper()
+
+	previous := adminKeyHash
+	sum := sha256.Sum256([]byte(testAdminKey))
+	adminKeyHash = sum[:]
+	t.Cleanup(func() { adminKeyHash = previous })
+}
+
+// useJWT switches the router to JWT authentication for the test
+func useJWT(t *testing.T) auth.JWTAuthenticator {
+	t.Helper()
+
+	previous := authenticator
+	jwtAuth := auth.JWTAuthenticator{Secret: []byte("test-secret")}
+	authenticator = jwtAuth
+	t.Cleanup(func() { authenticator = previous })
+
+	return jwtAuth
+}
+
+// bearer is the header of a request authenticated with a fresh token
+func bearer(t *testing.T, issuer auth.TokenIssuer, identity auth.Identity) http.Header {
+	t.Helper()
+
+	token, _, err := issuer.Issue(identity, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// serve runs one request against the router and returns the response
+func serve(router http.Handler, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for key, values := range header {
+		r.Header[key] = values
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// signalMessage is a message of the signaling websocket
+type signalMessage struct {
+	Event string `json:"event"`
+	Data  string `json:"data"`
+}
+
+// websocketTarget is the ws:// URL of path on srv
+func websocketTarget(srv *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + path
+}
+
+// nextSignal skips messages until one of the event arrives
+func nextSignal(t *testing.T, ws *websocket.Conn, event string) signalMessage {
+	t.Helper()
+
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer ws.SetReadDeadline(time.Time{}) //nolint
+
+	for {
+		message := signalMessage{}
+		if err := ws.ReadJSON(&message); err != nil {
+			t.Fatalf("waiting for %s: %v", event, err)
+		}
+		if message.Event == event {
+			return message
+		}
+	}
+}
+
+// dialJoin joins roomUUID on the websocket of srv, with the query of the join
+func dialJoin(t *testing.T, srv *httptest.Server, roomUUID string, query url.Values) *websocket.Conn {
+	t.Helper()
+
+	ws, _, err := websocket.DefaultDialer.Dial(websocketTarget(srv, "/websocket/"+roomUUID+"/join?"+query.Encode()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ws.Close() })
+
+	nextSignal(t, ws, "welcome")
+	return ws
+}
+
+// waitForParticipants waits until the room holds n peers
+func waitForParticipants(t *testing.T, roomUUID string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		participants, _ := websockets.RoomParticipants(roomUUID)
+		if participants == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d participants, want %d", participants, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// answerSignal answers the offer of the server the way a browser would
+func answerSignal(t *testing.T, ws *websocket.Conn, offer signalMessage) {
+	t.Helper()
+
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	description := webrtc.SessionDescription{}
+	if err := json.Unmarshal([]byte(offer.Data), &description); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetRemoteDescription(description); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := client.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteJSON(signalMessage{Event: "answer", Data: string(data)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	router := newTestRouter(t)
+	t.Cleanup(func() { websockets.SetDraining(false) })
+	admin := http.Header{"X-Admin-Key": {testAdminKey}}
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	roomUUID, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	joinTarget := websocketTarget(srv, "/websocket/"+roomUUID+"/join")
+
+	// A peer connected before the drain stays connected through it
+	connected, _, err := websocket.DefaultDialer.Dial(joinTarget, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connected.Close()
+	nextSignal(t, connected, "welcome")
+
+	if w := serve(router, http.MethodPost, "/admin/drain", "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("drain without the admin key: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := serve(router, http.MethodGet, "/healthz", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("healthz before draining: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w := serve(router, http.MethodPost, "/admin/drain", "", admin); w.Code != http.StatusNoContent {
+		t.Fatalf("drain: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serve(router, http.MethodGet, "/healthz", "", nil); w.Code != http.StatusServiceUnavailable || w.Body.String() != "draining" {
+		t.Fatalf("healthz while draining: got %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(router, http.MethodPost, "/api/rooms", "{}", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("room creation while draining: got %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	if _, resp, err := websocket.DefaultDialer.Dial(joinTarget, nil); err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("join while draining: got %v %v, want %d", resp, err, http.StatusServiceUnavailable)
+	}
+
+	// The connected peer is still offered its session and its messages handled
+	answerSignal(t, connected, nextSignal(t, connected, "offer"))
+	if err := connected.WriteJSON(signalMessage{Event: "refresh", Data: `"no-such-track"`}); err != nil {
+		t.Fatal(err)
+	}
+	if message := nextSignal(t, connected, "error"); !strings.Contains(message.Data, "unknown track") {
+		t.Fatalf("got %+v, want the refresh answered with unknown track", message)
+	}
+	if participants, ok := websockets.RoomParticipants(roomUUID); !ok || participants != 1 {
+		t.Fatalf("got %d participants, want the peer connected before the drain", participants)
+	}
+
+	if w := serve(router, http.MethodPost, "/admin/undrain", "", admin); w.Code != http.StatusNoContent {
+		t.Fatalf("undrain: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serve(router, http.MethodGet, "/healthz", "", nil); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Fatalf("healthz after undrain: got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestJoinInfoTokenIsBoundToTheRoom(t *testing.T) {
+	jwtAuth := useJWT(t)
+	router := newTestRouter(t)
+
+	roomUUID, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(router, http.MethodGet, "/api/rooms/"+roomUUID+"/join-info", "", bearer(t, jwtAuth, auth.Identity{Subject: "user-1"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+
+	response := joinInfoResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := jwtAuth.Authenticate(httptest.NewRequest(http.MethodGet, "/?token="+response.Token, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Room != roomUUID || identity.Subject != "user-1" {
+		t.Fatalf("got identity %+v, want user-1 bound to %s", identity, roomUUID)
+	}
+}
+
+func TestCreateRoomRefusesUnknownICEServer(t *testing.T) {
+	router := newTestRouter(t)
+
+	body := `{"allowAudio": true, "allowVideo": true, "iceServers": [{"urls": ["turn:attacker.example:3478"], "username": "u", "credential": "c"}]}`
+	w := serve(router, http.MethodPost, "/api/rooms", body, nil)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "attacker.example") {
+		t.Fatalf("got %d %s, want 400 naming the server", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateRoomRefusesUnknownTemplate(t *testing.T) {
+	router := newTestRouter(t)
+
+	for _, target := range []string{"/api/rooms", "/api/rooms?validate=true"} {
+		w := serve(router, http.MethodPost, target, `{"template": "concert"}`, nil)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "concert") {
+			t.Fatalf("%s: got %d %s, want 400 naming the template", target, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestValidateRoomConfig(t *testing.T) {
+	router := newTestRouter(t)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "valid config is normalized",
+			body:     `{"name": "  Planning  ", "allowAudio": true, "allowVideo": false}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "no media",
+			body:     `{"allowAudio": false, "allowVideo": false}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: websockets.ErrNoMediaAllowed.Error(),
+		},
+		{
+			name:     "negative limit",
+			body:     `{"maxParticipants": -1}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: websockets.ErrInvalidLimit.Error(),
+		},
+		{
+			name:     "bad JSON",
+			body:     `{"name": `,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rooms := websockets.GetServerStats().Rooms
+
+			w := serve(router, http.MethodPost, "/api/rooms?validate=true", tt.body, nil)
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Fatalf("got %d %s, want %d with %q", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+			if got := websockets.GetServerStats().Rooms; got != rooms {
+				t.Fatalf("validating changed the rooms from %d to %d", rooms, got)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			config := websockets.RoomConfig{}
+			if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+				t.Fatal(err)
+			}
+			if config.Name != "Planning" || !config.AllowAudio || config.AllowVideo {
+				t.Fatalf("got config %+v, want the normalized audio only Planning", config)
+			}
+		})
+	}
+}
+
+func TestPages(t *testing.T) {
+	router := newTestRouter(t)
+
+	for _, target := range []string{"/", "/room/some-room"} {
+		w := serve(router, http.MethodGet, target, "", nil)
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%s: got %d %q, want 200 text/html", target, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestPagesOutsideTheSourceTree(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Parsed anew from the embedded files, with no templates directory around
+	loaded, err := loadPages(templates.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := pages
+	pages = loaded
+	t.Cleanup(func() { pages = previous })
+
+	router := newTestRouter(t)
+	for _, target := range []string{"/", "/room/some-room"} {
+		w := serve(router, http.MethodGet, target, "", nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<html") {
+			t.Errorf("%s: got %d, want 200 with the page", target, w.Code)
+		}
+	}
+}
+
+func TestPageTemplateFailure(t *testing.T) {
+	router := newTestRouter(t)
+
+	previous := pages
+	pages = template.Must(template.New("lobby.html").Parse(`<h1>partial</h1>{{.Missing}}`))
+	t.Cleanup(func() { pages = previous })
+
+	w := serve(router, http.MethodGet, "/", "", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(w.Body.String(), "partial") {
+		t.Fatalf("half of the page was sent: %q", w.Body.String())
+	}
+}
+
+func TestNotifyRoutes(t *testing.T) {
+	router := newTestRouter(t)
+	admin := http.Header{"X-Admin-Key": {testAdminKey}}
+	banner := `{"level":"info","message":"recording starts soon"}`
+
+	roomUUID, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		want   int
+	}{
+		{name: "room without the admin key", target: "/api/rooms/" + roomUUID + "/notify", want: http.StatusUnauthorized},
+		{name: "room", target: "/api/rooms/" + roomUUID + "/notify", header: admin, want: http.StatusNoContent},
+		{name: "unknown room", target: "/api/rooms/no-such-room/notify", header: admin, want: http.StatusNotFound},
+		{name: "broadcast without the admin key", target: "/api/broadcast", want: http.StatusUnauthorized},
+		{name: "broadcast", target: "/api/broadcast", header: admin, want: http.StatusOK},
+		{name: "old room path", target: "/admin/rooms/" + roomUUID + "/notify", header: admin, want: http.StatusNotFound},
+		{name: "old broadcast path", target: "/admin/broadcast", header: admin, want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(router, http.MethodPost, tt.target, banner, tt.header); w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	w := serve(router, http.MethodPost, "/api/broadcast", banner, admin)
+	reply := struct {
+		Rooms *int `json:"rooms"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil || reply.Rooms == nil {
+		t.Fatalf("broadcast reply %q has no room count", w.Body)
+	}
+}
+
+func TestRoomExists(t *testing.T) {
+	router := newTestRouter(t)
+
+	roomUUID, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		roomUUID         string
+		wantExists       bool
+		wantParticipants bool
+	}{
+		{name: "existing room", roomUUID: roomUUID, wantExists: true, wantParticipants: true},
+		{name: "unknown room", roomUUID: "no-such-room"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, http.MethodGet, "/api/rooms/"+tt.roomUUID+"/exists", "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want %d", w.Code, http.StatusOK)
+			}
+
+			response := roomExistsResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Exists != tt.wantExists {
+				t.Errorf("got exists %v, want %v", response.Exists, tt.wantExists)
+			}
+			if got := response.Participants != nil; got != tt.wantParticipants {
+				t.Errorf("got participants %v, want them given %v", response.Participants, tt.wantParticipants)
+			} else if got && *response.Participants != 0 {
+				t.Errorf("got %d participants in an empty room", *response.Participants)
+			}
+		})
+	}
+}
+
+func TestSnapshotAndRestoreRoutes(t *testing.T) {
+	router := newTestRouter(t)
+
+	roomUUID, hostKey, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(router, http.MethodGet, "/api/rooms/"+roomUUID+"/snapshot", "", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("snapshot without the host key got %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w := serve(router, http.MethodGet, "/api/rooms/"+roomUUID+"/snapshot", "", http.Header{"X-Host-Key": {hostKey}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("snapshot got %d, want %d", w.Code, http.StatusOK)
+	}
+	snapshot := websockets.RoomSnapshot{}
+	if err := json.Unmarshal(w.Body.Bytes(), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := http.Header{"X-Admin-Key": {testAdminKey}}
+	restore := func(snapshot websockets.RoomSnapshot, header http.Header) *httptest.ResponseRecorder {
+		body, err := json.Marshal(snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serve(router, http.MethodPost, "/admin/rooms/restore", string(body), header)
+	}
+
+	if w := restore(snapshot, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("restore without the admin key got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := restore(snapshot, admin); w.Code != http.StatusConflict {
+		t.Fatalf("restoring over the room got %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	snapshot.UUID = uuid.NewString()
+	w = restore(snapshot, admin)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("restore got %d %s, want %d", w.Code, w.Body.String(), http.StatusCreated)
+	}
+	response := createRoomResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.UUID != snapshot.UUID || response.HostKey != hostKey {
+		t.Fatalf("got %+v, want the room %s with the old host key", response, snapshot.UUID)
+	}
+
+	snapshot.UUID = uuid.NewString()
+	snapshot.Expired = true
+	if w := restore(snapshot, admin); w.Code != http.StatusGone {
+		t.Fatalf("restoring an expired room got %d, want %d", w.Code, http.StatusGone)
+	}
+}
+
+func TestRoomsOfOtherTenants(t *testing.T) {
+	router := newTestRouter(t)
+
+	// Without TENANT_MODE requests come from the empty tenant
+	foreign, hostKey, err := websockets.AddRoomUUID("acme", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(roomUUID string) bool {
+		t.Helper()
+
+		w := serve(router, http.MethodGet, "/api/rooms/"+roomUUID+"/exists", "", nil)
+		response := roomExistsResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Exists
+	}
+	if exists(foreign) {
+		t.Fatal("the room of another tenant exists")
+	}
+	if !exists(own) {
+		t.Fatal("the room of the tenant doesn't exist")
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		header http.Header
+	}{
+		{name: "participants", method: http.MethodGet, target: "/api/rooms/" + foreign + "/participants"},
+		{name: "rename", method: http.MethodPatch, target: "/api/rooms/" + foreign, body: `{"name": "taken over"}`, header: http.Header{"X-Host-Key": {hostKey}}},
+		{name: "snapshot", method: http.MethodGet, target: "/api/rooms/" + foreign + "/snapshot", header: http.Header{"X-Host-Key": {hostKey}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(router, tt.method, tt.target, tt.body, tt.header); w.Code != http.StatusNotFound {
+				t.Fatalf("got %d, want %d", w.Code, http.StatusNotFound)
+			}
+		})
+	}
+
+	if w := serve(router, http.MethodGet, "/api/rooms/"+own+"/participants", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("participants of the own room got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestParticipants(t *testing.T) {
+	router := newTestRouter(t)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	roomUUID, hostKey, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialJoin(t, srv, roomUUID, url.Values{"name": {"Alice"}, "host": {hostKey}})
+	dialJoin(t, srv, roomUUID, url.Values{"name": {"Bob"}})
+	waitForParticipants(t, roomUUID, 2)
+
+	w := serve(router, http.MethodGet, "/api/rooms/"+roomUUID+"/participants", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var participants []websockets.Participant
+	if err := json.Unmarshal(w.Body.Bytes(), &participants); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := map[string]bool{}
+	for _, p := range participants {
+		if p.ID == "" || p.JoinedAt.IsZero() {
+			t.Errorf("participant %+v has no ID or join time", p)
+		}
+		hosts[p.Name] = p.IsHost
+	}
+	if len(participants) != 2 || !hosts["Alice"] || hosts["Bob"] {
+		t.Fatalf("got %+v, want Alice as host and Bob", participants)
+	}
+
+	if w := serve(router, http.MethodGet, "/api/rooms/no-such-room/participants", "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown room: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	router := newTestRouter(t)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header http.Header
+		want   int
+	}{
+		{name: "config with the key", method: http.MethodGet, target: "/admin/config", header: http.Header{"X-Admin-Key": {testAdminKey}}, want: http.StatusOK},
+		{name: "debug stats with the key", method: http.MethodGet, target: "/admin/debug/stats", header: http.Header{"X-Admin-Key": {testAdminKey}}, want: http.StatusOK},
+		{name: "config without a key", method: http.MethodGet, target: "/admin/config", want: http.StatusUnauthorized},
+		{name: "config with a wrong key", method: http.MethodGet, target: "/admin/config", header: http.Header{"X-Admin-Key": {"guess"}}, want: http.StatusUnauthorized},
+		{name: "config with a longer key", method: http.MethodGet, target: "/admin/config", header: http.Header{"X-Admin-Key": {testAdminKey + "x"}}, want: http.StatusUnauthorized},
+		{name: "broadcast without a key", method: http.MethodPost, target: "/api/broadcast", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(router, tt.method, tt.target, "", tt.header); w.Code != tt.want {
+				t.Fatalf("got %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	t.Run("no key configured", func(t *testing.T) {
+		previous := adminKeyHash
+		adminKeyHash = nil
+		t.Cleanup(func() { adminKeyHash = previous })
+
+		if w := serve(router, http.MethodGet, "/admin/config", "", http.Header{"X-Admin-Key": {""}}); w.Code != http.StatusUnauthorized {
+			t.Fatalf("got %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestDebugStats(t *testing.T) {
+	router := newTestRouter(t)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	roomUUID, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialJoin(t, srv, roomUUID, nil)
+	waitForParticipants(t, roomUUID, 1)
+
+	w := serve(router, http.MethodGet, "/admin/debug/stats", "", http.Header{"X-Admin-Key": {testAdminKey}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	stats := debugStatsResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server, the peer and the test itself run goroutines of their own
+	if stats.Goroutines < 3 {
+		t.Errorf("got %d goroutines, want at least 3", stats.Goroutines)
+	}
+	if m := stats.Memory; m.Alloc == 0 || m.TotalAlloc < m.Alloc || m.Sys < m.HeapInuse || m.HeapObjects == 0 {
+		t.Errorf("got memory %+v, want allocations within what the runtime got from the system", m)
+	}
+	if stats.Rooms < 1 || stats.Peers < 1 {
+		t.Errorf("got %d rooms and %d peers, want the joined room counted", stats.Rooms, stats.Peers)
+	}
+}
+
+func TestPprofRoutes(t *testing.T) {
+	admin := http.Header{"X-Admin-Key": {testAdminKey}}
+
+	tests := []struct {
+		name    string
+		enabled bool
+		header  http.Header
+		want    int
+	}{
+		{name: "disabled", header: admin, want: http.StatusNotFound},
+		{name: "enabled", enabled: true, header: admin, want: http.StatusOK},
+		{name: "enabled without the admin key", enabled: true, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := pprofEnabled
+			pprofEnabled = tt.enabled
+			t.Cleanup(func() { pprofEnabled = previous })
+			router := newTestRouter(t)
+
+			for _, target := range []string{"/debug/pprof/", "/debug/pprof/cmdline"} {
+				if w := serve(router, http.MethodGet, target, "", tt.header); w.Code != tt.want {
+					t.Errorf("%s: got %d, want %d", target, w.Code, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenameRoute(t *testing.T) {
+	router := newTestRouter(t)
+
+	// Rooms outlive the test, the names must not clash with an earlier run
+	suffix := " " + uuid.NewString()[:8]
+
+	config := websockets.DefaultRoomConfig()
+	config.Name = "Standup" + suffix
+	roomUUID, hostKey, err := websockets.AddRoomUUID("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Name = "Retro" + suffix
+	if _, _, err := websockets.AddRoomUUID("", config); err != nil {
+		t.Fatal(err)
+	}
+	host := http.Header{"X-Host-Key": {hostKey}}
+
+	tests := []struct {
+		name     string
+		roomUUID string
+		body     string
+		want     int
+	}{
+		{name: "rename", roomUUID: roomUUID, body: `{"name": "Planning` + suffix + `"}`, want: http.StatusNoContent},
+		{name: "name of another room", roomUUID: roomUUID, body: `{"name": "Retro` + suffix + `"}`, want: http.StatusConflict},
+		{name: "no name", roomUUID: roomUUID, body: `{}`, want: http.StatusBadRequest},
+		{name: "unknown room", roomUUID: "no-such-room", body: `{"name": "Planning"}`, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(router, http.MethodPatch, "/api/rooms/"+tt.roomUUID, tt.body, host); w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	if w := serve(router, http.MethodPost, "/api/rooms", `{"name": "planning`+suffix+`"}`, nil); w.Code != http.StatusConflict {
+		t.Fatalf("creating a room under the new name: got %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestConcurrentRoomCreations(t *testing.T) {
+	router := newTestRouter(t)
+
+	const limit, requests = 3, 10
+	previous := creationSlots
+	creationSlots = make(chan struct{}, limit)
+	t.Cleanup(func() { creationSlots = previous })
+
+	// The bodies keep the creations in flight until they are written
+	bodies := make([]*io.PipeWriter, requests)
+	codes := make(chan int, requests)
+	for i := range bodies {
+		body, writer := io.Pipe()
+		bodies[i] = writer
+
+		go func() {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/rooms", body))
+			_ = body.Close()
+			codes <- w.Code
+		}()
+	}
+
+	// The requests past the limit are refused right away
+	for i := 0; i < requests-limit; i++ {
+		select {
+		case code := <-codes:
+			if code != http.StatusServiceUnavailable {
+				t.Fatalf("got %d, want %d", code, http.StatusServiceUnavailable)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d refusals, want %d", i, requests-limit)
+		}
+	}
+
+	for _, writer := range bodies {
+		go func(writer *io.PipeWriter) {
+			_, _ = writer.Write([]byte("{}"))
+			_ = writer.Close()
+		}(writer)
+	}
+	for i := 0; i < limit; i++ {
+		if code := <-codes; code != http.StatusCreated {
+			t.Fatalf("got %d, want %d", code, http.StatusCreated)
+		}
+	}
+
+	// The slots are free again
+	if w := serve(router, http.MethodPost, "/api/rooms", "{}", nil); w.Code != http.StatusCreated {
+		t.Fatalf("after the burst: got %d, want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestGzipResponses(t *testing.T) {
+	router := newTestRouter(t)
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		wantGzip bool
+	}{
+		{name: "gzip accepted", header: http.Header{"Accept-Encoding": {"gzip, deflate"}}, wantGzip: true},
+		{name: "identity only", header: http.Header{"Accept-Encoding": {"identity"}}},
+		{name: "no accept-encoding"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, http.MethodGet, "/api/capacity", "", tt.header)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("Content-Encoding") == "gzip"; got != tt.wantGzip {
+				t.Fatalf("got Content-Encoding %q, want gzip %v", w.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+
+			var body io.Reader = w.Body
+			if tt.wantGzip {
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = gz
+			}
+
+			response := map[string]interface{}{}
+			if err := json.NewDecoder(body).Decode(&response); err != nil {
+				t.Fatalf("decoding the body: %v", err)
+			}
+		})
+	}
+}
+
+func TestGzipSkipsTheWebsocket(t *testing.T) {
+	srv := httptest.NewServer(newTestRouter(t))
+	defer srv.Close()
+
+	roomUUID, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{"Accept-Encoding": {"gzip"}}
+	ws, resp, err := websocket.DefaultDialer.Dial(websocketTarget(srv, "/websocket/"+roomUUID+"/join"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+		t.Fatalf("got Content-Encoding %q on the upgrade", encoding)
+	}
+	nextSignal(t, ws, "welcome")
+}
+
+func TestCapacity(t *testing.T) {
+	router := newTestRouter(t)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	// A short interval so a load is measured during the test
+	previous := cpuSampler
+	cpuSampler = cpuload.NewSampler(10 * time.Millisecond)
+	t.Cleanup(func() { cpuSampler = previous })
+
+	// Hashing keeps the CPU busy until some load is measured
+	deadline := time.Now().Add(5 * time.Second)
+	for cpuSampler.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the sampler measured no load")
+		}
+		sha256.Sum256([]byte(testAdminKey))
+	}
+
+	roomUUID, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialJoin(t, srv, roomUUID, nil)
+	waitForParticipants(t, roomUUID, 1)
+
+	w := serve(router, http.MethodGet, "/api/capacity", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	response := capacityResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, limits := websockets.GetServerStats(), websockets.GetLimits()
+	if response.Rooms == 0 || response.Rooms != stats.Rooms {
+		t.Errorf("got %d rooms, want %d", response.Rooms, stats.Rooms)
+	}
+	if response.Peers == 0 || response.Peers != stats.Peers {
+		t.Errorf("got %d peers, want %d", response.Peers, stats.Peers)
+	}
+	if response.MaxRooms != limits.MaxRooms || response.MaxParticipants != limits.MaxParticipants {
+		t.Errorf("got limits %d rooms and %d participants, want %d and %d",
+			response.MaxRooms, response.MaxParticipants, limits.MaxRooms, limits.MaxParticipants)
+	}
+	if response.CPULoad <= 0 || response.CPULoad > 1 {
+		t.Errorf("got CPU load %v, want it in (0, 1]", response.CPULoad)
+	}
+}
+
+func TestTracksRoute(t *testing.T) {
+	router := newTestRouter(t)
+
+	roomUUID, _, err := websockets.AddRoomUUID("", websockets.DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(router, http.MethodGet, "/api/rooms/"+roomUUID+"/tracks", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", w.Code, http.StatusOK)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Fatalf("got %s, want an empty list", body)
+	}
+
+	if w := serve(router, http.MethodGet, "/api/rooms/no-such-room/tracks", "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown room: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSplitRouters(t *testing.T) {
+	useTestAdminKey(t)
+	previous := pprofEnabled
+	pprofEnabled = true
+	t.Cleanup(func() { pprofEnabled = previous })
+
+	public, admin := NewPublicRouter(), NewAdminRouter()
+	adminKey := http.Header{"X-Admin-Key": {testAdminKey}}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantPublic int
+		wantAdmin  int
+	}{
+		{name: "config", method: http.MethodGet, target: "/admin/config", wantPublic: http.StatusNotFound, wantAdmin: http.StatusOK},
+		{name: "metrics", method: http.MethodGet, target: "/admin/metrics", wantPublic: http.StatusNotFound, wantAdmin: http.StatusOK},
+		{name: "debug stats", method: http.MethodGet, target: "/admin/debug/stats", wantPublic: http.StatusNotFound, wantAdmin: http.StatusOK},
+		{name: "pprof", method: http.MethodGet, target: "/debug/pprof/", wantPublic: http.StatusNotFound, wantAdmin: http.StatusOK},
+		{name: "capacity", method: http.MethodGet, target: "/api/capacity", wantPublic: http.StatusOK, wantAdmin: http.StatusNotFound},
+		{name: "healthz", method: http.MethodGet, target: "/healthz", wantPublic: http.StatusOK, wantAdmin: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(public, tt.method, tt.target, "", adminKey); w.Code != tt.wantPublic {
+				t.Errorf("public router: got %d, want %d", w.Code, tt.wantPublic)
+			}
+			if w := serve(admin, tt.method, tt.target, "", adminKey); w.Code != tt.wantAdmin {
+				t.Errorf("admin router: got %d, want %d", w.Code, tt.wantAdmin)
+			}
+		})
+	}
+}
+
+func TestLoadPages(t *testing.T) {
+	page := &fstest.MapFile{Data: []byte(`<html>{{.}}</html>`)}
+
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr string
+	}{
+		{name: "all pages", fsys: fstest.MapFS{"index.html": page, "lobby.html": page}},
+		{name: "one missing", fsys: fstest.MapFS{"lobby.html": page}, wantErr: "missing index.html"},
+		{name: "all missing", fsys: fstest.MapFS{"other.txt": page}, wantErr: "missing index.html, lobby.html"},
+		{
+			name:    "unparseable page",
+			fsys:    fstest.MapFS{"index.html": page, "lobby.html": &fstest.MapFile{Data: []byte(`{{.Broken`)}},
+			wantErr: "lobby.html",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadPages(tt.fsys)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want an error naming %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"net/http"
+	"time"
+)
+
+// serverTimeouts bound how long a client may take over a request, so slow
+// ones can't hold connections open
+type serverTimeouts struct {
+	read       time.Duration
+	readHeader time.Duration
+	write      time.Duration
+	idle       time.Duration
+}
+
+var timeouts serverTimeouts
+
+func init() {
+	timeouts = loadServerTimeouts()
+}
+
+func loadServerTimeouts() serverTimeouts {
+	return serverTimeouts{
+		read:       config.Duration("HTTP_READ_TIMEOUT", 15*time.Second),
+		readHeader: config.Duration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		write:      config.Duration("HTTP_WRITE_TIMEOUT", 15*time.Second),
+		idle:       config.Duration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+	}
+}
+
+// NewServer creates a server with the configured timeouts. Websocket
+// connections aren't cut by WriteTimeout: the upgrader clears the deadlines
+// once the connection is hijacked
+func NewServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       timeouts.read,
+		ReadHeaderTimeout: timeouts.readHeader,
+		WriteTimeout:      timeouts.write,
+		IdleTimeout:       timeouts.idle,
+	}
+}
@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewServerTimeouts(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want serverTimeouts
+	}{
+		{
+			name: "defaults",
+			env: map[string]string{
+				"HTTP_READ_TIMEOUT":        "",
+				"HTTP_READ_HEADER_TIMEOUT": "",
+				"HTTP_WRITE_TIMEOUT":       "",
+				"HTTP_IDLE_TIMEOUT":        "",
+			},
+			want: serverTimeouts{read: 15 * time.Second, readHeader: 5 * time.Second, write: 15 * time.Second, idle: time.Minute},
+		},
+		{
+			name: "configured",
+			env: map[string]string{
+				"HTTP_READ_TIMEOUT":        "3s",
+				"HTTP_READ_HEADER_TIMEOUT": "1s",
+				"HTTP_WRITE_TIMEOUT":       "4s",
+				"HTTP_IDLE_TIMEOUT":        "2m",
+			},
+			want: serverTimeouts{read: 3 * time.Second, readHeader: time.Second, write: 4 * time.Second, idle: 2 * time.Minute},
+		},
+	}
+
+	previous := timeouts
+	t.Cleanup(func() { timeouts = previous })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			timeouts = loadServerTimeouts()
+
+			srv := NewServer(":8080", http.NotFoundHandler())
+			got := serverTimeouts{read: srv.ReadTimeout, readHeader: srv.ReadHeaderTimeout, write: srv.WriteTimeout, idle: srv.IdleTimeout}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			if srv.Addr != ":8080" {
+				t.Fatalf("got address %q, want :8080", srv.Addr)
+			}
+		})
+	}
+}
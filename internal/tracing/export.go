@@ -0,0 +1,230 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// queueSize bounds the ended spans waiting for export, more are dropped
+	queueSize = 2048
+
+	// maxBatchSize is the most spans sent in one request
+	maxBatchSize = 512
+
+	exportInterval = 5 * time.Second
+)
+
+// OTLP span kinds and status codes, see opentelemetry/proto/trace/v1/trace.proto
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	statusCodeError  = 2
+)
+
+// batchProcessor hands the ended spans to the exporter in batches, in the
+// background so tracing never holds up signaling
+type batchProcessor struct {
+	queue    chan SpanData
+	stop     chan chan struct{}
+	exporter SpanExporter
+}
+
+func newBatchProcessor(exporter SpanExporter) *batchProcessor {
+	p := &batchProcessor{
+		queue:    make(chan SpanData, queueSize),
+		stop:     make(chan chan struct{}),
+		exporter: exporter,
+	}
+	go p.run()
+
+	return p
+}
+
+func (p *batchProcessor) onEnd(s SpanData) {
+	select {
+	case p.queue <- s:
+	default:
+		log.Printf("tracing: queue full, dropping span %s", s.Name)
+	}
+}
+
+func (p *batchProcessor) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, maxBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.exporter.ExportSpans(context.Background(), batch); err != nil {
+			log.Printf("tracing: export %d spans: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s := <-p.queue:
+			if batch = append(batch, s); len(batch) == maxBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case done := <-p.stop:
+			for len(p.queue) > 0 {
+				if batch = append(batch, <-p.queue); len(batch) == maxBatchSize {
+					flush()
+				}
+			}
+			flush()
+			close(done)
+			return
+		}
+	}
+}
+
+func (p *batchProcessor) shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case p.stop <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return p.exporter.Shutdown(ctx)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// otlpExporter posts the spans to the collector as OTLP/HTTP JSON
+type otlpExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+// ExportSpans posts the spans as an ExportTraceServiceRequest
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(exportRequest{ResourceSpans: []resourceSpans{{
+		Resource: resource{Attributes: []keyValue{{Key: "service.name", Value: anyValue{StringValue: e.serviceName}}}},
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{Name: "github.com/b4o4/conference-backend"},
+			Spans: encodeSpans(spans),
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Shutdown has nothing to release, every export is a request of its own
+func (e *otlpExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// spanJSON follows the protobuf JSON mapping OTLP uses: IDs in hex and
+// timestamps as strings of nanoseconds
+type spanJSON struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func encodeSpans(spans []SpanData) []spanJSON {
+	encoded := make([]spanJSON, 0, len(spans))
+	for _, s := range spans {
+		span := spanJSON{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentSpanID,
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.Server {
+			span.Kind = spanKindServer
+		}
+		for _, a := range s.Attributes {
+			span.Attributes = append(span.Attributes, keyValue{Key: a.Key, Value: anyValue{StringValue: a.Value}})
+		}
+		if s.Error != "" {
+			span.Status = &status{Code: statusCodeError, Message: s.Error}
+		}
+
+		encoded = append(encoded, span)
+	}
+
+	return encoded
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchExportOverOTLP(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []exportRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := exportRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		received = append(received, request)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	p := newBatchProcessor(&otlpExporter{endpoint: collector.URL, serviceName: "test-service", client: collector.Client()})
+	p.onEnd(SpanData{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Name: "websocket.connection", Server: true})
+	p.onEnd(SpanData{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "b7ad6b7169203331", ParentSpanID: "00f067aa0ba902b7", Name: "websocket.upgrade", Error: "bad handshake"})
+
+	// Shutdown sends what is still queued
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(received) != 1 {
+		t.Fatalf("got %d requests, want 1", len(received))
+	}
+	resource := received[0].ResourceSpans[0]
+	if resource.Resource.Attributes[0].Value.StringValue != "test-service" {
+		t.Fatalf("got resource %+v, want service.name test-service", resource.Resource)
+	}
+
+	spans := resource.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].Kind != spanKindServer || spans[0].ParentSpanID != "" || spans[0].Status != nil {
+		t.Fatalf("got connection span %+v, want a root server span", spans[0])
+	}
+	if spans[1].Kind != spanKindInternal || spans[1].ParentSpanID != spans[0].SpanID || spans[1].Status == nil || spans[1].Status.Code != statusCodeError {
+		t.Fatalf("got upgrade span %+v, want a failed internal child of the connection", spans[1])
+	}
+}
@@ -0,0 +1,50 @@
+// Package tracetest keeps exported spans in memory so tests can look at them,
+// after the package of the same name in the OpenTelemetry SDK
+package tracetest
+
+import (
+	"context"
+	"github.com/b4o4/conference-backend/internal/tracing"
+	"sync"
+)
+
+// InMemoryExporter is a tracing.SpanExporter holding the spans it gets
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) ExportSpans(_ context.Context, spans []tracing.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, spans...)
+
+	return nil
+}
+
+// Shutdown drops the spans held
+func (e *InMemoryExporter) Shutdown(context.Context) error {
+	e.Reset()
+
+	return nil
+}
+
+// GetSpans returns the spans exported so far, in the order they ended
+func (e *InMemoryExporter) GetSpans() []tracing.SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]tracing.SpanData(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = nil
+}
@@ -0,0 +1,271 @@
+// Package tracing records spans of the connection lifecycle and exports them
+// to an OpenTelemetry collector over OTLP/HTTP. It is off unless OTEL_ENABLED
+// is set, then every function is a no-op on nil spans
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/b4o4/conference-backend/internal/config"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	if !config.Bool("OTEL_ENABLED", false) {
+		return
+	}
+
+	setProcessor(newBatchProcessor(&otlpExporter{
+		endpoint:    strings.TrimSuffix(config.String("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"), "/") + "/v1/traces",
+		serviceName: config.String("OTEL_SERVICE_NAME", "conference-backend"),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}))
+}
+
+// SpanExporter gets the ended spans. It has the shape of the exporters of
+// the OpenTelemetry SDK, so the collector client is one implementation and
+// tracetest.InMemoryExporter another
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// spanProcessor hands the ended spans to an exporter
+type spanProcessor interface {
+	onEnd(s SpanData)
+	shutdown(ctx context.Context) error
+}
+
+// processor holds the spanProcessor in use, tracing is off while it is nil
+var processor atomic.Value
+
+type processorHolder struct {
+	spanProcessor
+}
+
+func setProcessor(p spanProcessor) {
+	processor.Store(processorHolder{p})
+}
+
+func currentProcessor() spanProcessor {
+	holder, _ := processor.Load().(processorHolder)
+	return holder.spanProcessor
+}
+
+// SetExporter turns tracing on with every span exported as it ends, which
+// tests use to look at the spans. A nil exporter turns tracing off
+func SetExporter(e SpanExporter) {
+	if e == nil {
+		setProcessor(nil)
+		return
+	}
+
+	setProcessor(syncProcessor{exporter: e})
+}
+
+// syncProcessor exports each span as it ends
+type syncProcessor struct {
+	exporter SpanExporter
+}
+
+func (p syncProcessor) onEnd(s SpanData) {
+	if err := p.exporter.ExportSpans(context.Background(), []SpanData{s}); err != nil {
+		log.Printf("tracing: export span %s: %v", s.Name, err)
+	}
+}
+
+func (p syncProcessor) shutdown(ctx context.Context) error {
+	return p.exporter.Shutdown(ctx)
+}
+
+// Attribute is a key and a string value, the only kind the spans here need
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// spanContext identifies a span, remote when it came from a traceparent header
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	remote  bool
+}
+
+type spanContextKey struct{}
+
+// SpanData is an ended span as the exporters get it. IDs are in hex,
+// ParentSpanID is empty for the root of a trace
+type SpanData struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+
+	// Server spans handle a request, the others are internal
+	Server     bool
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+
+	// Error is why the operation failed, empty if it didn't
+	Error string
+}
+
+// Span is an operation being timed. A nil span is valid and does nothing
+type Span struct {
+	mu         sync.Mutex
+	sc         spanContext
+	parentID   [8]byte
+	name       string
+	server     bool
+	start      time.Time
+	end        time.Time
+	attributes []Attribute
+	err        string
+}
+
+// Extract seeds ctx with the W3C traceparent of the request, if any, so the
+// spans started from it join the caller's trace
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if currentProcessor() == nil {
+		return ctx
+	}
+
+	// version-traceid-spanid-flags
+	parts := strings.Split(header.Get("traceparent"), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return ctx
+	}
+
+	sc := spanContext{remote: true}
+	if !decodeID(sc.traceID[:], parts[1]) || !decodeID(sc.spanID[:], parts[2]) {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// decodeID fills id from its hex form, all zero IDs are invalid
+func decodeID(id []byte, s string) bool {
+	if n, err := hex.Decode(id, []byte(s)); err != nil || n != len(id) || len(s) != 2*len(id) {
+		return false
+	}
+
+	for _, b := range id {
+		if b != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Start begins a span, a child of the one in ctx or the root of a new trace
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	if currentProcessor() == nil {
+		return ctx, nil
+	}
+
+	s := &Span{name: name, start: time.Now(), attributes: attributes}
+	parent, ok := ctx.Value(spanContextKey{}).(spanContext)
+	if ok {
+		s.sc.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(s.sc.traceID[:])
+	}
+	_, _ = rand.Read(s.sc.spanID[:])
+
+	// The first span of the process in a trace handles the request
+	s.server = !ok || parent.remote
+
+	return context.WithValue(ctx, spanContextKey{}, s.sc), s
+}
+
+// SetAttributes adds attributes to a span that hasn't ended
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.end.IsZero() {
+		s.attributes = append(s.attributes, attributes...)
+	}
+}
+
+// SetError marks a span that hasn't ended as failed, nil errors are ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.end.IsZero() {
+		s.err = err.Error()
+	}
+}
+
+// End finishes the span and queues it for export, only the first call counts
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	data := s.data()
+	s.mu.Unlock()
+
+	// Tracing may have been turned off since the span started
+	if p := currentProcessor(); p != nil {
+		p.onEnd(data)
+	}
+}
+
+// data is the span as exported. s.mu must be held
+func (s *Span) data() SpanData {
+	data := SpanData{
+		TraceID:    hex.EncodeToString(s.sc.traceID[:]),
+		SpanID:     hex.EncodeToString(s.sc.spanID[:]),
+		Name:       s.name,
+		Server:     s.server,
+		Start:      s.start,
+		End:        s.end,
+		Attributes: append([]Attribute(nil), s.attributes...),
+		Error:      s.err,
+	}
+	if s.parentID != [8]byte{} {
+		data.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+
+	return data
+}
+
+// Shutdown exports the spans still queued, waiting at most until ctx is done
+func Shutdown(ctx context.Context) error {
+	p := currentProcessor()
+	if p == nil {
+		return nil
+	}
+
+	return p.shutdown(ctx)
+}
@@ -0,0 +1,88 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"github.com/b4o4/conference-backend/internal/tracing"
+	"github.com/b4o4/conference-backend/internal/tracing/tracetest"
+	"net/http"
+	"testing"
+)
+
+const (
+	callerTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	callerSpanID  = "00f067aa0ba902b7"
+)
+
+// useInMemoryExporter turns tracing on for the test
+func useInMemoryExporter(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracing.SetExporter(exporter)
+	t.Cleanup(func() { tracing.SetExporter(nil) })
+
+	return exporter
+}
+
+func TestSpanTree(t *testing.T) {
+	exporter := useInMemoryExporter(t)
+
+	header := http.Header{"Traceparent": {"00-" + callerTraceID + "-" + callerSpanID + "-01"}}
+	ctx, root := tracing.Start(tracing.Extract(context.Background(), header), "websocket.connection", tracing.String("room.id", "room-1"))
+	_, child := tracing.Start(ctx, "websocket.upgrade")
+	child.SetError(errors.New("bad handshake"))
+	child.End()
+	child.End()
+	root.SetAttributes(tracing.String("peer.id", "peer-1"))
+	root.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2: %+v", len(spans), spans)
+	}
+	upgrade, connection := spans[0], spans[1]
+
+	if connection.TraceID != callerTraceID || connection.ParentSpanID != callerSpanID || !connection.Server {
+		t.Fatalf("connection span %+v doesn't continue the caller's trace as a server span", connection)
+	}
+	if len(connection.Attributes) != 2 || connection.Attributes[1] != tracing.String("peer.id", "peer-1") {
+		t.Fatalf("got connection attributes %v, want room.id and peer.id", connection.Attributes)
+	}
+	if upgrade.TraceID != callerTraceID || upgrade.ParentSpanID != connection.SpanID || upgrade.Server {
+		t.Fatalf("upgrade span %+v isn't an internal child of the connection span", upgrade)
+	}
+	if upgrade.Error != "bad handshake" {
+		t.Fatalf("got upgrade error %q, want bad handshake", upgrade.Error)
+	}
+}
+
+func TestSpanTreeWithoutCaller(t *testing.T) {
+	exporter := useInMemoryExporter(t)
+
+	// A malformed traceparent starts a trace of our own
+	header := http.Header{"Traceparent": {"00-" + callerTraceID + "-0000000000000000-01"}}
+	_, root := tracing.Start(tracing.Extract(context.Background(), header), "websocket.connection")
+	root.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].TraceID == callerTraceID || spans[0].ParentSpanID != "" || !spans[0].Server {
+		t.Fatalf("got %+v, want one root server span of a new trace", spans)
+	}
+}
+
+func TestTracingOff(t *testing.T) {
+	exporter := useInMemoryExporter(t)
+	tracing.SetExporter(nil)
+
+	_, span := tracing.Start(context.Background(), "websocket.connection")
+	if span != nil {
+		t.Fatal("got a span with tracing off")
+	}
+	span.SetError(errors.New("ignored"))
+	span.End()
+
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Fatalf("got %d spans exported with tracing off", len(spans))
+	}
+}
@@ -0,0 +1,148 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// announceLeadIn gives subscribers time to negotiate the announcement
+	// track before playback starts
+	announceLeadIn = time.Second
+
+	// announcePayloadType is the Opus payload type of server tracks, the
+	// downTracks map it to whatever each subscriber negotiated
+	announcePayloadType = 111
+)
+
+var (
+	ErrInvalidAnnouncement  = errors.New("invalid announcement name")
+	ErrAnnouncementNotFound = errors.New("announcement not found")
+)
+
+// announcementsDir holds the Ogg/Opus files that can be played into rooms
+var announcementsDir string
+
+func init() {
+	announcementsDir = config.String("ANNOUNCEMENTS_DIR", "announcements")
+}
+
+// Announce plays the announcement <name>.ogg into the room as a server owned
+// audio track, removed once playback is over. Only the host may do it
+func Announce(roomUUID, hostKey, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", ErrInvalidAnnouncement
+	}
+
+	listLock.RLock()
+	r, ok := conferences[roomUUID]
+	isHost := ok && r.isHost(hostKey)
+	listLock.RUnlock()
+
+	if !ok {
+		return "", ErrRoomNotFound
+	}
+
+	if !isHost {
+		return "", ErrNotHost
+	}
+
+	file, err := os.Open(filepath.Join(announcementsDir, name+".ogg"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrAnnouncementNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	ogg, _, err := oggreader.NewWith(file)
+	if err != nil {
+		_ = file.Close()
+		return "", err
+	}
+
+	codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"}
+	track := &localTrack{
+		id:          "announcement-" + uuid.NewString(),
+		streamID:    "announcement",
+		codec:       codec,
+		payloadType: announcePayloadType,
+		codecs:      map[webrtc.PayloadType]webrtc.RTPCodecCapability{announcePayloadType: codec},
+		subscribers: make(map[string]*downTrack),
+	}
+
+	if _, err = addTrack(track, roomUUID); err != nil {
+		_ = file.Close()
+		return "", err
+	}
+
+	go func() {
+		defer file.Close()
+		defer removeServerTrack(track, roomUUID)
+
+		time.Sleep(announceLeadIn)
+		playOgg(ogg, track)
+	}()
+
+	return track.ID(), nil
+}
+
+// playOgg forwards the Opus pages of the file in real time
+func playOgg(ogg *oggreader.OggReader, track *localTrack) {
+	packet := &rtp.Packet{Header: rtp.Header{
+		Version:        2,
+		PayloadType:    announcePayloadType,
+		SequenceNumber: uint16(rand.Uint32()),
+		Timestamp:      rand.Uint32(),
+		SSRC:           rand.Uint32(),
+	}}
+
+	var lastGranule uint64
+	next := time.Now()
+	for {
+		page, header, err := ogg.ParseNextPage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Println("announce:", err)
+			}
+			return
+		}
+
+		// Granule positions count 48kHz samples, the tags page has none
+		samples := header.GranulePosition - lastGranule
+		lastGranule = header.GranulePosition
+		if samples == 0 {
+			continue
+		}
+
+		packet.Payload = page
+		track.writeRTP(packet)
+		packet.SequenceNumber++
+		packet.Timestamp += uint32(samples)
+
+		next = next.Add(time.Duration(samples) * time.Second / 48000)
+		time.Sleep(time.Until(next))
+	}
+}
+
+// removeServerTrack removes a track the server published, there is no
+// publisher that could come back for it
+func removeServerTrack(t *localTrack, roomUUID string) {
+	listLock.Lock()
+	if trackLocals[roomUUID][t.ID()] == t {
+		dropTrack(t, roomUUID)
+	}
+	listLock.Unlock()
+
+	requestSignal(roomUUID)
+}
@@ -0,0 +1,121 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeAnnouncement writes <name>.ogg to dir, holding frames Opus frames of 20ms
+func writeAnnouncement(t *testing.T, dir, name string, frames int) {
+	t.Helper()
+
+	ogg, err := oggwriter.New(filepath.Join(dir, name+".ogg"), 48000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < frames; i++ {
+		packet := &rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: uint16(i), Timestamp: uint32(i) * 960},
+			Payload: []byte{0xfc, byte(i)},
+		}
+		if err := ogg.WriteRTP(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ogg.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	previous := announcementsDir
+	announcementsDir = t.TempDir()
+	t.Cleanup(func() { announcementsDir = previous })
+
+	const frames = 5
+	writeAnnouncement(t, announcementsDir, "chime", frames)
+
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trackID, err := Announce(roomUUID, hostKey, "chime")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The lead-in leaves the time to subscribe before playback
+	listLock.RLock()
+	track, ok := trackLocals[roomUUID][trackID]
+	listLock.RUnlock()
+	if !ok {
+		t.Fatalf("the announcement %s is not a track of the room", trackID)
+	}
+	queue := bindTestSubscriber(t, track, "bob")
+
+	deadline := time.Now().Add(announceLeadIn + 5*time.Second)
+	for {
+		listLock.RLock()
+		_, playing := trackLocals[roomUUID][trackID]
+		listLock.RUnlock()
+
+		if !playing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the announcement was not removed after playback")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < frames; i++ {
+		select {
+		case p := <-queue:
+			if len(p.payload) != 2 || p.payload[1] != byte(i) {
+				t.Fatalf("packet %d: got payload %x, want frame %d", i, p.payload, i)
+			}
+		default:
+			t.Fatalf("got %d packets, want %d", i, frames)
+		}
+	}
+	if got := forwarded(queue); got != 0 {
+		t.Fatalf("got %d packets past the end of the file", got)
+	}
+}
+
+func TestAnnounceErrors(t *testing.T) {
+	previous := announcementsDir
+	announcementsDir = t.TempDir()
+	t.Cleanup(func() { announcementsDir = previous })
+
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		hostKey      string
+		announcement string
+		want         error
+	}{
+		{name: "path outside the directory", hostKey: hostKey, announcement: "../secret", want: ErrInvalidAnnouncement},
+		{name: "hidden file", hostKey: hostKey, announcement: ".chime", want: ErrInvalidAnnouncement},
+		{name: "not the host", hostKey: "guess", announcement: "chime", want: ErrNotHost},
+		{name: "missing file", hostKey: hostKey, announcement: "chime", want: ErrAnnouncementNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Announce(roomUUID, tt.hostKey, tt.announcement); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package websockets
+
+import (
+	"errors"
+	"fmt"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/interceptor"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	// api creates every PeerConnection so they all share the same engine settings
+	api *webrtc.API
+
+	// iceServers are handed to PeerConnections and announced to clients
+	iceServers []webrtc.ICEServer
+
+	// allowedICEServers are the hosts, or host:port pairs, the ICE servers of
+	// a room may point to: those of ICE_SERVERS and ICE_SERVERS_ALLOWED
+	allowedICEServers = map[string]bool{}
+)
+
+func init() {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		log.Fatal(err)
+	}
+
+	// RED and ulpfec are forwarded as is, subscribers recover losses themselves
+	if config.Bool("FEC_ENABLED", false) {
+		if err := registerFECCodecs(mediaEngine); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// telephone-event carries the DTMF of SIP/PSTN gateways next to the audio
+	if config.Bool("DTMF_ENABLED", false) {
+		if err := registerDTMFCodecs(mediaEngine); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	interceptorRegistry := &interceptor.Registry{}
+	if err := webrtc.ConfigureNack(mediaEngine, interceptorRegistry); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := webrtc.ConfigureRTCPReports(interceptorRegistry); err != nil {
+		log.Fatal(err)
+	}
+
+	// Audio levels drive the active speaker detection, transport-cc the
+	// congestion control of the clients
+	extensions := config.String("RTP_HEADER_EXTENSIONS", "audio-level,transport-cc")
+	if err := registerHeaderExtensions(mediaEngine, interceptorRegistry, extensions); err != nil {
+		log.Fatalf("RTP_HEADER_EXTENSIONS: %v", err)
+	}
+
+	if err := checkInsecureSkipVerify(); err != nil {
+		log.Fatal(err)
+	}
+
+	iceServers = parseICEServers(
+		config.String("ICE_SERVERS", ""),
+		config.String("TURN_USERNAME", ""),
+		config.String("TURN_CREDENTIAL", ""),
+	)
+	for _, server := range iceServers {
+		for _, url := range server.URLs {
+			host, port := iceServerAddress(url)
+			allowICEServer(host, port)
+		}
+	}
+	for _, address := range strings.Split(config.String("ICE_SERVERS_ALLOWED", ""), ",") {
+		if address = strings.TrimSpace(address); address == "" {
+			continue
+		}
+
+		if host, port, err := net.SplitHostPort(address); err == nil {
+			allowICEServer(host, port)
+		} else {
+			allowICEServer(address, "")
+		}
+	}
+
+	api = webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+		webrtc.WithSettingEngine(newSettingEngine()),
+	)
+
+	// Checked against the codecs of api, so only once it is built
+	preferences, err := parseCodecPreferences(config.String("CODEC_PREFERENCES", ""))
+	if err != nil {
+		log.Fatalf("CODEC_PREFERENCES: %v", err)
+	}
+	codecPreferences = preferences
+}
+
+// newSettingEngine configures the ICE agents of the PeerConnections
+func newSettingEngine() webrtc.SettingEngine {
+	settingEngine := webrtc.SettingEngine{}
+
+	// Stalled connectivity checks fail fast so the client can retry
+	settingEngine.SetICETimeouts(
+		config.Duration("ICE_DISCONNECTED_TIMEOUT", 5*time.Second),
+		config.Duration("ICE_FAILED_TIMEOUT", 25*time.Second),
+		config.Duration("ICE_KEEPALIVE_INTERVAL", 2*time.Second),
+	)
+
+	return settingEngine
+}
+
+var errInsecureSkipVerify = errors.New("ICE_INSECURE_SKIP_VERIFY is not supported by the Pion version in use, " +
+	"trust the staging TURN certificate through SSL_CERT_FILE instead")
+
+// checkInsecureSkipVerify refuses ICE_INSECURE_SKIP_VERIFY when it is set. The
+// ICE agent can skip TURNS certificate checks, but this Pion version doesn't
+// let the SettingEngine pass that on, so the server won't pretend it does
+func checkInsecureSkipVerify() error {
+	if config.Bool("ICE_INSECURE_SKIP_VERIFY", false) {
+		return errInsecureSkipVerify
+	}
+
+	return nil
+}
+
+// parseICEServers turns a comma separated list of STUN/TURN URLs into ICE
+// servers, TURN ones get the given credentials
+func parseICEServers(urls, username, credential string) []webrtc.ICEServer {
+	servers := []webrtc.ICEServer{}
+
+	for _, url := range strings.Split(urls, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+
+		server := webrtc.ICEServer{URLs: []string{url}}
+		if strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:") {
+			server.Username = username
+			server.Credential = credential
+		}
+
+		servers = append(servers, server)
+	}
+
+	return servers
+}
+
+// iceServerAddress returns the host and port of a STUN/TURN URL such as
+// turn:turn.example.com:3478?transport=tcp, the port empty when it has none
+func iceServerAddress(url string) (string, string) {
+	address := url[strings.Index(url, ":")+1:]
+	if i := strings.Index(address, "?"); i >= 0 {
+		address = address[:i]
+	}
+
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		return strings.ToLower(host), port
+	}
+
+	return strings.ToLower(strings.Trim(address, "[]")), ""
+}
+
+// allowICEServer lets rooms use the host, on any port when port is empty
+func allowICEServer(host, port string) {
+	host = strings.ToLower(host)
+	if port == "" {
+		allowedICEServers[host] = true
+		return
+	}
+
+	allowedICEServers[net.JoinHostPort(host, port)] = true
+}
+
+// iceServerAllowed reports whether a room may hand out the STUN/TURN URL
+func iceServerAllowed(url string) bool {
+	host, port := iceServerAddress(url)
+	return allowedICEServers[host] || port != "" && allowedICEServers[net.JoinHostPort(host, port)]
+}
+
+// absCaptureTimeURI isn't among the URIs the sdp package knows
+const absCaptureTimeURI = "http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time"
+
+// registerHeaderExtensions negotiates the comma separated RTP header
+// extensions. transport-cc comes with the interceptor answering it
+func registerHeaderExtensions(mediaEngine *webrtc.MediaEngine, interceptorRegistry *interceptor.Registry, names string) error {
+	both := []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo}
+
+	for _, name := range strings.Split(names, ",") {
+		var uri string
+		kinds := both
+
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "":
+			continue
+		case "transport-cc":
+			if err := webrtc.ConfigureTWCCSender(mediaEngine, interceptorRegistry); err != nil {
+				return err
+			}
+			continue
+		case "audio-level":
+			uri, kinds = sdp.AudioLevelURI, []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio}
+		case "abs-send-time":
+			uri = sdp.ABSSendTimeURI
+		case "abs-capture-time":
+			uri = absCaptureTimeURI
+		default:
+			return fmt.Errorf("unknown header extension %q", name)
+		}
+
+		for _, kind := range kinds {
+			if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: uri}, kind); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func registerFECCodecs(mediaEngine *webrtc.MediaEngine) error {
+	codecs := []struct {
+		codec webrtc.RTPCodecParameters
+		kind  webrtc.RTPCodecType
+	}{
+		{webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "audio/red", ClockRate: 48000, Channels: 2, SDPFmtpLine: "111/111"},
+			PayloadType:        63,
+		}, webrtc.RTPCodecTypeAudio},
+		{webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/red", ClockRate: 90000},
+			PayloadType:        116,
+		}, webrtc.RTPCodecTypeVideo},
+		{webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/ulpfec", ClockRate: 90000},
+			PayloadType:        117,
+		}, webrtc.RTPCodecTypeVideo},
+	}
+
+	for _, c := range codecs {
+		if err := mediaEngine.RegisterCodec(c.codec, c.kind); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registerDTMFCodecs negotiates telephone-event at the clock rates of the
+// audio codecs it accompanies: 48 kHz for Opus, 8 kHz for G.711 and G.722.
+// The payload types are those browsers use
+func registerDTMFCodecs(mediaEngine *webrtc.MediaEngine) error {
+	for _, codec := range []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeTelephoneEvent, ClockRate: 48000, SDPFmtpLine: "0-15"},
+			PayloadType:        110,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeTelephoneEvent, ClockRate: 8000, SDPFmtpLine: "0-15"},
+			PayloadType:        126,
+		},
+	} {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RoomICEServers returns the STUN/TURN servers clients of the room should
+// use, credentials included
+func RoomICEServers(roomUUID string) ([]webrtc.ICEServer, bool) {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return nil, false
+	}
+
+	return append([]webrtc.ICEServer{}, r.config.roomICEServers()...), true
+}
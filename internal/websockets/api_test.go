@@ -0,0 +1,201 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/pion/interceptor"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckInsecureSkipVerify(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  error
+	}{
+		{name: "empty"},
+		{name: "false", value: "false"},
+		{name: "true", value: "true", want: errInsecureSkipVerify},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ICE_INSECURE_SKIP_VERIFY", tt.value)
+
+			if err := checkInsecureSkipVerify(); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// iceTimeouts reads the ICE timeouts the SettingEngine hands to the ICE
+// agents, Pion keeps them unexported
+func iceTimeouts(t *testing.T, settingEngine webrtc.SettingEngine) [3]time.Duration {
+	t.Helper()
+
+	timeouts := reflect.ValueOf(settingEngine).FieldByName("timeout")
+	var got [3]time.Duration
+	for i, name := range []string{"ICEDisconnectedTimeout", "ICEFailedTimeout", "ICEKeepaliveInterval"} {
+		field := timeouts.FieldByName(name)
+		if !field.IsValid() || field.IsNil() {
+			t.Fatalf("%s is not set", name)
+		}
+		got[i] = time.Duration(field.Elem().Int())
+	}
+	return got
+}
+
+func TestNewSettingEngineICETimeouts(t *testing.T) {
+	tests := []struct {
+		name                            string
+		disconnected, failed, keepalive string
+		want                            [3]time.Duration
+	}{
+		{name: "defaults", want: [3]time.Duration{5 * time.Second, 25 * time.Second, 2 * time.Second}},
+		{
+			name:         "configured",
+			disconnected: "3s",
+			failed:       "10s",
+			keepalive:    "500ms",
+			want:         [3]time.Duration{3 * time.Second, 10 * time.Second, 500 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ICE_DISCONNECTED_TIMEOUT", tt.disconnected)
+			t.Setenv("ICE_FAILED_TIMEOUT", tt.failed)
+			t.Setenv("ICE_KEEPALIVE_INTERVAL", tt.keepalive)
+
+			if got := iceTimeouts(t, newSettingEngine()); got != tt.want {
+				t.Fatalf("got disconnected, failed, keepalive %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterHeaderExtensions(t *testing.T) {
+	tests := []struct {
+		name      string
+		names     string
+		wantAudio []string
+		wantVideo []string
+		wantErr   bool
+	}{
+		{
+			name:      "default",
+			names:     "audio-level,transport-cc",
+			wantAudio: []string{sdp.AudioLevelURI, sdp.TransportCCURI},
+			wantVideo: []string{sdp.TransportCCURI},
+		},
+		{
+			name:      "send and capture times",
+			names:     " Abs-Send-Time , abs-capture-time",
+			wantAudio: []string{sdp.ABSSendTimeURI, absCaptureTimeURI},
+			wantVideo: []string{sdp.ABSSendTimeURI, absCaptureTimeURI},
+		},
+		{name: "none", names: ""},
+		{name: "unknown", names: "audio-level,mid", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mediaEngine := &webrtc.MediaEngine{}
+			if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+				t.Fatal(err)
+			}
+			interceptorRegistry := &interceptor.Registry{}
+
+			err := registerHeaderExtensions(mediaEngine, interceptorRegistry, tt.names)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error for an unknown extension")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			peerConnection, err := webrtc.NewAPI(
+				webrtc.WithMediaEngine(mediaEngine),
+				webrtc.WithInterceptorRegistry(interceptorRegistry),
+			).NewPeerConnection(webrtc.Configuration{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { closePeerConnection(peerConnection) })
+
+			for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+				if _, err := peerConnection.AddTransceiverFromKind(kind); err != nil {
+					t.Fatal(err)
+				}
+			}
+			offer, err := peerConnection.CreateOffer(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string][]string{}
+			for _, media := range parseSDP(t, offer).MediaDescriptions {
+				for _, attribute := range media.Attributes {
+					if attribute.Key != "extmap" {
+						continue
+					}
+					if fields := strings.Fields(attribute.Value); len(fields) > 1 {
+						got[media.MediaName.Media] = append(got[media.MediaName.Media], fields[1])
+					}
+				}
+			}
+
+			for kind, want := range map[string][]string{"audio": tt.wantAudio, "video": tt.wantVideo} {
+				sort.Strings(want)
+				sort.Strings(got[kind])
+				if !reflect.DeepEqual(got[kind], want) {
+					t.Errorf("%s: got extensions %v, want %v", kind, got[kind], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterDTMFCodecs(t *testing.T) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerDTMFCodecs(mediaEngine); err != nil {
+		t.Fatal(err)
+	}
+
+	peerConnection, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closePeerConnection(peerConnection) })
+
+	if _, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc := parseSDP(t, offer)
+	for payloadType, clockRate := range map[uint8]uint32{110: 48000, 126: 8000} {
+		codec, err := desc.GetCodecForPayloadType(payloadType)
+		if err != nil {
+			t.Fatalf("payload type %d: %v", payloadType, err)
+		}
+		if codec.Name != "telephone-event" || codec.ClockRate != clockRate {
+			t.Fatalf("got %s/%d on payload type %d, want telephone-event/%d", codec.Name, codec.ClockRate, payloadType, clockRate)
+		}
+	}
+}
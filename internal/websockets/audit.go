@@ -0,0 +1,90 @@
+package websockets
+
+import (
+	"sync"
+	"time"
+)
+
+// auditLogSize bounds the number of events kept per room
+const auditLogSize = 256
+
+// Audit event types
+const (
+	AuditJoin       = "join"
+	AuditLeave      = "leave"
+	AuditLock       = "lock"
+	AuditUnlock     = "unlock"
+	AuditExpire     = "expire"
+	AuditRename     = "rename"
+	AuditHostChange = "host_change"
+	AuditMute       = "mute"
+	AuditUnmute     = "unmute"
+	AuditPause      = "pause"
+	AuditResume     = "resume"
+)
+
+// AuditEvent is a single entry of the room audit log, metadata only
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	PeerID string    `json:"peerId,omitempty"`
+}
+
+// auditLog is a fixed size ring buffer of room events
+type auditLog struct {
+	sync.Mutex
+	events []AuditEvent
+	next   int
+	full   bool
+}
+
+func newAuditLog() *auditLog {
+	return &auditLog{events: make([]AuditEvent, auditLogSize)}
+}
+
+func (a *auditLog) append(typ, peerID string) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.events[a.next] = AuditEvent{Time: time.Now(), Type: typ, PeerID: peerID}
+	a.next = (a.next + 1) % len(a.events)
+	if a.next == 0 {
+		a.full = true
+	}
+}
+
+// list returns the stored events, oldest first
+func (a *auditLog) list() []AuditEvent {
+	a.Lock()
+	defer a.Unlock()
+
+	if !a.full {
+		return append([]AuditEvent{}, a.events[:a.next]...)
+	}
+
+	return append(append([]AuditEvent{}, a.events[a.next:]...), a.events[:a.next]...)
+}
+
+// recordEvent appends an event to the room audit log
+func recordEvent(roomUUID, typ, peerID string) {
+	listLock.RLock()
+	r, ok := conferences[roomUUID]
+	listLock.RUnlock()
+
+	if ok {
+		r.events.append(typ, peerID)
+	}
+}
+
+// RoomEvents returns the recent audit events of a room, oldest first
+func RoomEvents(roomUUID string) ([]AuditEvent, bool) {
+	listLock.RLock()
+	r, ok := conferences[roomUUID]
+	listLock.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	return r.events.list(), true
+}
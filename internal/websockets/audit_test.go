@@ -0,0 +1,84 @@
+package websockets
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoomEventsOrder(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, aliceWelcome := joinRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 1)
+	bob, bobWelcome := joinRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 2)
+
+	if err := alice.WriteJSON(websocketMessage{Event: "mute", Data: "true"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = alice.Close()
+	waitForPeers(t, roomUUID, 1)
+	_ = bob.Close()
+
+	aliceID, bobID := aliceWelcome.ConnectionID, bobWelcome.ConnectionID
+	want := []AuditEvent{
+		{Type: AuditJoin, PeerID: aliceID},
+		{Type: AuditJoin, PeerID: bobID},
+		{Type: AuditMute, PeerID: aliceID},
+		{Type: AuditLeave, PeerID: aliceID},
+		{Type: AuditLeave, PeerID: bobID},
+	}
+
+	// The leave is recorded once the peer is gone, so wait for the last one
+	var events []AuditEvent
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if events, _ = RoomEvents(roomUUID); len(events) >= len(want) {
+			break
+		}
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(events), events, len(want))
+	}
+	for i, event := range events {
+		if event.Type != want[i].Type || event.PeerID != want[i].PeerID {
+			t.Errorf("event %d: got %s of %s, want %s of %s", i, event.Type, event.PeerID, want[i].Type, want[i].PeerID)
+		}
+		if i > 0 && event.Time.Before(events[i-1].Time) {
+			t.Errorf("event %d is older than the one before it", i)
+		}
+	}
+}
+
+func TestSetPublisherPausedIsAudited(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, welcome := joinRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 1)
+
+	for _, paused := range []bool{true, false} {
+		if err := SetPublisherPaused(roomUUID, hostKey, welcome.ConnectionID, paused); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, _ := RoomEvents(roomUUID)
+	var got []string
+	for _, event := range events {
+		if event.PeerID == welcome.ConnectionID {
+			got = append(got, event.Type)
+		}
+	}
+	if want := []string{AuditJoin, AuditPause, AuditResume}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+}
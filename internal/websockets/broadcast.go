@@ -0,0 +1,25 @@
+package websockets
+
+// broadcast sends the message to every peer of the room except the given
+// one, nil to reach them all, and to the room's observers. listLock must be
+// held. A peer whose write fails is flagged by its writer, signaling is then
+// requested so it is reaped without waiting for its read loop
+func broadcast(roomUUID string, message websocketMessage, except *peerConnectionState) {
+	broken := false
+	for _, p := range peerConnections[roomUUID] {
+		if p == except || p.websocket.broken() {
+			continue
+		}
+
+		if err := p.websocket.WriteJSON(&message); err != nil {
+			logSampled(err)
+			broken = true
+		}
+	}
+
+	notifyObservers(roomUUID, message)
+
+	if broken {
+		requestSignal(roomUUID)
+	}
+}
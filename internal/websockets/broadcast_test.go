@@ -0,0 +1,89 @@
+package websockets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailedWriteReapsThePeer(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := dialRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 1)
+
+	// Its writes fail while reads would hang, only the failed write can reap it
+	conn := &fakeConn{failWrites: true}
+	zombie := newTestPeer(t)
+	zombie.id = "zombie"
+	zombie.websocket = conn
+
+	listLock.Lock()
+	peerConnections[roomUUID] = append(peerConnections[roomUUID], zombie)
+	broadcast(roomUUID, websocketMessage{Event: "notification", Data: "{}"}, nil)
+	listLock.Unlock()
+
+	if !conn.broken() {
+		t.Fatal("the failed write did not flag the connection")
+	}
+
+	waitForPeers(t, roomUUID, 1)
+	listLock.RLock()
+	remaining := peerConnections[roomUUID][0]
+	listLock.RUnlock()
+	if remaining == zombie {
+		t.Fatal("the peer whose write failed is still in the room")
+	}
+
+	if _, ok := nextEvent(t, ws, "notification", 5*time.Second); !ok {
+		t.Fatal("the healthy peer missed the broadcast")
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conns := map[string]*fakeConn{}
+	for _, peerID := range []string{"alice", "bob", "carol"} {
+		conns[peerID] = addFakePeer(t, roomUUID, peerID)
+	}
+
+	// Peers already flagged broken are being reaped, nothing is written to them
+	conns["carol"].markFailed()
+
+	listLock.Lock()
+	broadcast(roomUUID, websocketMessage{Event: "chat", Data: `"hi"`}, findPeer(roomUUID, "bob"))
+	broadcast(roomUUID, websocketMessage{Event: "notification", Data: "{}"}, nil)
+	listLock.Unlock()
+
+	tests := []struct {
+		peerID string
+		want   []string
+	}{
+		{peerID: "alice", want: []string{"chat", "notification"}},
+		{peerID: "bob", want: []string{"notification"}},
+		{peerID: "carol"},
+	}
+
+	for _, tt := range tests {
+		got := conns[tt.peerID].events()
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s got %v, want %v", tt.peerID, got, tt.want)
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Fatalf("%s got %v, want %v", tt.peerID, got, tt.want)
+			}
+		}
+	}
+
+	if message, _ := conns["alice"].message("chat"); message.Data != `"hi"` {
+		t.Fatalf("got data %q, want %q", message.Data, `"hi"`)
+	}
+}
@@ -0,0 +1,87 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"log"
+	"strings"
+)
+
+var (
+	errNotBundled = errors.New("remote description doesn't bundle all of its media")
+	errNoRTCPMux  = errors.New("remote description doesn't multiplex RTCP")
+)
+
+var (
+	// bundlePolicy set to max-bundle has all media share one transport, so a
+	// single set of candidates and ports is gathered
+	bundlePolicy webrtc.BundlePolicy
+
+	// rtcpMuxPolicy set to require has RTCP share the port of RTP
+	rtcpMuxPolicy webrtc.RTCPMuxPolicy
+)
+
+func init() {
+	switch policy := strings.ToLower(config.String("BUNDLE_POLICY", "max-bundle")); policy {
+	case "max-bundle":
+		bundlePolicy = webrtc.BundlePolicyMaxBundle
+	case "balanced":
+		bundlePolicy = webrtc.BundlePolicyBalanced
+	case "max-compat":
+		bundlePolicy = webrtc.BundlePolicyMaxCompat
+	default:
+		log.Fatalf("BUNDLE_POLICY must be max-bundle, balanced or max-compat, got %q", policy)
+	}
+
+	switch policy := strings.ToLower(config.String("RTCP_MUX_POLICY", "require")); policy {
+	case "require":
+		rtcpMuxPolicy = webrtc.RTCPMuxPolicyRequire
+	case "negotiate":
+		rtcpMuxPolicy = webrtc.RTCPMuxPolicyNegotiate
+	default:
+		log.Fatalf("RTCP_MUX_POLICY must be require or negotiate, got %q", policy)
+	}
+}
+
+// checkBundle refuses a description of the client its policies don't
+// allow: with max-bundle every media section must be in the BUNDLE group,
+// with require every one must carry rtcp-mux. Rejected sections don't count
+func checkBundle(desc webrtc.SessionDescription) error {
+	if bundlePolicy != webrtc.BundlePolicyMaxBundle && rtcpMuxPolicy != webrtc.RTCPMuxPolicyRequire {
+		return nil
+	}
+
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(desc.SDP)); err != nil {
+		return err
+	}
+
+	bundled := map[string]bool{}
+	for _, attribute := range parsed.Attributes {
+		if fields := strings.Fields(attribute.Value); attribute.Key == sdp.AttrKeyGroup && len(fields) > 0 && fields[0] == "BUNDLE" {
+			for _, mid := range fields[1:] {
+				bundled[mid] = true
+			}
+		}
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		if _, bundleOnly := media.Attribute("bundle-only"); media.MediaName.Port.Value == 0 && !bundleOnly {
+			continue
+		}
+
+		if mid, _ := media.Attribute(sdp.AttrKeyMID); bundlePolicy == webrtc.BundlePolicyMaxBundle && !bundled[mid] {
+			return errNotBundled
+		}
+
+		// Data channels run over SCTP, there is no RTCP to multiplex
+		if _, mux := media.Attribute(sdp.AttrKeyRTCPMux); rtcpMuxPolicy == webrtc.RTCPMuxPolicyRequire &&
+			media.MediaName.Media != "application" && !mux {
+			return errNoRTCPMux
+		}
+	}
+
+	return nil
+}
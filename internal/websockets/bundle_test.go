@@ -0,0 +1,119 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOfferIsBundled(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws, welcome := joinRoom(t, srv, roomUUID)
+	if welcome.BundlePolicy != "max-bundle" || welcome.RTCPMuxPolicy != "require" {
+		t.Fatalf("welcome got %s and %s, want max-bundle and require", welcome.BundlePolicy, welcome.RTCPMuxPolicy)
+	}
+
+	waitForPeers(t, roomUUID, 1)
+	listLock.RLock()
+	peer := findPeer(roomUUID, welcome.ConnectionID)
+	listLock.RUnlock()
+	if peer == nil {
+		t.Fatalf("peer %s isn't in the room", welcome.ConnectionID)
+	}
+	if config := peer.peerConnection.GetConfiguration(); config.BundlePolicy != webrtc.BundlePolicyMaxBundle || config.RTCPMuxPolicy != webrtc.RTCPMuxPolicyRequire {
+		t.Fatalf("server PeerConnection got %s and %s, want max-bundle and require", config.BundlePolicy, config.RTCPMuxPolicy)
+	}
+
+	desc := parseSDP(t, nextOffer(t, ws))
+	bundled := map[string]bool{}
+	for _, attribute := range desc.Attributes {
+		if fields := strings.Fields(attribute.Value); attribute.Key == sdp.AttrKeyGroup && len(fields) > 0 && fields[0] == "BUNDLE" {
+			for _, mid := range fields[1:] {
+				bundled[mid] = true
+			}
+		}
+	}
+
+	if len(desc.MediaDescriptions) == 0 {
+		t.Fatal("the offer has no media")
+	}
+	for _, media := range desc.MediaDescriptions {
+		mid, _ := media.Attribute(sdp.AttrKeyMID)
+		if !bundled[mid] {
+			t.Errorf("%s section %s isn't in the BUNDLE group %v", media.MediaName.Media, mid, bundled)
+		}
+		if _, mux := media.Attribute(sdp.AttrKeyRTCPMux); !mux {
+			t.Errorf("%s section %s has no rtcp-mux", media.MediaName.Media, mid)
+		}
+	}
+}
+
+func TestCheckBundle(t *testing.T) {
+	noGroup := regexp.MustCompile(`a=group:BUNDLE[^\r\n]*\r\n`)
+	noRTCPMux := regexp.MustCompile(`a=rtcp-mux\r\n`)
+	rejected := regexp.MustCompile(`m=video \d+`)
+
+	dataChannel := func(t *testing.T) webrtc.SessionDescription {
+		t.Helper()
+
+		client := newTestClient(t)
+		if _, err := client.CreateDataChannel("chat", nil); err != nil {
+			t.Fatal(err)
+		}
+		offer, err := client.CreateOffer(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return offer
+	}
+
+	tests := []struct {
+		name   string
+		offer  func(t *testing.T) webrtc.SessionDescription
+		edit   func(sdp string) string
+		bundle webrtc.BundlePolicy
+		rtcp   webrtc.RTCPMuxPolicy
+		want   error
+	}{
+		{name: "bundled with rtcp-mux", offer: clientOffer},
+		{name: "not bundled", offer: clientOffer, edit: func(s string) string { return noGroup.ReplaceAllString(s, "") }, want: errNotBundled},
+		{name: "no rtcp-mux", offer: clientOffer, edit: func(s string) string { return noRTCPMux.ReplaceAllString(s, "") }, want: errNoRTCPMux},
+		{name: "balanced allows no bundle", offer: clientOffer, edit: func(s string) string { return noGroup.ReplaceAllString(s, "") }, bundle: webrtc.BundlePolicyBalanced},
+		{name: "negotiate allows no rtcp-mux", offer: clientOffer, edit: func(s string) string { return noRTCPMux.ReplaceAllString(s, "") }, rtcp: webrtc.RTCPMuxPolicyNegotiate},
+		{name: "rejected sections don't count", offer: clientOffer, edit: func(s string) string {
+			return rejected.ReplaceAllString(noRTCPMux.ReplaceAllString(noGroup.ReplaceAllString(s, ""), ""), "m=video 0")
+		}},
+		{name: "data channel without rtcp-mux", offer: dataChannel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previousBundle, previousRTCP := bundlePolicy, rtcpMuxPolicy
+			t.Cleanup(func() { bundlePolicy, rtcpMuxPolicy = previousBundle, previousRTCP })
+			bundlePolicy, rtcpMuxPolicy = webrtc.BundlePolicyMaxBundle, webrtc.RTCPMuxPolicyRequire
+			if tt.bundle != 0 {
+				bundlePolicy = tt.bundle
+			}
+			if tt.rtcp != 0 {
+				rtcpMuxPolicy = tt.rtcp
+			}
+
+			offer := tt.offer(t)
+			if tt.edit != nil {
+				offer.SDP = tt.edit(offer.SDP)
+			}
+
+			if err := checkBundle(offer); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/webrtc/v3"
+	"log"
+	"math"
+	"strings"
+)
+
+// maxPendingCandidates bounds the candidates a client may send ahead of the
+// remote description, a browser gathers a few dozen at most
+const maxPendingCandidates = 100
+
+var errTooManyPendingCandidates = errors.New("too many candidates before the remote description")
+
+var (
+	// iceTransportPolicy set to relay forces media through TURN
+	iceTransportPolicy webrtc.ICETransportPolicy
+
+	// dropMDNSCandidates discards candidates hiding their address behind a .local name
+	dropMDNSCandidates bool
+
+	// iceCandidatePoolSize is the number of candidates gathered ahead of the
+	// first offer, handed to the clients with the welcome message
+	iceCandidatePoolSize uint8
+)
+
+func init() {
+	switch policy := strings.ToLower(config.String("ICE_TRANSPORT_POLICY", "all")); policy {
+	case "all":
+		iceTransportPolicy = webrtc.ICETransportPolicyAll
+	case "relay":
+		iceTransportPolicy = webrtc.ICETransportPolicyRelay
+	default:
+		log.Fatalf("ICE_TRANSPORT_POLICY must be all or relay, got %q", policy)
+	}
+
+	dropMDNSCandidates = config.Bool("ICE_DROP_MDNS", false)
+
+	poolSize := config.Int("ICE_CANDIDATE_POOL_SIZE", 0)
+	if poolSize < 0 || poolSize > math.MaxUint8 {
+		log.Fatalf("ICE_CANDIDATE_POOL_SIZE must be between 0 and %d, got %d", math.MaxUint8, poolSize)
+	}
+	iceCandidatePoolSize = uint8(poolSize)
+}
+
+// allowCandidate reports whether an SDP candidate line, sent by the server
+// or by a client, passes the configured policy
+func allowCandidate(candidate string) bool {
+	// candidate:<foundation> <component> <protocol> <priority> <address> <port> typ <type> ...
+	fields := strings.Fields(strings.TrimPrefix(candidate, "a="))
+	if len(fields) < 8 {
+		// End of candidates and the like carry nothing to filter
+		return true
+	}
+
+	if dropMDNSCandidates && strings.HasSuffix(fields[4], ".local") {
+		return false
+	}
+
+	if iceTransportPolicy == webrtc.ICETransportPolicyRelay {
+		for i := 6; i < len(fields)-1; i++ {
+			if fields[i] == "typ" {
+				return fields[i+1] == webrtc.ICECandidateTypeRelay.String()
+			}
+		}
+	}
+
+	return true
+}
+
+// normalizeCandidate turns the forms of an SDP candidate some clients send
+// into the one AddICECandidate takes: without the a= of an SDP line, and
+// end-of-candidates as the empty candidate
+func normalizeCandidate(candidate string) string {
+	candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "a=")
+	if candidate == "end-of-candidates" {
+		return ""
+	}
+
+	return candidate
+}
+
+// addCandidate applies a candidate of the client, or keeps it for when the
+// remote description is set if it came first, as trickling allows
+func (p *peerConnectionState) addCandidate(candidate webrtc.ICECandidateInit) error {
+	if p.peerConnection.RemoteDescription() != nil {
+		return p.peerConnection.AddICECandidate(candidate)
+	}
+
+	if len(p.pendingCandidates) == maxPendingCandidates {
+		return errTooManyPendingCandidates
+	}
+	p.pendingCandidates = append(p.pendingCandidates, candidate)
+
+	return nil
+}
+
+// flushCandidates applies the candidates kept by addCandidate, once the
+// remote description is set
+func (p *peerConnectionState) flushCandidates() error {
+	if p.peerConnection.RemoteDescription() == nil {
+		return nil
+	}
+
+	pending := p.pendingCandidates
+	p.pendingCandidates = nil
+
+	for _, candidate := range pending {
+		if err := p.peerConnection.AddICECandidate(candidate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
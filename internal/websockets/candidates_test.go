@@ -0,0 +1,113 @@
+package websockets
+
+import (
+	"github.com/pion/webrtc/v3"
+	"testing"
+)
+
+const (
+	testHostCandidate  = "candidate:1 1 udp 2130706431 192.0.2.1 5000 typ host"
+	testMDNSCandidate  = "candidate:2 1 udp 2130706431 4f1c8d2e-1b7a-4c2f-9d3e-0a1b2c3d4e5f.local 5001 typ host"
+	testSrflxCandidate = "candidate:3 1 udp 1694498815 203.0.113.7 5002 typ srflx raddr 192.0.2.1 rport 5000"
+	testRelayCandidate = "candidate:4 1 udp 16777215 198.51.100.9 5003 typ relay raddr 203.0.113.7 rport 5002"
+)
+
+// useCandidatePolicy sets the candidate filter for the test
+func useCandidatePolicy(t *testing.T, policy webrtc.ICETransportPolicy, dropMDNS bool) {
+	t.Helper()
+
+	previousPolicy, previousDropMDNS := iceTransportPolicy, dropMDNSCandidates
+	iceTransportPolicy, dropMDNSCandidates = policy, dropMDNS
+	t.Cleanup(func() { iceTransportPolicy, dropMDNSCandidates = previousPolicy, previousDropMDNS })
+}
+
+func TestAllowCandidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    webrtc.ICETransportPolicy
+		dropMDNS  bool
+		candidate string
+		want      bool
+	}{
+		{name: "host with all", policy: webrtc.ICETransportPolicyAll, candidate: testHostCandidate, want: true},
+		{name: "mDNS with all", policy: webrtc.ICETransportPolicyAll, candidate: testMDNSCandidate, want: true},
+		{name: "mDNS dropped", policy: webrtc.ICETransportPolicyAll, dropMDNS: true, candidate: testMDNSCandidate},
+		{name: "host kept when dropping mDNS", policy: webrtc.ICETransportPolicyAll, dropMDNS: true, candidate: testHostCandidate, want: true},
+		{name: "host with relay", policy: webrtc.ICETransportPolicyRelay, candidate: testHostCandidate},
+		{name: "srflx with relay", policy: webrtc.ICETransportPolicyRelay, candidate: testSrflxCandidate},
+		{name: "relay with relay", policy: webrtc.ICETransportPolicyRelay, candidate: testRelayCandidate, want: true},
+		{name: "SDP line with relay", policy: webrtc.ICETransportPolicyRelay, candidate: "a=" + testHostCandidate},
+		{name: "end of candidates with relay", policy: webrtc.ICETransportPolicyRelay, candidate: "", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useCandidatePolicy(t, tt.policy, tt.dropMDNS)
+
+			if got := allowCandidate(tt.candidate); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayOnly(t *testing.T) {
+	useCandidatePolicy(t, webrtc.ICETransportPolicyRelay, false)
+
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, welcome := joinRoom(t, srv, roomUUID)
+
+	listLock.RLock()
+	peer := findPeer(roomUUID, welcome.ConnectionID)
+	listLock.RUnlock()
+	if peer == nil {
+		t.Fatal("the peer is not in the room")
+	}
+	if policy := peer.peerConnection.GetConfiguration().ICETransportPolicy; policy != webrtc.ICETransportPolicyRelay {
+		t.Fatalf("got transport policy %s, want %s", policy, webrtc.ICETransportPolicyRelay)
+	}
+
+	// Only the relay candidate of the client is kept for the remote description
+	receiver := newTestPeer(t)
+	for _, candidate := range []string{testHostCandidate, testSrflxCandidate, testRelayCandidate} {
+		message := websocketMessage{Event: "candidate", Data: `{"candidate":"` + candidate + `","sdpMid":"0","sdpMLineIndex":0}`}
+		if !handleMessage(&fakeConn{}, receiver, "", &message) {
+			t.Fatalf("%s closed the connection", candidate)
+		}
+	}
+	if len(receiver.pendingCandidates) != 1 || receiver.pendingCandidates[0].Candidate != testRelayCandidate {
+		t.Fatalf("got %v, want only the relay candidate", receiver.pendingCandidates)
+	}
+}
+
+func TestICECandidatePoolSize(t *testing.T) {
+	previous := iceCandidatePoolSize
+	iceCandidatePoolSize = 4
+	t.Cleanup(func() { iceCandidatePoolSize = previous })
+
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, welcome := joinRoom(t, srv, roomUUID)
+	if welcome.ICECandidatePoolSize != 4 {
+		t.Fatalf("welcome got pool size %d, want 4", welcome.ICECandidatePoolSize)
+	}
+
+	waitForPeers(t, roomUUID, 1)
+	listLock.RLock()
+	peer := findPeer(roomUUID, welcome.ConnectionID)
+	listLock.RUnlock()
+	if peer == nil {
+		t.Fatalf("peer %s isn't in the room", welcome.ConnectionID)
+	}
+	if got := peer.peerConnection.GetConfiguration().ICECandidatePoolSize; got != 4 {
+		t.Fatalf("server PeerConnection got pool size %d, want 4", got)
+	}
+}
@@ -0,0 +1,120 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/b4o4/conference-backend/internal/config"
+	"log"
+	"strings"
+	"time"
+)
+
+// minEvictableIdle gives the host of a new room time to join before the room counts as stale
+const minEvictableIdle = time.Minute
+
+var (
+	ErrTooManyRooms      = errors.New("room limit reached")
+	ErrRoomFull          = errors.New("room is full")
+	ErrTooManyPublishers = errors.New("publisher limit reached")
+	ErrTooManyTracks     = errors.New("track limit of the room reached")
+)
+
+var (
+	// maxRooms bounds the rooms of the instance, 0 means no limit
+	maxRooms int
+
+	// maxParticipants bounds the peers of a room, hosts excepted, 0 means no limit
+	maxParticipants int
+
+	// maxTracksPerRoom bounds the tracks a room forwards, 0 means no limit
+	maxTracksPerRoom int
+
+	// evictStaleRooms makes room for a new room past MAX_ROOMS by removing the
+	// one empty for the longest, instead of refusing the new one
+	evictStaleRooms bool
+)
+
+func init() {
+	maxRooms = config.Int("MAX_ROOMS", 0)
+	maxParticipants = config.Int("MAX_PARTICIPANTS", 0)
+	maxTracksPerRoom = config.Int("MAX_TRACKS_PER_ROOM", 0)
+
+	switch policy := strings.ToLower(config.String("ROOM_EVICTION", "reject")); policy {
+	case "reject":
+	case "evict-stale":
+		evictStaleRooms = true
+	default:
+		log.Fatalf("ROOM_EVICTION must be reject or evict-stale, got %q", policy)
+	}
+}
+
+// Limits are the configured capacity of the instance, 0 means unlimited
+type Limits struct {
+	MaxRooms         int `json:"maxRooms"`
+	MaxParticipants  int `json:"maxParticipants"`
+	MaxTracksPerRoom int `json:"maxTracksPerRoom"`
+}
+
+func GetLimits() Limits {
+	return Limits{MaxRooms: maxRooms, MaxParticipants: maxParticipants, MaxTracksPerRoom: maxTracksPerRoom}
+}
+
+// roomsFull reports whether no more rooms may be created. listLock must be held
+func roomsFull() bool {
+	return maxRooms > 0 && len(conferences) >= maxRooms
+}
+
+// evictStaleRoom removes the room that has been empty the longest, false if
+// no room has been empty for minEvictableIdle. listLock must be held
+func evictStaleRoom() bool {
+	stale, staleSince := "", time.Now().Add(-minEvictableIdle)
+	for roomUUID, r := range conferences {
+		if len(peerConnections[roomUUID]) > 0 || r.emptySince.IsZero() || !r.emptySince.Before(staleSince) {
+			continue
+		}
+		stale, staleSince = roomUUID, r.emptySince
+	}
+
+	if stale == "" {
+		return false
+	}
+
+	log.Printf("evicting room %s, empty since %s", stale, staleSince.Format(time.RFC3339))
+	unregisterRoom(stale, "room_evicted")
+
+	return true
+}
+
+// roomFull reports whether the room takes no more guests. The room's own
+// maxParticipants replaces MAX_PARTICIPANTS. listLock must be held
+func roomFull(roomUUID string) bool {
+	limit := maxParticipants
+	if r, ok := conferences[roomUUID]; ok && r.config.MaxParticipants > 0 {
+		limit = r.config.MaxParticipants
+	}
+
+	return limit > 0 && len(peerConnections[roomUUID]) >= limit
+}
+
+// tracksFull reports whether the room forwards as many tracks as it may. listLock must be held
+func tracksFull(roomUUID string) bool {
+	return maxTracksPerRoom > 0 && len(trackLocals[roomUUID]) >= maxTracksPerRoom
+}
+
+// publishersFull reports whether peerID may not start publishing in the room,
+// peers already publishing may add tracks. listLock must be held
+func publishersFull(roomUUID, peerID string) bool {
+	limit := conferences[roomUUID].config.MaxPublishers
+	if limit == 0 {
+		return false
+	}
+
+	// Server owned tracks have no peer and don't count
+	publishers := map[string]bool{}
+	for _, t := range trackLocals[roomUUID] {
+		if t.peerID != "" {
+			publishers[t.peerID] = true
+		}
+	}
+
+	return !publishers[peerID] && len(publishers) >= limit
+}
@@ -0,0 +1,199 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/pion/webrtc/v3"
+	"testing"
+	"time"
+)
+
+func TestPublisherLimit(t *testing.T) {
+	config := DefaultRoomConfig()
+	config.MaxPublishers = 1
+	roomUUID, _, err := AddRoomUUID("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		track *localTrack
+		want  error
+	}{
+		{name: "first publisher", track: newTestTrack("alice", "camera", testVP8)},
+		{name: "first publisher's second track", track: newTestTrack("alice", "mic", testOpus)},
+		{name: "second publisher", track: newTestTrack("bob", "camera", testVP8), want: ErrTooManyPublishers},
+		{name: "server track", track: newTestTrack("", "announcement", testOpus)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := addTrack(tt.track, roomUUID); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// The denied publisher still watches the others
+	bob := newTestPeer(t)
+	bob.id = "bob"
+	bob.websocket = &fakeConn{}
+
+	listLock.Lock()
+	syncPeer(roomUUID, bob)
+	bob.answered()
+	listLock.Unlock()
+
+	received := map[string]bool{}
+	for _, sender := range bob.peerConnection.GetSenders() {
+		if sender.Track() != nil {
+			received[sender.Track().ID()] = true
+		}
+	}
+	for _, trackID := range []string{trackKey("alice", "camera"), trackKey("alice", "mic")} {
+		if !received[trackID] {
+			t.Fatalf("bob receives %v, want %s among them", received, trackID)
+		}
+	}
+	if received[trackKey("bob", "camera")] {
+		t.Fatal("bob's denied track is forwarded")
+	}
+}
+
+func TestTrackLimit(t *testing.T) {
+	previous := maxTracksPerRoom
+	maxTracksPerRoom = 2
+	t.Cleanup(func() { maxTracksPerRoom = previous })
+
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		track *localTrack
+		want  error
+	}{
+		{name: "first track", track: newTestTrack("alice", "camera", testVP8)},
+		{name: "second track", track: newTestTrack("bob", "camera", testVP8)},
+		{name: "over the limit", track: newTestTrack("alice", "mic", testOpus), want: ErrTooManyTracks},
+		{name: "server track over the limit", track: newTestTrack("", "announcement", testOpus), want: ErrTooManyTracks},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := addTrack(tt.track, roomUUID); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if got := GetLimits().MaxTracksPerRoom; got != 2 {
+		t.Fatalf("limits report %d tracks per room, want 2", got)
+	}
+}
+
+func TestRejectTrack(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason error
+		want   string
+	}{
+		{name: "publisher limit", reason: ErrTooManyPublishers, want: "publish_denied"},
+		{name: "track limit", reason: ErrTooManyTracks, want: "track_rejected"},
+		{name: "kind not allowed", reason: ErrTrackKindNotAllowed, want: "track_rejected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{}
+			rejectTrack(conn, &webrtc.TrackRemote{}, tt.reason)
+
+			message, ok := conn.message(tt.want)
+			if !ok {
+				t.Fatalf("got %v, want %s", conn.events(), tt.want)
+			}
+
+			event := trackEvent{}
+			if err := json.Unmarshal([]byte(message.Data), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Reason != tt.reason.Error() {
+				t.Fatalf("got reason %q, want %q", event.Reason, tt.reason.Error())
+			}
+		})
+	}
+}
+
+func TestEvictStaleRoom(t *testing.T) {
+	open := func(emptyFor time.Duration) string {
+		t.Helper()
+
+		roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		listLock.Lock()
+		conferences[roomUUID].emptySince = time.Now().Add(-emptyFor)
+		listLock.Unlock()
+		return roomUUID
+	}
+
+	// The busy room has been around the longest but has a peer
+	busy := open(2 * time.Hour)
+	addFakePeer(t, busy, "alice")
+	listLock.Lock()
+	conferences[busy].emptySince = time.Time{}
+	listLock.Unlock()
+
+	stale := open(time.Hour)
+	fresh := open(0)
+
+	previousMax, previousEvict := maxRooms, evictStaleRooms
+	t.Cleanup(func() {
+		listLock.Lock()
+		maxRooms, evictStaleRooms = previousMax, previousEvict
+		listLock.Unlock()
+	})
+
+	exists := func(roomUUID string) bool {
+		_, ok := RoomParticipants(roomUUID)
+		return ok
+	}
+
+	// At capacity the reject policy refuses the new room
+	listLock.Lock()
+	maxRooms, evictStaleRooms = len(conferences), false
+	listLock.Unlock()
+	if _, _, err := AddRoomUUID("", DefaultRoomConfig()); !errors.Is(err, ErrTooManyRooms) {
+		t.Fatalf("reject policy: got %v, want %v", err, ErrTooManyRooms)
+	}
+	if !exists(stale) {
+		t.Fatal("the reject policy evicted a room")
+	}
+
+	listLock.Lock()
+	evictStaleRooms = true
+	listLock.Unlock()
+	created, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatalf("evict-stale policy: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		roomUUID string
+		want     bool
+	}{
+		{name: "the room empty the longest", roomUUID: stale},
+		{name: "the busy room", roomUUID: busy, want: true},
+		{name: "the room just emptied", roomUUID: fresh, want: true},
+		{name: "the new room", roomUUID: created, want: true},
+	} {
+		if got := exists(tt.roomUUID); got != tt.want {
+			t.Errorf("%s: got it kept %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package websockets
+
+import (
+	"fmt"
+	"github.com/pion/webrtc/v3"
+	"sort"
+	"strings"
+)
+
+// codecPreferences is the order codecs are offered to clients in, by name
+// (VP9, opus...). Codecs not listed follow in their registration order
+var codecPreferences []string
+
+// parseCodecPreferences reads the comma separated codec names, each must be
+// one the engine negotiates
+func parseCodecPreferences(names string) ([]string, error) {
+	preferences := []string{}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			preferences = append(preferences, name)
+		}
+	}
+
+	if len(preferences) == 0 {
+		return nil, nil
+	}
+
+	supported, err := supportedCodecs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range preferences {
+		if !supported[strings.ToLower(name)] {
+			return nil, fmt.Errorf("unknown codec %q", name)
+		}
+	}
+
+	return preferences, nil
+}
+
+// supportedCodecs returns the lowercase names of the codecs api registered,
+// read from the receivers of a throwaway PeerConnection
+func supportedCodecs() (map[string]bool, error) {
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close() //nolint
+
+	supported := map[string]bool{}
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		transceiver, err := pc.AddTransceiverFromKind(kind)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, codec := range transceiver.Receiver().GetParameters().Codecs {
+			supported[strings.ToLower(codecName(codec))] = true
+		}
+	}
+
+	return supported, nil
+}
+
+// codecName strips the kind from the MIME type, video/VP9 is VP9
+func codecName(codec webrtc.RTPCodecParameters) string {
+	_, name, _ := strings.Cut(codec.MimeType, "/")
+	return name
+}
+
+// codecRank is the position of the codec in codecPreferences, unlisted
+// codecs rank after all the listed ones
+func codecRank(codec webrtc.RTPCodecParameters) int {
+	for i, name := range codecPreferences {
+		if strings.EqualFold(name, codecName(codec)) {
+			return i
+		}
+	}
+
+	return len(codecPreferences)
+}
+
+// preferCodecs orders the codecs of the transceiver by codecPreferences, it
+// must be called before the first offer
+func preferCodecs(transceiver *webrtc.RTPTransceiver) error {
+	if len(codecPreferences) == 0 {
+		return nil
+	}
+
+	codecs := transceiver.Receiver().GetParameters().Codecs
+	sort.SliceStable(codecs, func(i, j int) bool {
+		return codecRank(codecs[i]) < codecRank(codecs[j])
+	})
+
+	return transceiver.SetCodecPreferences(codecs)
+}
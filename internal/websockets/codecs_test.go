@@ -0,0 +1,90 @@
+package websockets
+
+import (
+	"github.com/pion/webrtc/v3"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParseCodecPreferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", names: ""},
+		{name: "blank entries", names: " , ,"},
+		{name: "listed codecs", names: "VP9, vp8,opus", want: []string{"VP9", "vp8", "opus"}},
+		{name: "unknown codec", names: "VP9,H265", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCodecPreferences(tt.names)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreferCodecs(t *testing.T) {
+	tests := []struct {
+		name        string
+		preferences []string
+		want        string
+	}{
+		{name: "registration order", want: "VP8"},
+		{name: "VP9 first", preferences: []string{"VP9", "VP8"}, want: "VP9"},
+		{name: "case insensitive", preferences: []string{"h264"}, want: "H264"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := codecPreferences
+			codecPreferences = tt.preferences
+			t.Cleanup(func() { codecPreferences = previous })
+
+			peer := newTestPeer(t)
+			transceiver, err := peer.peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionRecvonly,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := preferCodecs(transceiver); err != nil {
+				t.Fatal(err)
+			}
+
+			offer, err := peer.peerConnection.CreateOffer(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The first payload type of the m-line is the codec the client picks
+			desc := parseSDP(t, offer)
+			payloadType, err := strconv.Atoi(desc.MediaDescriptions[0].MediaName.Formats[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, err := desc.GetCodecForPayloadType(uint8(payloadType))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codec.Name != tt.want {
+				t.Fatalf("got %s offered first, want %s", codec.Name, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/b4o4/conference-backend/internal/config"
+	"log"
+	"strings"
+)
+
+var (
+	ErrAwaitingConsent      = errors.New("recording waits for the participants' consent")
+	ErrInvalidConsentPolicy = errors.New("consent policy must be none, all, majority or host")
+)
+
+// Consent policies, deciding when a recording the host asked for may run
+const (
+	ConsentNone     = "none"
+	ConsentAll      = "all"
+	ConsentMajority = "majority"
+	ConsentHost     = "host"
+)
+
+// defaultConsentPolicy is the policy of new rooms
+var defaultConsentPolicy string
+
+func init() {
+	defaultConsentPolicy = strings.ToLower(config.String("RECORDING_CONSENT", ConsentNone))
+	if !validConsentPolicy(defaultConsentPolicy) {
+		log.Fatalf("RECORDING_CONSENT: %v, got %q", ErrInvalidConsentPolicy, defaultConsentPolicy)
+	}
+}
+
+func validConsentPolicy(policy string) bool {
+	switch policy {
+	case ConsentNone, ConsentAll, ConsentMajority, ConsentHost:
+		return true
+	default:
+		return false
+	}
+}
+
+// RecordingConsent is where a room stands on recording: whether the host
+// asked for it, whether it runs, and which peers agreed
+type RecordingConsent struct {
+	Policy    string   `json:"policy"`
+	Requested bool     `json:"requested"`
+	Recording bool     `json:"recording"`
+	Consented []string `json:"consented"`
+	Pending   []string `json:"pending"`
+}
+
+// consentGiven reports whether the peers of the room agree to be recorded
+// under its policy. listLock must be held
+func (r *room) consentGiven(roomUUID string) bool {
+	peers := peerConnections[roomUUID]
+
+	switch r.consentPolicy {
+	case ConsentAll:
+		for _, p := range peers {
+			if !p.recordingConsent {
+				return false
+			}
+		}
+		return true
+	case ConsentMajority:
+		consented := 0
+		for _, p := range peers {
+			if p.recordingConsent {
+				consented++
+			}
+		}
+		return 2*consented > len(peers)
+	case ConsentHost:
+		for _, p := range peers {
+			if p.isHost && p.recordingConsent {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// applyConsent starts the requested recording once the peers agree, and
+// stops it while they don't, e.g. after a peer withdrew or someone joined.
+// Its files are opened and closed once listLock is released. listLock must
+// be held
+func applyConsent(roomUUID string) {
+	r, ok := conferences[roomUUID]
+	if !ok || r.expired || !r.recordingRequested {
+		return
+	}
+
+	switch given := r.consentGiven(roomUUID); {
+	case given && r.recording == nil:
+		r.startRecording(roomUUID)
+	case !given && r.recording != nil:
+		log.Printf("room %s lost recording consent, recording stopped", roomUUID)
+		r.finishRecording(roomUUID)
+	}
+}
+
+// requestConsent asks the peer, or the whole room when peer is nil, to
+// consent to the recording. listLock must be held
+func requestConsent(roomUUID string, r *room, peer *peerConnectionState) {
+	if r.consentPolicy == ConsentNone || !r.recordingRequested {
+		return
+	}
+
+	message := websocketMessage{Event: "recording_consent_request"}
+	if peer == nil {
+		broadcast(roomUUID, message, nil)
+		return
+	}
+
+	if err := peer.websocket.WriteJSON(&message); err != nil {
+		logSampled(err)
+	}
+}
+
+// setRecordingConsent records the answer of the peer to a consent request,
+// data is a JSON boolean. Consent can be withdrawn at any time
+func setRecordingConsent(conn signalConn, roomUUID string, peer *peerConnectionState, message *websocketMessage) {
+	consent := false
+	if err := json.Unmarshal([]byte(message.Data), &consent); err != nil {
+		sendError(conn, "consent must be true or false")
+		return
+	}
+
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	peer.recordingConsent = consent
+	applyConsent(roomUUID)
+}
+
+// GetRecordingConsent returns the consent state of the room
+func GetRecordingConsent(roomUUID string) (RecordingConsent, error) {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return RecordingConsent{}, ErrRoomNotFound
+	}
+
+	consent := RecordingConsent{
+		Policy:    r.consentPolicy,
+		Requested: r.recordingRequested,
+		Recording: r.recording != nil,
+		Consented: []string{},
+		Pending:   []string{},
+	}
+	for _, p := range peerConnections[roomUUID] {
+		if p.recordingConsent {
+			consent.Consented = append(consent.Consented, p.id)
+		} else {
+			consent.Pending = append(consent.Pending, p.id)
+		}
+	}
+
+	return consent, nil
+}
+
+// SetConsentPolicy changes the consent policy of the room, a recording in
+// progress follows the new policy at once. Only the host may do it
+func SetConsentPolicy(roomUUID, hostKey, policy string) error {
+	if !validConsentPolicy(policy) {
+		return ErrInvalidConsentPolicy
+	}
+
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !r.isHost(hostKey) {
+		return ErrNotHost
+	}
+
+	if r.consentPolicy == policy {
+		return nil
+	}
+
+	r.consentPolicy = policy
+	requestConsent(roomUUID, r, nil)
+	applyConsent(roomUUID)
+
+	return nil
+}
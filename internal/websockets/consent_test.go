@@ -0,0 +1,144 @@
+package websockets
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConsentGiven(t *testing.T) {
+	// Each peer is given as host and consent flags
+	type peer struct{ host, consent bool }
+
+	tests := []struct {
+		name   string
+		policy string
+		peers  []peer
+		want   bool
+	}{
+		{name: "none needs nobody", policy: ConsentNone, peers: []peer{{false, false}}, want: true},
+		{name: "all agreed", policy: ConsentAll, peers: []peer{{true, true}, {false, true}}, want: true},
+		{name: "all but one", policy: ConsentAll, peers: []peer{{true, true}, {false, false}}, want: false},
+		{name: "all of an empty room", policy: ConsentAll, want: true},
+		{name: "majority", policy: ConsentMajority, peers: []peer{{false, true}, {false, true}, {false, false}}, want: true},
+		{name: "half is no majority", policy: ConsentMajority, peers: []peer{{false, true}, {false, false}}, want: false},
+		{name: "majority of an empty room", policy: ConsentMajority, want: false},
+		{name: "host agreed", policy: ConsentHost, peers: []peer{{true, true}, {false, false}}, want: true},
+		{name: "host didn't", policy: ConsentHost, peers: []peer{{true, false}, {false, true}}, want: false},
+		{name: "no host", policy: ConsentHost, peers: []peer{{false, true}}, want: false},
+	}
+
+	const roomUUID = "consent-room"
+	t.Cleanup(func() {
+		listLock.Lock()
+		delete(peerConnections, roomUUID)
+		listLock.Unlock()
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var peers []*peerConnectionState
+			for _, p := range tt.peers {
+				peers = append(peers, &peerConnectionState{isHost: p.host, recordingConsent: p.consent})
+			}
+
+			listLock.Lock()
+			defer listLock.Unlock()
+
+			peerConnections[roomUUID] = peers
+			r := &room{consentPolicy: tt.policy}
+			if got := r.consentGiven(roomUUID); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetRecordingConsentRejects(t *testing.T) {
+	conn := &fakeConn{}
+	peer := &peerConnectionState{id: "peer", recordingConsent: true}
+
+	setRecordingConsent(conn, "", peer, &websocketMessage{Event: "consent", Data: `"yes"`})
+
+	if events := conn.events(); len(events) != 1 || events[0] != "error" {
+		t.Fatalf("got events %v, want [error]", events)
+	}
+	if !peer.recordingConsent {
+		t.Fatal("a malformed answer withdrew the consent")
+	}
+}
+
+// waitForRecording waits until the room is, or is no longer, being recorded
+func waitForRecording(t *testing.T, roomUUID string, recording bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		consent, err := GetRecordingConsent(roomUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if consent.Recording == recording {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got recording %v, want %v", consent.Recording, recording)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsentStartsAndStopsTheRecording(t *testing.T) {
+	useRecordingDir(t)
+	srv := newTestServer(t)
+
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, _ := joinRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 1)
+
+	if err := SetConsentPolicy(roomUUID, hostKey, ConsentAll); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StartRecording(roomUUID, hostKey); !errors.Is(err, ErrAwaitingConsent) {
+		t.Fatalf("got %v, want %v", err, ErrAwaitingConsent)
+	}
+	if _, ok := nextEvent(t, ws, "recording_consent_request", 5*time.Second); !ok {
+		t.Fatal("no recording_consent_request")
+	}
+
+	if err := ws.WriteJSON(websocketMessage{Event: "consent", Data: "true"}); err != nil {
+		t.Fatal(err)
+	}
+	waitForRecording(t, roomUUID, true)
+
+	listLock.RLock()
+	rec := conferences[roomUUID].recording
+	listLock.RUnlock()
+
+	// With the file work held up, withdrawing the consent still stops the
+	// recording at once, the manifest is finished afterwards
+	release := make(chan struct{})
+	rec.do(func() { <-release })
+
+	if err := ws.WriteJSON(websocketMessage{Event: "consent", Data: "false"}); err != nil {
+		t.Fatal(err)
+	}
+	waitForRecording(t, roomUUID, false)
+
+	close(release)
+	if _, err := rec.wait(); err != nil {
+		t.Fatal(err)
+	}
+	if manifest := readManifest(t, rec); manifest.StoppedAt == nil {
+		t.Fatal("manifest.json of the stopped recording has no stoppedAt")
+	}
+
+	// The room left empty would otherwise consent once the test is over,
+	// recording outside the test's directory
+	if _, err := StopRecording(roomUUID, hostKey); err != nil {
+		t.Fatal(err)
+	}
+}
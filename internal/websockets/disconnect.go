@@ -0,0 +1,22 @@
+package websockets
+
+import "github.com/gorilla/websocket"
+
+// participantLeft tells observers a peer left the room, graceful when its
+// client closed the websocket on purpose
+type participantLeft struct {
+	PeerID   string `json:"peerId"`
+	Graceful bool   `json:"graceful"`
+}
+
+// logDisconnect logs why the read loop of a peer ended and reports whether
+// the client closed the websocket normally
+func logDisconnect(err error) bool {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		logSampled("info:", err)
+		return true
+	}
+
+	logSampled("warn:", err)
+	return false
+}
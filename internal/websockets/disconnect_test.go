@@ -0,0 +1,50 @@
+package websockets
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogDisconnect(t *testing.T) {
+	// Every message is logged, whatever the ones before it
+	previous := sampler
+	sampler = newLogSampler(0)
+	t.Cleanup(func() { sampler = previous })
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	tests := []struct {
+		name         string
+		err          error
+		wantGraceful bool
+		wantLevel    string
+	}{
+		{name: "normal closure", err: &websocket.CloseError{Code: websocket.CloseNormalClosure}, wantGraceful: true, wantLevel: "info:"},
+		{name: "going away", err: &websocket.CloseError{Code: websocket.CloseGoingAway}, wantGraceful: true, wantLevel: "info:"},
+		{name: "abnormal closure", err: &websocket.CloseError{Code: websocket.CloseAbnormalClosure}, wantLevel: "warn:"},
+		{name: "protocol error", err: &websocket.CloseError{Code: websocket.CloseProtocolError}, wantLevel: "warn:"},
+		{name: "connection dropped", err: io.ErrUnexpectedEOF, wantLevel: "warn:"},
+		{name: "read error", err: errors.New("read: connection reset by peer"), wantLevel: "warn:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+
+			if got := logDisconnect(tt.err); got != tt.wantGraceful {
+				t.Fatalf("got graceful %v, want %v", got, tt.wantGraceful)
+			}
+			if got := logs.String(); !strings.Contains(got, tt.wantLevel+" "+tt.err.Error()) {
+				t.Fatalf("got log %q, want %s level", got, tt.wantLevel)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package websockets
+
+import "encoding/json"
+
+// e2eeKeyMessage carries the key material clients encrypt their media with.
+// The server relays it as is and never logs it: clients send it with the peer
+// it is meant for, or none for every other peer, and receive it with the sender
+type e2eeKeyMessage struct {
+	PeerID string          `json:"peerId,omitempty"`
+	Key    json.RawMessage `json:"key"`
+}
+
+// relayE2EEKey hands the key of a peer to the other peers of the room. Unlike
+// broadcast it skips the observers, they have no business with the keys
+func relayE2EEKey(conn signalConn, roomUUID string, from *peerConnectionState, message *websocketMessage) {
+	key := e2eeKeyMessage{}
+	if err := json.Unmarshal([]byte(message.Data), &key); err != nil || len(key.Key) == 0 {
+		sendError(conn, "malformed e2ee key")
+		return
+	}
+
+	data, err := json.Marshal(e2eeKeyMessage{PeerID: from.id, Key: key.Key})
+	if err != nil {
+		sendError(conn, "malformed e2ee key")
+		return
+	}
+	relayed := &websocketMessage{Event: "e2ee_key", Data: string(data)}
+
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	if key.PeerID != "" {
+		to := findPeer(roomUUID, key.PeerID)
+		if to == nil || to == from {
+			sendError(conn, "unknown peer")
+			return
+		}
+
+		if err := to.websocket.WriteJSON(relayed); err != nil {
+			logSampled(err)
+		}
+		return
+	}
+
+	for _, p := range peerConnections[roomUUID] {
+		if p == from {
+			continue
+		}
+
+		if err := p.websocket.WriteJSON(relayed); err != nil {
+			logSampled(err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package websockets
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRelayE2EEKey(t *testing.T) {
+	const secret = "c2VjcmV0LWtleS1tYXRlcmlhbA"
+
+	// Everything logged meanwhile is kept to look for the key
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := map[string]*fakeConn{}
+	for _, peerID := range []string{"alice", "bob", "carol"} {
+		conns[peerID] = addFakePeer(t, roomUUID, peerID)
+	}
+
+	// A peer whose writes fail makes the relay log the error
+	mallory := addFakePeer(t, roomUUID, "mallory")
+	mallory.failWrites = true
+
+	listLock.RLock()
+	alice := findPeer(roomUUID, "alice")
+	listLock.RUnlock()
+
+	// relayed returns the key the peer got from alice, empty if none
+	relayed := func(peerID string) string {
+		message, ok := conns[peerID].message("e2ee_key")
+		if !ok {
+			return ""
+		}
+
+		key := e2eeKeyMessage{}
+		if err := json.Unmarshal([]byte(message.Data), &key); err != nil {
+			t.Fatal(err)
+		}
+		if key.PeerID != "alice" {
+			t.Fatalf("%s got a key from %q, want alice", peerID, key.PeerID)
+		}
+		return string(key.Key)
+	}
+
+	send := func(data string) {
+		t.Helper()
+
+		for _, conn := range conns {
+			conn.mu.Lock()
+			conn.written = nil
+			conn.mu.Unlock()
+		}
+		if !handleMessage(conns["alice"], alice, roomUUID, &websocketMessage{Event: "e2ee_key", Data: data}) {
+			t.Fatal("the key closed the connection")
+		}
+	}
+
+	send(`{"key":"` + secret + `"}`)
+	for _, peerID := range []string{"bob", "carol"} {
+		if got := relayed(peerID); got != `"`+secret+`"` {
+			t.Fatalf("%s got key %s, want %q", peerID, got, secret)
+		}
+	}
+	if got := relayed("alice"); got != "" {
+		t.Fatal("alice got her own key back")
+	}
+
+	send(`{"peerId":"bob","key":"` + secret + `"}`)
+	if relayed("bob") == "" || relayed("carol") != "" {
+		t.Fatalf("got bob %v and carol %v, want the key sent to bob alone", conns["bob"].events(), conns["carol"].events())
+	}
+
+	for _, data := range []string{
+		`{"peerId":"nobody","key":"` + secret + `"}`,
+		`{"peerId":"alice","key":"` + secret + `"}`,
+		`{"key":"` + secret + `"`,
+	} {
+		send(data)
+		if events := conns["alice"].events(); len(events) != 1 || events[0] != "error" {
+			t.Fatalf("%s: got %v, want an error", data, events)
+		}
+	}
+	send(`{"peerId":"mallory","key":"` + secret + `"}`)
+
+	log.SetOutput(os.Stderr)
+	if strings.Contains(logs.String(), secret) {
+		t.Fatalf("the key was logged: %s", logs.String())
+	}
+}
@@ -0,0 +1,116 @@
+package websockets
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJoinError(t *testing.T) {
+	open := func(t *testing.T, config RoomConfig) string {
+		t.Helper()
+
+		roomUUID, _, err := AddRoomUUID("", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return roomUUID
+	}
+
+	full := DefaultRoomConfig()
+	full.MaxParticipants = 1
+
+	tests := []struct {
+		name   string
+		room   func(t *testing.T) string
+		isHost bool
+		want   error
+	}{
+		{
+			name: "open room",
+			room: func(t *testing.T) string { return open(t, DefaultRoomConfig()) },
+		},
+		{
+			name: "unknown room",
+			room: func(t *testing.T) string { return "no-such-room" },
+			want: ErrRoomNotFound,
+		},
+		{
+			name: "expired room",
+			room: func(t *testing.T) string {
+				roomUUID := open(t, DefaultRoomConfig())
+				listLock.Lock()
+				conferences[roomUUID].expired = true
+				listLock.Unlock()
+				return roomUUID
+			},
+			isHost: true,
+			want:   ErrRoomExpired,
+		},
+		{
+			name: "full room",
+			room: func(t *testing.T) string {
+				roomUUID := open(t, full)
+				addFakePeer(t, roomUUID, "alice")
+				return roomUUID
+			},
+			want: ErrRoomFull,
+		},
+		{
+			name: "host in a full room",
+			room: func(t *testing.T) string {
+				roomUUID := open(t, full)
+				addFakePeer(t, roomUUID, "alice")
+				return roomUUID
+			},
+			isHost: true,
+		},
+		{
+			name: "locked room",
+			room: func(t *testing.T) string {
+				roomUUID := open(t, DefaultRoomConfig())
+				listLock.Lock()
+				conferences[roomUUID].locked = true
+				listLock.Unlock()
+				return roomUUID
+			},
+			want: ErrRoomLocked,
+		},
+		{
+			name: "host in a locked room",
+			room: func(t *testing.T) string {
+				roomUUID := open(t, DefaultRoomConfig())
+				listLock.Lock()
+				conferences[roomUUID].locked = true
+				listLock.Unlock()
+				return roomUUID
+			},
+			isHost: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomUUID := tt.room(t)
+
+			listLock.RLock()
+			err := joinError(roomUUID, tt.isHost)
+			listLock.RUnlock()
+
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotHostIsUnauthorized(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SetRoomLocked(roomUUID, "guess", true)
+	if !errors.Is(err, ErrNotHost) || !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("got %v, want %v wrapping %v", err, ErrNotHost, ErrUnauthorized)
+	}
+}
@@ -0,0 +1,78 @@
+package websockets
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// roomExpiryWarning is how long before the end of a room its peers are warned
+const roomExpiryWarning = time.Minute
+
+type roomExpiring struct {
+	SecondsLeft int `json:"secondsLeft"`
+}
+
+// scheduleExpiry ends the room once its max duration is over, warning the
+// peers shortly before. listLock must be held
+func scheduleExpiry(roomUUID string, r *room) {
+	duration := time.Duration(r.config.MaxDurationSeconds) * time.Second
+	if duration == 0 {
+		return
+	}
+
+	warning := roomExpiryWarning
+	if warning >= duration {
+		warning = duration / 2
+	}
+
+	expiresAt := r.createdAt.Add(duration)
+	time.AfterFunc(time.Until(expiresAt.Add(-warning)), func() {
+		warnExpiry(roomUUID, r, expiresAt)
+	})
+	time.AfterFunc(time.Until(expiresAt), func() {
+		expireRoom(roomUUID, r)
+	})
+}
+
+func warnExpiry(roomUUID string, r *room, expiresAt time.Time) {
+	data, err := json.Marshal(roomExpiring{SecondsLeft: int(time.Until(expiresAt).Round(time.Second).Seconds())})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	if conferences[roomUUID] != r {
+		return
+	}
+
+	broadcast(roomUUID, websocketMessage{Event: "room_expiring", Data: string(data)}, nil)
+}
+
+// expireRoom closes every connection of the room, then removes it
+func expireRoom(roomUUID string, r *room) {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	if conferences[roomUUID] != r {
+		return
+	}
+
+	r.expired = true
+	r.events.append(AuditExpire, "")
+	if r.recording != nil {
+		r.finishRecording(roomUUID)
+	}
+
+	log.Printf("room %s reached its max duration", roomUUID)
+
+	for _, p := range peerConnections[roomUUID] {
+		disconnect(p.websocket, ErrRoomExpired)
+	}
+	notifyObservers(roomUUID, websocketMessage{Event: "room_expired"})
+
+	removeRoom(roomUUID)
+}
@@ -0,0 +1,78 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestExpireRoom(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := dialRoom(t, srv, roomUUID)
+
+	waitForPeers(t, roomUUID, 1)
+
+	listLock.RLock()
+	r := conferences[roomUUID]
+	listLock.RUnlock()
+	expireRoom(roomUUID, r)
+
+	events, closeErr := readUntilClose(t, ws)
+	if closeErr.Code != websocket.CloseNormalClosure {
+		t.Fatalf("got close code %d, want %d", closeErr.Code, websocket.CloseNormalClosure)
+	}
+	if len(events) == 0 || events[len(events)-1] != "room_expired" {
+		t.Fatalf("got events %v, want them to end with room_expired", events)
+	}
+
+	listLock.RLock()
+	_, registered := conferences[roomUUID]
+	_, peers := peerConnections[roomUUID]
+	_, tracks := trackLocals[roomUUID]
+	listLock.RUnlock()
+	if registered || peers || tracks {
+		t.Fatalf("the expired room is still registered: room %v, peers %v, tracks %v", registered, peers, tracks)
+	}
+}
+
+func TestRoomExpiresOnItsOwn(t *testing.T) {
+	srv := newTestServer(t)
+
+	config := DefaultRoomConfig()
+	config.MaxDurationSeconds = 2
+	roomUUID, _, err := AddRoomUUID("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, _ := joinRoom(t, srv, roomUUID)
+
+	// Shorter than roomExpiryWarning, the warning comes halfway through
+	message, ok := nextEvent(t, ws, "room_expiring", 3*time.Second)
+	if !ok {
+		t.Fatal("no room_expiring before the end of the room")
+	}
+	expiring := roomExpiring{}
+	if err := json.Unmarshal([]byte(message.Data), &expiring); err != nil {
+		t.Fatal(err)
+	}
+	if expiring.SecondsLeft != 1 {
+		t.Fatalf("got %d seconds left, want 1", expiring.SecondsLeft)
+	}
+
+	events, closeErr := readUntilClose(t, ws)
+	if closeErr.Code != websocket.CloseNormalClosure {
+		t.Fatalf("got close code %d, want %d", closeErr.Code, websocket.CloseNormalClosure)
+	}
+	if len(events) == 0 || events[len(events)-1] != "room_expired" {
+		t.Fatalf("got events %v, want them to end with room_expired", events)
+	}
+
+	if _, ok := RoomParticipants(roomUUID); ok {
+		t.Fatal("the expired room is still registered")
+	}
+}
@@ -0,0 +1,488 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// downTrackQueueSize is how many packets may wait for a subscriber before
+	// new ones are dropped
+	downTrackQueueSize int
+
+	// packetsDroppedTotal counts the packets dropped for every subscriber
+	// since startup, those who already left included
+	packetsDroppedTotal atomic.Uint64
+)
+
+func init() {
+	downTrackQueueSize = config.Int("SEND_QUEUE_SIZE", 256)
+	if downTrackQueueSize == 0 {
+		log.Fatal("SEND_QUEUE_SIZE must be at least 1")
+	}
+}
+
+// localTrack is a track published to the room. Incoming packets are fanned
+// out to a downTrack per subscriber so every subscriber can be controlled on its own
+type localTrack struct {
+	id          string
+	streamID    string
+	codec       webrtc.RTPCodecCapability
+	payloadType webrtc.PayloadType
+	peerID      string
+
+	// remoteID is the ID the publisher gave the track, id is made unique
+	// in the room from it, see trackKey
+	remoteID string
+
+	// codecs maps the payload types the publisher negotiated, FEC and other
+	// auxiliary payloads included, to their codec
+	codecs map[webrtc.PayloadType]webrtc.RTPCodecCapability
+
+	// publisher and ssrc identify the incoming stream for RTCP feedback
+	publisher *webrtc.PeerConnection
+	ssrc      webrtc.SSRC
+
+	mu          sync.RWMutex
+	subscribers map[string]*downTrack
+	recorder    *trackRecorder
+
+	// held tracks are paused for every subscriber by the host
+	held bool
+
+	// orphaned runs out the grace period of a publisher that went away,
+	// see orphanTrack. Guarded by listLock
+	orphaned   *time.Timer
+	orphanedAt time.Time
+
+	// ownerSubject and reconnectToken identify the publisher, only a peer
+	// proving to be it may adopt the track once orphaned. Guarded by listLock
+	ownerSubject   string
+	reconnectToken string
+}
+
+// mimeTypeTelephoneEvent is the DTMF of SIP/PSTN gateways, see DTMF_ENABLED
+const mimeTypeTelephoneEvent = "audio/telephone-event"
+
+func newLocalTrack(t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver, publisher *webrtc.PeerConnection, peerID string) *localTrack {
+	primary := t.Codec()
+	codecs := map[webrtc.PayloadType]webrtc.RTPCodecCapability{
+		t.PayloadType(): primary.RTPCodecCapability,
+	}
+	for _, codec := range receiver.GetParameters().Codecs {
+		codecs[codec.PayloadType] = codec.RTPCodecCapability
+	}
+
+	// The track may start with a DTMF event, it still is the audio the events accompany
+	if strings.EqualFold(primary.MimeType, mimeTypeTelephoneEvent) {
+		for _, codec := range receiver.GetParameters().Codecs {
+			if !strings.EqualFold(codec.MimeType, mimeTypeTelephoneEvent) {
+				primary = codec
+				break
+			}
+		}
+	}
+
+	return &localTrack{
+		id:          trackKey(peerID, t.ID()),
+		remoteID:    t.ID(),
+		streamID:    t.StreamID(),
+		codec:       primary.RTPCodecCapability,
+		payloadType: primary.PayloadType,
+		codecs:      codecs,
+		peerID:      peerID,
+		publisher:   publisher,
+		ssrc:        t.SSRC(),
+		subscribers: make(map[string]*downTrack),
+	}
+}
+
+func (t *localTrack) ID() string { return t.id }
+
+// trackKey identifies a published track in its room and towards the
+// subscribers. Publishers pick their track IDs themselves, so two of them may
+// well pick the same one
+func trackKey(peerID, trackID string) string {
+	return peerID + "-" + trackID
+}
+
+func (t *localTrack) Kind() webrtc.RTPCodecType {
+	return codecKind(t.codec)
+}
+
+func codecKind(codec webrtc.RTPCodecCapability) webrtc.RTPCodecType {
+	switch {
+	case strings.HasPrefix(codec.MimeType, "audio/"):
+		return webrtc.RTPCodecTypeAudio
+	case strings.HasPrefix(codec.MimeType, "video/"):
+		return webrtc.RTPCodecTypeVideo
+	default:
+		return webrtc.RTPCodecType(0)
+	}
+}
+
+// subscribe creates the downTrack forwarding this track to the given peer
+func (t *localTrack) subscribe(subscriber *peerConnectionState) (*downTrack, error) {
+	d := &downTrack{
+		source:     t,
+		bytesOut:   &subscriber.bytesOut,
+		dropped:    &subscriber.packetsDropped,
+		translator: rtpTranslator{clockRate: t.codec.ClockRate},
+	}
+
+	t.mu.Lock()
+	t.subscribers[subscriber.id] = d
+	t.mu.Unlock()
+
+	return d, nil
+}
+
+func (t *localTrack) unsubscribe(peerID string) {
+	t.mu.Lock()
+	delete(t.subscribers, peerID)
+	t.mu.Unlock()
+}
+
+// subscriber returns the downTrack of the given peer, if any
+func (t *localTrack) subscriber(peerID string) (*downTrack, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	d, ok := t.subscribers[peerID]
+	return d, ok
+}
+
+// keyFrame asks the publisher for a fresh keyframe
+func (t *localTrack) keyFrame() {
+	t.mu.RLock()
+	publisher, ssrc := t.publisher, t.ssrc
+	t.mu.RUnlock()
+
+	if publisher == nil {
+		return
+	}
+
+	_ = publisher.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)},
+	})
+}
+
+// pauseAll stops forwarding the track to every subscriber
+func (t *localTrack) pauseAll() {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, d := range t.subscribers {
+		d.pause()
+	}
+}
+
+func (t *localTrack) resumeAll() {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, d := range t.subscribers {
+		d.resume()
+	}
+}
+
+// hold pauses the track for every subscriber until release
+func (t *localTrack) hold() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.held = true
+	for _, d := range t.subscribers {
+		d.pause()
+	}
+}
+
+// release lifts hold, forward tells whether subscribers get the track again right away
+func (t *localTrack) release(forward bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.held = false
+	if forward {
+		for _, d := range t.subscribers {
+			d.resume()
+		}
+	}
+}
+
+func (t *localTrack) isHeld() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.held
+}
+
+// writeRTP forwards a packet to every subscriber. A failing or slow
+// subscriber doesn't affect the others
+func (t *localTrack) writeRTP(p *rtp.Packet) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	// Subscribers write from their own goroutine, the payload must outlive the read buffer
+	if len(t.subscribers) > 0 {
+		payload := append([]byte(nil), p.Payload...)
+		for _, d := range t.subscribers {
+			d.writeRTP(p.Header, payload)
+		}
+	}
+
+	if t.recorder != nil {
+		t.recorder.writeRTP(p)
+	}
+}
+
+// downTrack is the copy of a localTrack sent to a single subscriber. It is
+// the webrtc.TrackLocal added to the subscriber's PeerConnection
+type downTrack struct {
+	source   *localTrack
+	bytesOut *atomic.Uint64
+	dropped  *atomic.Uint64
+
+	mu         sync.Mutex
+	translator rtpTranslator
+	ssrc       webrtc.SSRC
+
+	// queue feeds the goroutine writing to the subscriber while the track is bound
+	queue chan queuedPacket
+
+	// payloadTypes maps publisher payload types to the ones the subscriber
+	// negotiated. Payloads the subscriber can't take are dropped
+	payloadTypes map[webrtc.PayloadType]webrtc.PayloadType
+}
+
+func (d *downTrack) ID() string       { return d.source.id }
+func (d *downTrack) RID() string      { return "" }
+func (d *downTrack) StreamID() string { return d.source.streamID }
+
+func (d *downTrack) Kind() webrtc.RTPCodecType {
+	return d.source.Kind()
+}
+
+// Bind is called by the PeerConnection once negotiation is complete
+func (d *downTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	negotiated := ctx.CodecParameters()
+
+	primary, ok := matchCodec(d.source.codec, negotiated)
+	if !ok {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+
+	payloadTypes := d.source.payloadTypesFor(negotiated)
+
+	queue := make(chan queuedPacket, downTrackQueueSize)
+	go d.writeLoop(queue, ctx.WriteStream())
+
+	d.mu.Lock()
+	if d.queue != nil {
+		close(d.queue)
+	}
+	d.ssrc = ctx.SSRC()
+	d.queue = queue
+	d.payloadTypes = payloadTypes
+	d.mu.Unlock()
+
+	return primary, nil
+}
+
+func (d *downTrack) Unbind(webrtc.TrackLocalContext) error {
+	d.mu.Lock()
+	if d.queue != nil {
+		close(d.queue)
+		d.queue = nil
+	}
+	d.mu.Unlock()
+
+	return nil
+}
+
+// payloadTypesFor maps the payload types of the publisher, FEC and RED
+// included, to the ones a subscriber negotiated. Payloads the subscriber
+// can't take are left out
+func (t *localTrack) payloadTypesFor(negotiated []webrtc.RTPCodecParameters) map[webrtc.PayloadType]webrtc.PayloadType {
+	payloadTypes := make(map[webrtc.PayloadType]webrtc.PayloadType, len(t.codecs))
+	for payloadType, codec := range t.codecs {
+		if match, ok := matchCodec(codec, negotiated); ok {
+			payloadTypes[payloadType] = match.PayloadType
+		}
+	}
+
+	return payloadTypes
+}
+
+// matchCodec finds the negotiated codec for a publisher codec, on mime type
+// and fmtp first, then on mime type alone. The clock rates must agree, as
+// telephone-event is negotiated at several
+func matchCodec(codec webrtc.RTPCodecCapability, negotiated []webrtc.RTPCodecParameters) (webrtc.RTPCodecParameters, bool) {
+	for _, c := range negotiated {
+		if strings.EqualFold(c.MimeType, codec.MimeType) && c.ClockRate == codec.ClockRate && c.SDPFmtpLine == codec.SDPFmtpLine {
+			return c, true
+		}
+	}
+
+	for _, c := range negotiated {
+		if strings.EqualFold(c.MimeType, codec.MimeType) && c.ClockRate == codec.ClockRate {
+			return c, true
+		}
+	}
+
+	return webrtc.RTPCodecParameters{}, false
+}
+
+type queuedPacket struct {
+	header  rtp.Header
+	payload []byte
+}
+
+// writeRTP queues a packet for the subscriber. It never blocks: when the
+// subscriber can't keep up the packet is dropped and counted
+func (d *downTrack) writeRTP(header rtp.Header, payload []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	payloadType, ok := d.payloadTypes[webrtc.PayloadType(header.PayloadType)]
+	if !ok || d.queue == nil {
+		return
+	}
+
+	if !d.translator.translate(&header) {
+		return
+	}
+
+	// The extensions still point into the read buffer and interceptors may rewrite them
+	header = header.Clone()
+	header.SSRC = uint32(d.ssrc)
+	header.PayloadType = uint8(payloadType)
+
+	select {
+	case d.queue <- queuedPacket{header: header, payload: payload}:
+	default:
+		d.dropped.Add(1)
+		packetsDroppedTotal.Add(1)
+	}
+}
+
+// writeLoop sends the queued packets until the queue is closed on Unbind
+func (d *downTrack) writeLoop(queue chan queuedPacket, writeStream webrtc.TrackLocalWriter) {
+	for p := range queue {
+		n, _ := writeStream.WriteRTP(&p.header, p.payload)
+		d.bytesOut.Add(uint64(n))
+	}
+}
+
+// pause stops forwarding to the subscriber until resume is called
+func (d *downTrack) pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.translator.pause()
+}
+
+func (d *downTrack) resume() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.translator.resume()
+}
+
+// rebase continues the stream from a new source, which started when
+// the previous one stopped at since
+func (d *downTrack) rebase(since time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.translator.rebase(since)
+}
+
+// rtpTranslator rewrites sequence numbers and timestamps so a subscriber
+// sees a continuous stream even when forwarding was paused in between
+type rtpTranslator struct {
+	clockRate uint32
+
+	paused   bool
+	resync   bool
+	pausedAt time.Time
+
+	started   bool
+	seqOffset uint16
+	tsOffset  uint32
+	lastSeq   uint16
+	lastTS    uint32
+}
+
+func (r *rtpTranslator) pause() {
+	if r.paused {
+		return
+	}
+
+	r.paused = true
+	r.pausedAt = time.Now()
+}
+
+func (r *rtpTranslator) resume() {
+	if !r.paused {
+		return
+	}
+
+	r.paused = false
+	r.resync = true
+}
+
+func (r *rtpTranslator) rebase(since time.Time) {
+	if !r.started {
+		// Nothing was sent yet, the new source sets the offsets
+		r.seqOffset, r.tsOffset = 0, 0
+		return
+	}
+
+	r.resync = true
+	if !r.paused {
+		r.pausedAt = since
+	}
+}
+
+// translate rewrites the header in place and reports whether the packet must be sent
+func (r *rtpTranslator) translate(h *rtp.Header) bool {
+	if r.paused {
+		return false
+	}
+
+	if r.resync {
+		r.resync = false
+
+		if r.started {
+			// Continue right after the last packet sent, advancing the
+			// timestamp by the time spent paused
+			gap := uint32(time.Since(r.pausedAt).Seconds() * float64(r.clockRate))
+			if gap == 0 {
+				gap = 1
+			}
+
+			r.seqOffset = h.SequenceNumber - (r.lastSeq + 1)
+			r.tsOffset = h.Timestamp - (r.lastTS + gap)
+		}
+	}
+
+	h.SequenceNumber -= r.seqOffset
+	h.Timestamp -= r.tsOffset
+
+	// Late packets mustn't move the resume point backwards
+	if !r.started || int16(h.SequenceNumber-r.lastSeq) > 0 {
+		r.lastSeq = h.SequenceNumber
+		r.lastTS = h.Timestamp
+	}
+	r.started = true
+
+	return true
+}
@@ -0,0 +1,314 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// translatePacket translates a packet with the given sequence number and timestamp
+func translatePacket(t *testing.T, r *rtpTranslator, seq uint16, ts uint32) (rtp.Header, bool) {
+	t.Helper()
+
+	h := rtp.Header{SequenceNumber: seq, Timestamp: ts}
+	ok := r.translate(&h)
+	return h, ok
+}
+
+func TestRTPTranslatorPassesThrough(t *testing.T) {
+	r := &rtpTranslator{clockRate: 90000}
+
+	for i := uint16(0); i < 3; i++ {
+		h, ok := translatePacket(t, r, 100+i, 1000+uint32(i)*3000)
+		if !ok || h.SequenceNumber != 100+i || h.Timestamp != 1000+uint32(i)*3000 {
+			t.Fatalf("packet %d: got %d/%d sent=%v, want it unchanged", i, h.SequenceNumber, h.Timestamp, ok)
+		}
+	}
+}
+
+func TestRTPTranslatorPauseResume(t *testing.T) {
+	r := &rtpTranslator{clockRate: 90000}
+	translatePacket(t, r, 100, 1000)
+	translatePacket(t, r, 101, 4000)
+
+	r.pause()
+	if _, ok := translatePacket(t, r, 102, 7000); ok {
+		t.Fatal("a packet was sent while paused")
+	}
+
+	// A second spent paused shows as a second of timestamps
+	r.pausedAt = time.Now().Add(-time.Second)
+	r.resume()
+
+	h, ok := translatePacket(t, r, 5000, 900000)
+	if !ok {
+		t.Fatal("the first packet after resume was dropped")
+	}
+	if h.SequenceNumber != 102 {
+		t.Fatalf("got sequence number %d, want 102", h.SequenceNumber)
+	}
+	if gap := h.Timestamp - 4000; gap < 90000 || gap > 90000+9000 {
+		t.Fatalf("got a timestamp gap of %d, want about 90000", gap)
+	}
+
+	next, _ := translatePacket(t, r, 5001, 903000)
+	if next.SequenceNumber != 103 || next.Timestamp != h.Timestamp+3000 {
+		t.Fatalf("got %d/%d, want 103/%d", next.SequenceNumber, next.Timestamp, h.Timestamp+3000)
+	}
+}
+
+func TestRTPTranslatorWrapsAround(t *testing.T) {
+	r := &rtpTranslator{clockRate: 90000}
+	translatePacket(t, r, 65535, 1000)
+
+	r.pause()
+	r.resume()
+
+	h, _ := translatePacket(t, r, 10, 2000)
+	if h.SequenceNumber != 0 {
+		t.Fatalf("got sequence number %d, want 0 after 65535", h.SequenceNumber)
+	}
+}
+
+func TestRTPTranslatorLatePacket(t *testing.T) {
+	r := &rtpTranslator{clockRate: 90000}
+	translatePacket(t, r, 100, 1000)
+	translatePacket(t, r, 102, 7000)
+
+	// 101 arrives late and is forwarded, but the stream resumes after 102
+	if h, ok := translatePacket(t, r, 101, 4000); !ok || h.SequenceNumber != 101 {
+		t.Fatalf("got %d sent=%v, want the late packet forwarded unchanged", h.SequenceNumber, ok)
+	}
+
+	r.pause()
+	r.resume()
+
+	if h, _ := translatePacket(t, r, 300, 50000); h.SequenceNumber != 103 {
+		t.Fatalf("got sequence number %d, want 103", h.SequenceNumber)
+	}
+}
+
+func TestRTPTranslatorRebase(t *testing.T) {
+	t.Run("before the first packet", func(t *testing.T) {
+		r := &rtpTranslator{clockRate: 90000}
+		r.rebase(time.Now())
+
+		if h, _ := translatePacket(t, r, 7000, 123456); h.SequenceNumber != 7000 || h.Timestamp != 123456 {
+			t.Fatalf("got %d/%d, want the new source unchanged", h.SequenceNumber, h.Timestamp)
+		}
+	})
+
+	t.Run("after packets were sent", func(t *testing.T) {
+		r := &rtpTranslator{clockRate: 90000}
+		translatePacket(t, r, 100, 1000)
+
+		// The new publisher starts from numbers of its own
+		r.rebase(time.Now())
+		h, ok := translatePacket(t, r, 40000, 777777)
+		if !ok || h.SequenceNumber != 101 {
+			t.Fatalf("got sequence number %d sent=%v, want 101", h.SequenceNumber, ok)
+		}
+		if h.Timestamp <= 1000 {
+			t.Fatalf("got timestamp %d, want it after 1000", h.Timestamp)
+		}
+	})
+}
+
+func TestForwardFEC(t *testing.T) {
+	red := webrtc.RTPCodecCapability{MimeType: "video/red", ClockRate: 90000}
+	ulpfec := webrtc.RTPCodecCapability{MimeType: "video/ulpfec", ClockRate: 90000}
+	h264 := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}
+
+	track := newTestTrack("alice", "camera", testVP8)
+	track.codecs[97] = red
+	track.codecs[98] = ulpfec
+	track.codecs[99] = h264
+
+	// The subscriber numbers the payloads its own way and has no H264
+	queue := bindTestSubscriberWith(t, track, "bob", []webrtc.RTPCodecParameters{
+		{RTPCodecCapability: testVP8, PayloadType: 100},
+		{RTPCodecCapability: red, PayloadType: 101},
+		{RTPCodecCapability: ulpfec, PayloadType: 102},
+	})
+
+	for i, payloadType := range []uint8{96, 97, 98, 99} {
+		track.writeRTP(&rtp.Packet{Header: rtp.Header{PayloadType: payloadType, SequenceNumber: uint16(i)}, Payload: []byte{byte(i)}})
+	}
+
+	var got []uint8
+	for len(queue) > 0 {
+		p := <-queue
+		got = append(got, p.header.PayloadType)
+	}
+	if want := []uint8{100, 101, 102}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got payload types %v, want %v", got, want)
+	}
+}
+
+func TestForwardDTMF(t *testing.T) {
+	dtmf48k := webrtc.RTPCodecCapability{MimeType: mimeTypeTelephoneEvent, ClockRate: 48000, SDPFmtpLine: "0-15"}
+	dtmf8k := webrtc.RTPCodecCapability{MimeType: mimeTypeTelephoneEvent, ClockRate: 8000, SDPFmtpLine: "0-15"}
+
+	tests := []struct {
+		name       string
+		negotiated []webrtc.RTPCodecParameters
+		want       []uint8
+	}{
+		{
+			name: "both clock rates",
+			negotiated: []webrtc.RTPCodecParameters{
+				{RTPCodecCapability: testOpus, PayloadType: 109},
+				{RTPCodecCapability: dtmf8k, PayloadType: 100},
+				{RTPCodecCapability: dtmf48k, PayloadType: 101},
+			},
+			want: []uint8{109, 101, 100},
+		},
+		{
+			name: "8 kHz events only",
+			negotiated: []webrtc.RTPCodecParameters{
+				{RTPCodecCapability: testOpus, PayloadType: 109},
+				{RTPCodecCapability: dtmf8k, PayloadType: 100},
+			},
+			want: []uint8{109, 100},
+		},
+		{
+			name:       "no events",
+			negotiated: []webrtc.RTPCodecParameters{{RTPCodecCapability: testOpus, PayloadType: 109}},
+			want:       []uint8{109},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track := newTestTrack("alice", "mic", testOpus)
+			track.codecs[110] = dtmf48k
+			track.codecs[126] = dtmf8k
+
+			queue := bindTestSubscriberWith(t, track, "bob", tt.negotiated)
+			for i, payloadType := range []uint8{96, 110, 126} {
+				track.writeRTP(&rtp.Packet{Header: rtp.Header{PayloadType: payloadType, SequenceNumber: uint16(i)}, Payload: []byte{byte(i)}})
+			}
+
+			var got []uint8
+			for len(queue) > 0 {
+				p := <-queue
+				got = append(got, p.header.PayloadType)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got payload types %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// stalledStream is the write stream of a subscriber that stopped reading,
+// every write blocks until release is closed
+type stalledStream struct {
+	release chan struct{}
+}
+
+func (s stalledStream) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	<-s.release
+	return header.MarshalSize() + len(payload), nil
+}
+
+func (s stalledStream) Write(b []byte) (int, error) {
+	<-s.release
+	return len(b), nil
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	track := newTestTrack("alice", "camera", testVP8)
+
+	stalled := stalledStream{release: make(chan struct{})}
+	t.Cleanup(func() { close(stalled.release) })
+	slow := &peerConnectionState{id: "slow"}
+	bindTestStream(t, track, slow, stalled)
+
+	fast := &peerConnectionState{id: "fast"}
+	bindTestStream(t, track, fast, countingStream{})
+
+	// Each burst fits the queue of the fast subscriber, which is drained in
+	// between, the slow one stops taking any after the first
+	const bursts = 4
+	seq := uint16(0)
+	for burst := 1; burst <= bursts; burst++ {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < downTrackQueueSize; i++ {
+				seq++
+				track.writeRTP(&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: seq}, Payload: make([]byte, 100)})
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("burst %d: the publisher is blocked by the slow subscriber", burst)
+		}
+
+		want := uint64(burst * downTrackQueueSize * 112)
+		deadline := time.Now().Add(5 * time.Second)
+		for fast.bytesOut.Load() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("burst %d: the fast subscriber got %d bytes, want %d", burst, fast.bytesOut.Load(), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if dropped := fast.packetsDropped.Load(); dropped != 0 {
+		t.Fatalf("the fast subscriber dropped %d packets", dropped)
+	}
+
+	// The slow subscriber holds a queue full, and likely the packet it is stuck writing
+	if dropped, most := slow.packetsDropped.Load(), uint64((bursts-1)*downTrackQueueSize); dropped != most && dropped != most-1 {
+		t.Fatalf("the slow subscriber dropped %d packets, want %d or %d", dropped, most-1, most)
+	}
+}
+
+func TestSameTrackIDFromTwoPublishers(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both publishers name their camera the same way
+	tracks := map[string]*localTrack{}
+	for _, peerID := range []string{"alice", "bob"} {
+		track, err := addTrack(newTestTrack(peerID, "camera", testVP8), roomUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracks[peerID] = track
+	}
+
+	listLock.RLock()
+	published := len(trackLocals[roomUUID])
+	listLock.RUnlock()
+	if published != 2 {
+		t.Fatalf("got %d tracks in the room, want 2", published)
+	}
+
+	// Each publisher's packets reach carol on that publisher's track only
+	for peerID, track := range tracks {
+		queue := bindTestSubscriber(t, track, "carol")
+		track.writeRTP(&rtp.Packet{Header: rtp.Header{PayloadType: 96}, Payload: []byte(peerID)})
+
+		if len(queue) != 1 {
+			t.Fatalf("got %d packets from %s, want 1", len(queue), peerID)
+		}
+		if p := <-queue; string(p.payload) != peerID {
+			t.Fatalf("got %q on %s's track, want %q", p.payload, peerID, peerID)
+		}
+	}
+
+	// Publishing the same ID twice is still refused
+	if _, err := addTrack(newTestTrack("alice", "camera", testVP8), roomUUID); !errors.Is(err, ErrDuplicateTrack) {
+		t.Fatalf("got %v publishing alice's camera again, want %v", err, ErrDuplicateTrack)
+	}
+}
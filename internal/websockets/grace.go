@@ -0,0 +1,113 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/webrtc/v3"
+	"strings"
+	"time"
+)
+
+// publisherGrace is how long the tracks of a publisher that went away are
+// kept, 0 removes them right away
+var publisherGrace time.Duration
+
+func init() {
+	publisherGrace = config.Duration("PUBLISHER_GRACE_PERIOD", 0)
+}
+
+// orphanTrack keeps the track of a gone publisher for publisherGrace.
+// Subscribers keep their senders and show the last frame, a publisher
+// reconnecting with the same track takes it over. listLock must be held
+func orphanTrack(t *localTrack, roomUUID string) {
+	var timer *time.Timer
+	timer = time.AfterFunc(publisherGrace, func() {
+		listLock.Lock()
+
+		// Taken over in the meantime
+		if trackLocals[roomUUID][t.ID()] != t || t.orphaned != timer {
+			listLock.Unlock()
+			return
+		}
+
+		t.orphaned = nil
+		dropTrack(t, roomUUID)
+
+		listLock.Unlock()
+		requestSignal(roomUUID)
+	})
+
+	t.orphaned, t.orphanedAt = timer, time.Now()
+}
+
+// findOrphan returns the orphaned track the publisher had published as
+// remoteID, nil if there is none. listLock must be held
+func findOrphan(roomUUID, remoteID string, publisher *peerConnectionState) *localTrack {
+	for _, t := range trackLocals[roomUUID] {
+		if t.orphaned != nil && t.remoteID == remoteID && t.ownedBy(publisher) {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// ownedBy reports whether the peer is the publisher the track came from: the
+// same authenticated subject, or the holder of its reconnect token. Track IDs
+// are chosen by clients, so they prove nothing. listLock must be held
+func (t *localTrack) ownedBy(p *peerConnectionState) bool {
+	if p == nil {
+		return false
+	}
+
+	return t.ownerSubject != "" && t.ownerSubject == p.identity.Subject ||
+		t.reconnectToken != "" && t.reconnectToken == p.resumeToken
+}
+
+// adoptTrack makes the reconnected publisher of track the source of the
+// orphaned one, subscribers continue the stream where it stopped. listLock must be held
+func adoptTrack(orphan, track *localTrack, roomUUID string) bool {
+	if orphan.orphaned == nil || !sameCodecs(orphan, track) {
+		return false
+	}
+
+	orphan.orphaned.Stop()
+	orphan.orphaned = nil
+	orphan.ownerSubject, orphan.reconnectToken = track.ownerSubject, track.reconnectToken
+
+	orphan.mu.Lock()
+	orphan.peerID = track.peerID
+	orphan.publisher = track.publisher
+	orphan.ssrc = track.ssrc
+	for _, d := range orphan.subscribers {
+		d.rebase(orphan.orphanedAt)
+	}
+	orphan.mu.Unlock()
+
+	// A hold was meant for the previous peer
+	if p := findPeer(roomUUID, orphan.peerID); p != nil && p.paused {
+		orphan.hold()
+	} else if orphan.isHeld() {
+		r := conferences[roomUUID]
+		orphan.release(!r.config.ActiveSpeakerOnly || orphan.Kind() != webrtc.RTPCodecTypeVideo || r.speaker.active() == orphan.peerID)
+	}
+
+	return true
+}
+
+// sameCodecs reports whether two tracks are sent with the same payload
+// types, only then a downTrack can switch from one to the other
+func sameCodecs(a, b *localTrack) bool {
+	if a.payloadType != b.payloadType || len(a.codecs) != len(b.codecs) {
+		return false
+	}
+
+	for payloadType, codec := range a.codecs {
+		other, ok := b.codecs[payloadType]
+		if !ok || !strings.EqualFold(codec.MimeType, other.MimeType) || codec.ClockRate != other.ClockRate ||
+			codec.Channels != other.Channels || codec.SDPFmtpLine != other.SDPFmtpLine {
+			return false
+		}
+	}
+
+	return true
+}
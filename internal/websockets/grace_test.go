@@ -0,0 +1,79 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/auth"
+	"testing"
+	"time"
+)
+
+func TestFindOrphanNeedsThePublisher(t *testing.T) {
+	const roomUUID = "grace-room"
+
+	timer := time.AfterFunc(time.Hour, func() {})
+	defer timer.Stop()
+
+	tracks := map[string]*localTrack{
+		"alice": {remoteID: "camera", orphaned: timer, ownerSubject: "alice", reconnectToken: "alice-token"},
+		"anon":  {remoteID: "mic", orphaned: timer, reconnectToken: "anon-token"},
+	}
+
+	listLock.Lock()
+	trackLocals[roomUUID] = tracks
+	listLock.Unlock()
+	t.Cleanup(func() {
+		listLock.Lock()
+		delete(trackLocals, roomUUID)
+		listLock.Unlock()
+	})
+
+	tests := []struct {
+		name      string
+		remoteID  string
+		publisher *peerConnectionState
+		want      *localTrack
+	}{
+		{
+			name:      "same subject",
+			remoteID:  "camera",
+			publisher: &peerConnectionState{identity: auth.Identity{Subject: "alice"}},
+			want:      tracks["alice"],
+		},
+		{
+			name:      "reconnect token",
+			remoteID:  "mic",
+			publisher: &peerConnectionState{resumeToken: "anon-token"},
+			want:      tracks["anon"],
+		},
+		{
+			name:      "another subject reusing the track ID",
+			remoteID:  "camera",
+			publisher: &peerConnectionState{identity: auth.Identity{Subject: "mallory"}},
+		},
+		{
+			name:      "another peer's reconnect token",
+			remoteID:  "camera",
+			publisher: &peerConnectionState{resumeToken: "anon-token"},
+		},
+		{
+			name:      "anonymous peer without a token",
+			remoteID:  "mic",
+			publisher: &peerConnectionState{},
+		},
+		{
+			name:     "no publisher",
+			remoteID: "mic",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listLock.Lock()
+			got := findOrphan(roomUUID, tt.remoteID, tt.publisher)
+			listLock.Unlock()
+
+			if got != tt.want {
+				t.Fatalf("got %p, want %p", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package websockets
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// RaisedHand is a peer waiting for the floor, the room keeps them in the
+// order they raised their hand
+type RaisedHand struct {
+	PeerID   string    `json:"peerId"`
+	Name     string    `json:"name"`
+	RaisedAt time.Time `json:"raisedAt"`
+}
+
+// handIndex returns the position of the peer in the queue, -1 if its hand
+// is down. listLock must be held
+func (r *room) handIndex(peerID string) int {
+	for i, hand := range r.hands {
+		if hand.PeerID == peerID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// setHandRaised raises or lowers the hand of the peer and tells the room
+// when the queue changed
+func setHandRaised(roomUUID string, peer *peerConnectionState, raised bool) {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return
+	}
+
+	i := r.handIndex(peer.id)
+	switch {
+	case raised && i < 0:
+		r.hands = append(r.hands, RaisedHand{PeerID: peer.id, Name: peer.name, RaisedAt: time.Now()})
+	case !raised && i >= 0:
+		r.hands = append(r.hands[:i], r.hands[i+1:]...)
+	default:
+		return
+	}
+
+	broadcastHands(roomUUID, r)
+}
+
+// lowerHandOnLeave drops the hand of a peer that left. listLock must be held
+func lowerHandOnLeave(roomUUID, peerID string) {
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return
+	}
+
+	if i := r.handIndex(peerID); i >= 0 {
+		r.hands = append(r.hands[:i], r.hands[i+1:]...)
+		broadcastHands(roomUUID, r)
+	}
+}
+
+// broadcastHands sends the queue to the room. listLock must be held
+func broadcastHands(roomUUID string, r *room) {
+	data, err := json.Marshal(r.handQueue())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	broadcast(roomUUID, websocketMessage{Event: "hands", Data: string(data)}, nil)
+}
+
+// handQueue copies the queue, oldest hand first. listLock must be held
+func (r *room) handQueue() []RaisedHand {
+	return append([]RaisedHand{}, r.hands...)
+}
+
+// ListHands returns the raised hands of a room, oldest first
+func ListHands(roomUUID string) ([]RaisedHand, error) {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	return r.handQueue(), nil
+}
+
+// ClearHands lowers every hand of the room. Only the host may do it
+func ClearHands(roomUUID, hostKey string) error {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !r.isHost(hostKey) {
+		return ErrNotHost
+	}
+
+	if len(r.hands) == 0 {
+		return nil
+	}
+
+	r.hands = nil
+	broadcastHands(roomUUID, r)
+
+	return nil
+}
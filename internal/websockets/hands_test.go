@@ -0,0 +1,128 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRaisedHands(t *testing.T) {
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conns := map[string]*fakeConn{}
+	for _, peerID := range []string{"alice", "bob", "carol"} {
+		conns[peerID] = addFakePeer(t, roomUUID, peerID)
+	}
+
+	send := func(peerID, event string) {
+		t.Helper()
+
+		listLock.RLock()
+		peer := findPeer(roomUUID, peerID)
+		listLock.RUnlock()
+		if !handleMessage(conns[peerID], peer, roomUUID, &websocketMessage{Event: event}) {
+			t.Fatalf("%s from %s closed the connection", event, peerID)
+		}
+	}
+
+	// queue returns the peers of the last hands event carol got
+	queue := func() []string {
+		t.Helper()
+
+		message, ok := conns["carol"].message("hands")
+		if !ok {
+			t.Fatal("carol got no hands event")
+		}
+		hands := []RaisedHand{}
+		if err := json.Unmarshal([]byte(message.Data), &hands); err != nil {
+			t.Fatal(err)
+		}
+		peerIDs := []string{}
+		for _, hand := range hands {
+			peerIDs = append(peerIDs, hand.PeerID)
+		}
+		return peerIDs
+	}
+
+	checkQueue := func(want ...string) {
+		t.Helper()
+
+		if want == nil {
+			want = []string{}
+		}
+		if got := queue(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("broadcast queue %v, want %v", got, want)
+		}
+		hands, err := ListHands(roomUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hands) != len(want) {
+			t.Fatalf("ListHands got %d hands, want %d", len(hands), len(want))
+		}
+		for i, hand := range hands {
+			if hand.PeerID != want[i] {
+				t.Fatalf("ListHands got %s at %d, want %s", hand.PeerID, i, want[i])
+			}
+		}
+	}
+
+	send("bob", "raise_hand")
+	send("alice", "raise_hand")
+	checkQueue("bob", "alice")
+
+	// Raising twice keeps the place in the queue and broadcasts nothing
+	sent := len(conns["carol"].events())
+	send("bob", "raise_hand")
+	if got := len(conns["carol"].events()); got != sent {
+		t.Fatalf("raising again sent %d events", got-sent)
+	}
+
+	send("bob", "lower_hand")
+	checkQueue("alice")
+
+	send("bob", "raise_hand")
+	checkQueue("alice", "bob")
+
+	if err := ClearHands(roomUUID, "not the host"); !errors.Is(err, ErrNotHost) {
+		t.Fatalf("got %v clearing without the host key, want %v", err, ErrNotHost)
+	}
+	if err := ClearHands(roomUUID, hostKey); err != nil {
+		t.Fatal(err)
+	}
+	checkQueue()
+
+	if _, err := ListHands("missing-room"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("got %v for a missing room, want %v", err, ErrRoomNotFound)
+	}
+}
+
+func TestLowerHandOnLeave(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addFakePeer(t, roomUUID, "alice")
+	bob := addFakePeer(t, roomUUID, "bob")
+
+	listLock.RLock()
+	alice := findPeer(roomUUID, "alice")
+	listLock.RUnlock()
+	setHandRaised(roomUUID, alice, true)
+
+	listLock.Lock()
+	lowerHandOnLeave(roomUUID, "alice")
+	listLock.Unlock()
+
+	message, ok := bob.message("hands")
+	if !ok || message.Data != "[]" {
+		t.Fatalf("bob got %+v, want an empty queue", message)
+	}
+	if hands, _ := ListHands(roomUUID); len(hands) != 0 {
+		t.Fatalf("got %v after alice left, want no hands", hands)
+	}
+}
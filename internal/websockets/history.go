@@ -0,0 +1,123 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"sync"
+	"time"
+)
+
+// statsHistorySize bounds the samples kept per room, an hour at the default interval
+const statsHistorySize = 360
+
+func init() {
+	if interval := config.Duration("STATS_SAMPLE_INTERVAL", 10*time.Second); interval > 0 {
+		go sampleRoomStatsEvery(interval)
+	}
+}
+
+// StatsSample is the state of a room at one point of its history. Bitrates
+// are averaged over the interval before the sample
+type StatsSample struct {
+	Time         time.Time `json:"time"`
+	Participants int       `json:"participants"`
+	Tracks       int       `json:"tracks"`
+	BitrateIn    uint64    `json:"bitrateIn"`
+	BitrateOut   uint64    `json:"bitrateOut"`
+}
+
+// statsHistory is a fixed size ring buffer of room samples
+type statsHistory struct {
+	sync.Mutex
+	samples []StatsSample
+	next    int
+	full    bool
+
+	// Counters of every peer at the previous sample, to turn them into bitrates
+	last     time.Time
+	lastPeer map[string]peerBytes
+}
+
+type peerBytes struct {
+	in, out uint64
+}
+
+func newStatsHistory() *statsHistory {
+	return &statsHistory{
+		samples:  make([]StatsSample, statsHistorySize),
+		lastPeer: make(map[string]peerBytes),
+	}
+}
+
+func (h *statsHistory) append(sample StatsSample) {
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list returns the stored samples, oldest first
+func (h *statsHistory) list() []StatsSample {
+	h.Lock()
+	defer h.Unlock()
+
+	if !h.full {
+		return append([]StatsSample{}, h.samples[:h.next]...)
+	}
+
+	return append(append([]StatsSample{}, h.samples[h.next:]...), h.samples[:h.next]...)
+}
+
+// sample records the current state of the room. listLock must be held
+func (h *statsHistory) sample(roomUUID string, now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+
+	sample := StatsSample{
+		Time:         now,
+		Participants: len(peerConnections[roomUUID]),
+		Tracks:       len(trackLocals[roomUUID]),
+	}
+
+	// Peers that left since the previous sample take their last bytes with them
+	var in, out uint64
+	current := make(map[string]peerBytes, len(peerConnections[roomUUID]))
+	for _, p := range peerConnections[roomUUID] {
+		bytes := peerBytes{in: p.bytesIn.Load(), out: p.bytesOut.Load()}
+		previous := h.lastPeer[p.id]
+		in += bytes.in - previous.in
+		out += bytes.out - previous.out
+		current[p.id] = bytes
+	}
+
+	if elapsed := now.Sub(h.last).Seconds(); !h.last.IsZero() && elapsed > 0 {
+		sample.BitrateIn = uint64(float64(in*8) / elapsed)
+		sample.BitrateOut = uint64(float64(out*8) / elapsed)
+	}
+
+	h.last, h.lastPeer = now, current
+	h.append(sample)
+}
+
+func sampleRoomStatsEvery(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		listLock.RLock()
+		for roomUUID, r := range conferences {
+			r.history.sample(roomUUID, now)
+		}
+		listLock.RUnlock()
+	}
+}
+
+// GetRoomStatsHistory returns the samples taken of a room, oldest first
+func GetRoomStatsHistory(roomUUID string) ([]StatsSample, error) {
+	listLock.RLock()
+	r, ok := conferences[roomUUID]
+	listLock.RUnlock()
+
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	return r.history.list(), nil
+}
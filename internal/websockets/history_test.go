@@ -0,0 +1,72 @@
+package websockets
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatsHistory(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addFakePeer(t, roomUUID, "alice")
+	addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+
+	listLock.RLock()
+	alice := findPeer(roomUUID, "alice")
+	listLock.RUnlock()
+
+	// A history of its own, the background sampler writes to the room's
+	history := newStatsHistory()
+	start := time.Now()
+	sample := func(at time.Duration) {
+		listLock.RLock()
+		history.sample(roomUUID, start.Add(at))
+		listLock.RUnlock()
+	}
+
+	sample(0)
+	alice.bytesIn.Add(1000)
+	alice.bytesOut.Add(2000)
+	sample(time.Second)
+	sample(2 * time.Second)
+
+	want := []StatsSample{
+		{Time: start, Participants: 1, Tracks: 1},
+		{Time: start.Add(time.Second), Participants: 1, Tracks: 1, BitrateIn: 8000, BitrateOut: 16000},
+		{Time: start.Add(2 * time.Second), Participants: 1, Tracks: 1},
+	}
+	got := history.list()
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !got[i].Time.Equal(want[i].Time) || got[i].Participants != want[i].Participants || got[i].Tracks != want[i].Tracks ||
+			got[i].BitrateIn != want[i].BitrateIn || got[i].BitrateOut != want[i].BitrateOut {
+			t.Errorf("sample %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Past its size the oldest samples make room for the new ones
+	for i := 3; i < statsHistorySize+5; i++ {
+		sample(time.Duration(i) * time.Second)
+	}
+	got = history.list()
+	if len(got) != statsHistorySize {
+		t.Fatalf("got %d samples, want %d", len(got), statsHistorySize)
+	}
+	if first := start.Add(5 * time.Second); !got[0].Time.Equal(first) {
+		t.Fatalf("got oldest sample at %s, want %s", got[0].Time, first)
+	}
+	for i := 1; i < len(got); i++ {
+		if !got[i].Time.After(got[i-1].Time) {
+			t.Fatalf("sample %d at %s is not after %s", i, got[i].Time, got[i-1].Time)
+		}
+	}
+
+	if _, err := GetRoomStatsHistory("no-such-room"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("unknown room: got %v, want %v", err, ErrRoomNotFound)
+	}
+}
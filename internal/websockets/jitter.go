@@ -0,0 +1,146 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/rtp"
+	"time"
+)
+
+const (
+	// jitterTick is how often held packets are checked when nothing new arrives
+	jitterTick = 5 * time.Millisecond
+
+	// maxJitterPackets bounds a buffer whatever the delay, a full buffer gives up on its gaps
+	maxJitterPackets = 512
+)
+
+// jitterBufferDelay is how long a packet may wait for the ones before it, 0 forwards packets as they come
+var jitterBufferDelay time.Duration
+
+func init() {
+	jitterBufferDelay = time.Duration(config.Int("JITTER_BUFFER_MS", 0)) * time.Millisecond
+}
+
+// jitterBuffer forwards the packets of a track in sequence order, holding
+// those after a gap up to delay for the missing ones to arrive
+type jitterBuffer struct {
+	delay   time.Duration
+	forward func(*rtp.Packet)
+	in      chan *rtp.Packet
+
+	// Owned by the run goroutine
+	packets map[uint16]bufferedPacket
+	next    uint16
+	started bool
+}
+
+type bufferedPacket struct {
+	packet  *rtp.Packet
+	arrived time.Time
+}
+
+func newJitterBuffer(delay time.Duration, forward func(*rtp.Packet)) *jitterBuffer {
+	j := &jitterBuffer{
+		delay:   delay,
+		forward: forward,
+		in:      make(chan *rtp.Packet, 64),
+		packets: make(map[uint16]bufferedPacket),
+	}
+	go j.run()
+
+	return j
+}
+
+// push hands a packet over to the buffer, which keeps it
+func (j *jitterBuffer) push(p *rtp.Packet) {
+	j.in <- p
+}
+
+// close stops the buffer, packets still held are dropped
+func (j *jitterBuffer) close() {
+	close(j.in)
+}
+
+func (j *jitterBuffer) run() {
+	ticker := time.NewTicker(jitterTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case p, ok := <-j.in:
+			if !ok {
+				return
+			}
+			j.insert(p, time.Now())
+			j.flush(time.Now())
+		case now := <-ticker.C:
+			j.flush(now)
+		}
+	}
+}
+
+func (j *jitterBuffer) insert(p *rtp.Packet, now time.Time) {
+	if !j.started {
+		j.started = true
+		j.next = p.SequenceNumber
+	}
+
+	if behind := int16(p.SequenceNumber - j.next); behind < 0 {
+		// Too late, the packets after it are already forwarded
+		if behind >= -maxJitterPackets {
+			return
+		}
+
+		// Too far back to be late, the sequence jumped after a long gap or a
+		// publisher restart. What is held goes out first, then the buffer
+		// starts over from this packet
+		j.forwardHeld()
+		j.next = p.SequenceNumber
+	}
+
+	j.packets[p.SequenceNumber] = bufferedPacket{packet: p, arrived: now}
+}
+
+// forwardHeld forwards every held packet in sequence order, whatever the gaps
+func (j *jitterBuffer) forwardHeld() {
+	for len(j.packets) > 0 {
+		first, _ := j.first()
+		j.forward(j.packets[first].packet)
+		delete(j.packets, first)
+	}
+}
+
+// flush forwards every packet that is next in sequence. A gap is skipped
+// once the packet right after it waited for delay
+func (j *jitterBuffer) flush(now time.Time) {
+	for len(j.packets) > 0 {
+		if b, ok := j.packets[j.next]; ok {
+			delete(j.packets, j.next)
+			j.forward(b.packet)
+			j.next++
+			continue
+		}
+
+		first, waited := j.first()
+		if now.Sub(waited) < j.delay && len(j.packets) < maxJitterPackets {
+			return
+		}
+
+		j.next = first
+	}
+}
+
+// first returns the lowest held sequence number and when that packet arrived
+func (j *jitterBuffer) first() (uint16, time.Time) {
+	var first uint16
+	var arrived time.Time
+
+	found := false
+	for seq, b := range j.packets {
+		if !found || int16(seq-first) < 0 {
+			first, arrived, found = seq, b.arrived, true
+		}
+	}
+
+	return first, arrived
+}
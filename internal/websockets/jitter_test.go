@@ -0,0 +1,115 @@
+package websockets
+
+import (
+	"github.com/pion/rtp"
+	"testing"
+	"time"
+)
+
+const testJitterDelay = 50 * time.Millisecond
+
+// jitterStep inserts the packets of seqs at the time after the start given
+// by at, then flushes
+type jitterStep struct {
+	at   time.Duration
+	seqs []uint16
+}
+
+func TestJitterBuffer(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []jitterStep
+		want  []uint16
+	}{
+		{
+			name:  "in order",
+			steps: []jitterStep{{seqs: []uint16{1, 2, 3}}},
+			want:  []uint16{1, 2, 3},
+		},
+		{
+			name:  "out of order within the delay",
+			steps: []jitterStep{{seqs: []uint16{1, 3, 2, 5, 4}}},
+			want:  []uint16{1, 2, 3, 4, 5},
+		},
+		{
+			name:  "gap waits for the delay",
+			steps: []jitterStep{{seqs: []uint16{1, 3, 4}}, {at: testJitterDelay / 2}},
+			want:  []uint16{1},
+		},
+		{
+			name:  "gap skipped after the delay",
+			steps: []jitterStep{{seqs: []uint16{1, 3, 4}}, {at: testJitterDelay}},
+			want:  []uint16{1, 3, 4},
+		},
+		{
+			name:  "late packet dropped",
+			steps: []jitterStep{{seqs: []uint16{1, 3}}, {at: testJitterDelay}, {at: testJitterDelay, seqs: []uint16{2, 4}}},
+			want:  []uint16{1, 3, 4},
+		},
+		{
+			name:  "wraps around",
+			steps: []jitterStep{{seqs: []uint16{65534, 0, 65535, 1}}},
+			want:  []uint16{65534, 65535, 0, 1},
+		},
+		{
+			name:  "long gap resynchronizes",
+			steps: []jitterStep{{seqs: []uint16{10, 11}}, {at: time.Second, seqs: []uint16{40010, 40012, 40011}}},
+			want:  []uint16{10, 11, 40010, 40011, 40012},
+		},
+		{
+			name:  "long gap forwards what is held first",
+			steps: []jitterStep{{seqs: []uint16{10, 12}}, {at: testJitterDelay / 2, seqs: []uint16{40010}}},
+			want:  []uint16{10, 12, 40010},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []uint16
+			j := &jitterBuffer{
+				delay:   testJitterDelay,
+				forward: func(p *rtp.Packet) { got = append(got, p.SequenceNumber) },
+				packets: make(map[uint16]bufferedPacket),
+			}
+
+			start := time.Now()
+			for _, step := range tt.steps {
+				now := start.Add(step.at)
+				for _, seq := range step.seqs {
+					j.insert(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}}, now)
+				}
+				j.flush(now)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestJitterBufferForwardsInOrder(t *testing.T) {
+	forwarded := make(chan uint16, 8)
+	j := newJitterBuffer(testJitterDelay, func(p *rtp.Packet) { forwarded <- p.SequenceNumber })
+	defer j.close()
+
+	for _, seq := range []uint16{100, 102, 101, 104, 103} {
+		j.push(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}})
+	}
+
+	for want := uint16(100); want <= 104; want++ {
+		select {
+		case got := <-forwarded:
+			if got != want {
+				t.Fatalf("got %d, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("packet %d not forwarded", want)
+		}
+	}
+}
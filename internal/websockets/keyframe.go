@@ -0,0 +1,69 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"log"
+	"strings"
+	"time"
+)
+
+// Keyframe modes
+const (
+	// keyFrameOnDemand requests keyframes only when the room is renegotiated, e.g. on join
+	keyFrameOnDemand = "on-demand"
+	// keyFramePeriodic requests keyframes on a timer only
+	keyFramePeriodic = "periodic"
+	keyFrameBoth     = "both"
+)
+
+var keyFrameMode string
+
+func init() {
+	keyFrameMode = strings.ToLower(config.String("KEYFRAME_MODE", keyFrameBoth))
+	interval := config.Duration("KEYFRAME_INTERVAL", 3*time.Second)
+
+	switch keyFrameMode {
+	case keyFrameOnDemand, keyFramePeriodic, keyFrameBoth:
+	default:
+		log.Fatalf("KEYFRAME_MODE must be on-demand, periodic or both, got %q", keyFrameMode)
+	}
+
+	if keyFrameOnTimer(keyFrameMode) {
+		if interval == 0 {
+			log.Fatal("KEYFRAME_INTERVAL must be positive for periodic keyframes")
+		}
+		go dispatchKeyFramesEvery(interval)
+	}
+}
+
+// keyFrameOnSignal reports whether in mode renegotiations are followed by a keyframe request
+func keyFrameOnSignal(mode string) bool {
+	return mode != keyFramePeriodic
+}
+
+// keyFrameOnTimer reports whether in mode keyframes are requested every KEYFRAME_INTERVAL
+func keyFrameOnTimer(mode string) bool {
+	return mode != keyFrameOnDemand
+}
+
+func dispatchKeyFramesEvery(interval time.Duration) {
+	for range time.NewTicker(interval).C {
+		dispatchKeyFrames(dispatchKeyFrame)
+	}
+}
+
+// dispatchKeyFrames calls dispatch for every room with peers
+func dispatchKeyFrames(dispatch func(roomUUID string)) {
+	listLock.RLock()
+	rooms := make([]string, 0, len(peerConnections))
+	for roomUUID, peers := range peerConnections {
+		if len(peers) > 0 {
+			rooms = append(rooms, roomUUID)
+		}
+	}
+	listLock.RUnlock()
+
+	for _, roomUUID := range rooms {
+		dispatch(roomUUID)
+	}
+}
@@ -0,0 +1,112 @@
+package websockets
+
+import (
+	"github.com/pion/rtp"
+	"testing"
+)
+
+func TestKeyFrameModes(t *testing.T) {
+	tests := []struct {
+		mode         string
+		wantOnSignal bool
+		wantOnTimer  bool
+	}{
+		{mode: keyFrameOnDemand, wantOnSignal: true},
+		{mode: keyFramePeriodic, wantOnTimer: true},
+		{mode: keyFrameBoth, wantOnSignal: true, wantOnTimer: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			if got := keyFrameOnSignal(tt.mode); got != tt.wantOnSignal {
+				t.Errorf("on signal: got %v, want %v", got, tt.wantOnSignal)
+			}
+			if got := keyFrameOnTimer(tt.mode); got != tt.wantOnTimer {
+				t.Errorf("on timer: got %v, want %v", got, tt.wantOnTimer)
+			}
+		})
+	}
+}
+
+func TestDispatchKeyFramesSkipsEmptyRooms(t *testing.T) {
+	occupied, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := newTestPeer(t)
+	peer.websocket = &fakeConn{}
+	listLock.Lock()
+	peerConnections[occupied] = append(peerConnections[occupied], peer)
+	listLock.Unlock()
+
+	dispatched := make(map[string]int)
+	dispatchKeyFrames(func(roomUUID string) { dispatched[roomUUID]++ })
+
+	if dispatched[occupied] != 1 || dispatched[empty] != 0 {
+		t.Fatalf("got %d requests in the room with a peer and %d in the empty one, want 1 and 0", dispatched[occupied], dispatched[empty])
+	}
+}
+
+func TestRefreshWritesPLI(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, camera, sender := connectTestPublisher(t)
+	ssrc := sender.GetParameters().Encodings[0].SSRC
+
+	publisher := &peerConnectionState{id: "alice", peerConnection: server, websocket: &fakeConn{}}
+	listLock.Lock()
+	peerConnections[roomUUID] = append(peerConnections[roomUUID], publisher)
+	listLock.Unlock()
+
+	track := addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+	track.mu.Lock()
+	track.publisher, track.ssrc = server, ssrc
+	track.mu.Unlock()
+
+	// The server knows the incoming track once media arrived
+	if err := camera.WriteRTP(&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 1}, Payload: []byte{0}}); err != nil {
+		t.Fatal(err)
+	}
+
+	subscriber := newTestPeer(t)
+	for _, tt := range []struct {
+		name string
+		data string
+	}{
+		{name: "one track", data: `"` + track.ID() + `"`},
+		{name: "every track", data: ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{}
+			if !handleMessage(conn, subscriber, roomUUID, &websocketMessage{Event: "refresh", Data: tt.data}) {
+				t.Fatal("the refresh closed the connection")
+			}
+
+			got, ok := nextPLI(t, sender)
+			if !ok || got != uint32(ssrc) {
+				t.Fatalf("got a PLI for %d (%v), want one for %d", got, ok, ssrc)
+			}
+			if events := conn.events(); len(events) != 0 {
+				t.Fatalf("got %v, want no reply", events)
+			}
+		})
+	}
+
+	t.Run("unknown track", func(t *testing.T) {
+		conn := &fakeConn{}
+		if !handleMessage(conn, subscriber, roomUUID, &websocketMessage{Event: "refresh", Data: `"bob-camera"`}) {
+			t.Fatal("the refresh closed the connection")
+		}
+		if events := conn.events(); len(events) != 1 || events[0] != "error" {
+			t.Fatalf("got %v, want an error", events)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package websockets
+
+import (
+	"fmt"
+	"github.com/b4o4/conference-backend/internal/config"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSampledMessages bounds the messages remembered between two cleanups
+const maxSampledMessages = 1024
+
+var sampler *logSampler
+
+func init() {
+	sampler = newLogSampler(config.Duration("LOG_SAMPLE_INTERVAL", time.Second))
+}
+
+// logSampler logs identical messages at most once per interval and counts
+// the ones it swallowed, so connection churn doesn't flood the log
+type logSampler struct {
+	mu       sync.Mutex
+	interval time.Duration
+	messages map[string]*sampledMessage
+}
+
+type sampledMessage struct {
+	logged     time.Time
+	suppressed int
+}
+
+func newLogSampler(interval time.Duration) *logSampler {
+	return &logSampler{interval: interval, messages: make(map[string]*sampledMessage)}
+}
+
+// logSampled is log.Println for hot error paths
+func logSampled(v ...interface{}) {
+	if message, suppressed, ok := sampler.sample(strings.TrimSuffix(fmt.Sprintln(v...), "\n"), time.Now()); ok {
+		if suppressed > 0 {
+			log.Printf("%s (%d similar suppressed)", message, suppressed)
+		} else {
+			log.Print(message)
+		}
+	}
+}
+
+// sample reports whether message must be logged now and how many identical
+// ones were dropped since it last was
+func (s *logSampler) sample(message string, now time.Time) (string, int, bool) {
+	if s.interval == 0 {
+		return message, 0, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.messages[message]
+	if ok && now.Sub(m.logged) < s.interval {
+		m.suppressed++
+		return message, 0, false
+	}
+
+	if !ok {
+		if len(s.messages) >= maxSampledMessages {
+			s.prune(now)
+		}
+		if len(s.messages) >= maxSampledMessages {
+			return message, 0, true
+		}
+		m = &sampledMessage{}
+		s.messages[message] = m
+	}
+
+	suppressed := m.suppressed
+	m.logged, m.suppressed = now, 0
+
+	return message, suppressed, true
+}
+
+// prune forgets the messages whose interval is over. Their suppressed
+// counts are lost, which only happens under a flood of distinct messages
+func (s *logSampler) prune(now time.Time) {
+	for message, m := range s.messages {
+		if now.Sub(m.logged) >= s.interval {
+			delete(s.messages, message)
+		}
+	}
+}
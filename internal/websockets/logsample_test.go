@@ -0,0 +1,71 @@
+package websockets
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLogSamplerSamplesRepeatedMessages(t *testing.T) {
+	s := newLogSampler(time.Second)
+	start := time.Now()
+
+	type step struct {
+		message        string
+		after          time.Duration
+		wantLogged     bool
+		wantSuppressed int
+	}
+	steps := []step{
+		{message: "write: broken pipe", wantLogged: true},
+		{message: "write: broken pipe", after: 100 * time.Millisecond},
+		{message: "write: broken pipe", after: 200 * time.Millisecond},
+		{message: "write: broken pipe", after: 300 * time.Millisecond},
+		{message: "read: connection reset", after: 300 * time.Millisecond, wantLogged: true},
+		{message: "write: broken pipe", after: time.Second, wantLogged: true, wantSuppressed: 3},
+		{message: "write: broken pipe", after: 1500 * time.Millisecond},
+		{message: "read: connection reset", after: 1500 * time.Millisecond, wantLogged: true},
+	}
+
+	for i, st := range steps {
+		_, suppressed, logged := s.sample(st.message, start.Add(st.after))
+		if logged != st.wantLogged || suppressed != st.wantSuppressed {
+			t.Fatalf("step %d %q: got logged=%v suppressed=%d, want logged=%v suppressed=%d",
+				i, st.message, logged, suppressed, st.wantLogged, st.wantSuppressed)
+		}
+	}
+}
+
+func TestLogSamplerDisabled(t *testing.T) {
+	s := newLogSampler(0)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if _, _, logged := s.sample("write: broken pipe", now); !logged {
+			t.Fatalf("message %d was suppressed with sampling off", i)
+		}
+	}
+}
+
+func TestLogSamplerFull(t *testing.T) {
+	s := newLogSampler(time.Second)
+	now := time.Now()
+
+	for i := 0; i < maxSampledMessages; i++ {
+		s.sample(fmt.Sprintf("peer %d: write failed", i), now)
+	}
+
+	// Nothing can be forgotten yet, so new messages are logged rather than tracked
+	for i := 0; i < 2; i++ {
+		if _, _, logged := s.sample("one more", now); !logged {
+			t.Fatalf("message %d past the limit was suppressed", i)
+		}
+	}
+
+	// Once the interval is over the old ones make room again
+	later := now.Add(time.Second)
+	s.sample("one more", later)
+	if _, _, logged := s.sample("one more", later); logged {
+		t.Fatal("a repeated message was logged after the cleanup")
+	}
+}
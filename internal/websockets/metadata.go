@@ -0,0 +1,57 @@
+package websockets
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+)
+
+// maxMetadataSize bounds the metadata a peer may attach to itself, in bytes
+const maxMetadataSize = 1024
+
+// peerMetadataChanged tells the room a peer replaced its metadata
+type peerMetadataChanged struct {
+	PeerID   string          `json:"peerId"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// parseMetadata checks that raw is a JSON object small enough to be relayed,
+// it is returned compacted
+func parseMetadata(raw []byte) (json.RawMessage, bool) {
+	if len(raw) > maxMetadataSize {
+		return nil, false
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil || object == nil {
+		return nil, false
+	}
+
+	compacted := &bytes.Buffer{}
+	if err := json.Compact(compacted, raw); err != nil {
+		return nil, false
+	}
+
+	return compacted.Bytes(), true
+}
+
+// setMetadata replaces the metadata of the peer and tells the room
+func setMetadata(conn signalConn, roomUUID string, peer *peerConnectionState, message *websocketMessage) {
+	metadata, ok := parseMetadata([]byte(message.Data))
+	if !ok {
+		sendError(conn, "metadata must be a JSON object of up to 1024 bytes")
+		return
+	}
+
+	data, err := json.Marshal(peerMetadataChanged{PeerID: peer.id, Metadata: metadata})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	peer.metadata = metadata
+	broadcast(roomUUID, websocketMessage{Event: "peer_metadata_changed", Data: string(data)}, nil)
+}
@@ -0,0 +1,49 @@
+package websockets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{name: "object", raw: `{"role": "speaker", "seat": 3}`, want: `{"role":"speaker","seat":3}`, wantOK: true},
+		{name: "empty object", raw: `{}`, want: `{}`, wantOK: true},
+		{name: "nested", raw: "{\n  \"a\": {\"b\": [1, 2]}\n}", want: `{"a":{"b":[1,2]}}`, wantOK: true},
+		{name: "array", raw: `[1, 2]`},
+		{name: "string", raw: `"speaker"`},
+		{name: "null", raw: `null`},
+		{name: "empty", raw: ``},
+		{name: "malformed", raw: `{"role":`},
+		{name: "trailing data", raw: `{} {}`},
+		{name: "too large", raw: `{"pad":"` + strings.Repeat("x", maxMetadataSize) + `"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseMetadata([]byte(tt.raw))
+			if ok != tt.wantOK || string(got) != tt.want {
+				t.Fatalf("parseMetadata(%q) = %s, %v, want %s, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSetMetadataRejects(t *testing.T) {
+	conn := &fakeConn{}
+	peer := &peerConnectionState{id: "peer", metadata: []byte(`{"kept":true}`)}
+
+	setMetadata(conn, "", peer, &websocketMessage{Event: "set_metadata", Data: `[1]`})
+
+	if events := conn.events(); len(events) != 1 || events[0] != "error" {
+		t.Fatalf("got events %v, want [error]", events)
+	}
+	if string(peer.metadata) != `{"kept":true}` {
+		t.Fatalf("got metadata %s, want the previous one kept", peer.metadata)
+	}
+}
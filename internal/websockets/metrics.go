@@ -0,0 +1,93 @@
+package websockets
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// publishedCodecs counts the tracks published per codec since startup
+var publishedCodecs = &codecCounter{counts: make(map[string]uint64)}
+
+type codecCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (c *codecCounter) inc(mimeType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts[strings.ToLower(mimeType)]++
+}
+
+func (c *codecCounter) snapshot() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make(map[string]uint64, len(c.counts))
+	for codec, n := range c.counts {
+		counts[codec] = n
+	}
+
+	return counts
+}
+
+// peerDrops is the drop counter of a connected subscriber
+type peerDrops struct {
+	room, peer string
+	dropped    uint64
+}
+
+// droppedPerPeer returns the drop counters of the connected peers, sorted so
+// the output is stable
+func droppedPerPeer() []peerDrops {
+	listLock.RLock()
+	drops := []peerDrops{}
+	for roomUUID, peers := range peerConnections {
+		for _, p := range peers {
+			drops = append(drops, peerDrops{room: roomUUID, peer: p.id, dropped: p.packetsDropped.Load()})
+		}
+	}
+	listLock.RUnlock()
+
+	sort.Slice(drops, func(i, j int) bool {
+		if drops[i].room != drops[j].room {
+			return drops[i].room < drops[j].room
+		}
+		return drops[i].peer < drops[j].peer
+	})
+
+	return drops
+}
+
+// WriteMetrics writes the server metrics in the Prometheus text format
+func WriteMetrics(w io.Writer) error {
+	stats := GetServerStats()
+	counts := publishedCodecs.snapshot()
+
+	codecs := make([]string, 0, len(counts))
+	for codec := range counts {
+		codecs = append(codecs, codec)
+	}
+	sort.Strings(codecs)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP conference_rooms Rooms currently open.\n# TYPE conference_rooms gauge\nconference_rooms %d\n", stats.Rooms)
+	fmt.Fprintf(&b, "# HELP conference_peers Peers currently connected.\n# TYPE conference_peers gauge\nconference_peers %d\n", stats.Peers)
+	fmt.Fprintf(&b, "# HELP conference_tracks Tracks currently published.\n# TYPE conference_tracks gauge\nconference_tracks %d\n", stats.Tracks)
+	fmt.Fprintf(&b, "# HELP conference_tracks_published_total Tracks published since startup by codec.\n# TYPE conference_tracks_published_total counter\n")
+	for _, codec := range codecs {
+		fmt.Fprintf(&b, "conference_tracks_published_total{codec=%q} %d\n", codec, counts[codec])
+	}
+	fmt.Fprintf(&b, "# HELP conference_packets_dropped_total Packets dropped since startup because a subscriber's send queue was full.\n# TYPE conference_packets_dropped_total counter\nconference_packets_dropped_total %d\n", stats.PacketsDropped)
+	fmt.Fprintf(&b, "# HELP conference_peer_packets_dropped_total Packets dropped for a connected subscriber whose send queue was full.\n# TYPE conference_peer_packets_dropped_total counter\n")
+	for _, d := range droppedPerPeer() {
+		fmt.Fprintf(&b, "conference_peer_packets_dropped_total{room=%q,peer=%q} %d\n", d.room, d.peer, d.dropped)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,103 @@
+package websockets
+
+import (
+	"fmt"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetric returns the value of the metric line starting with series, 0 if missing
+func scrapeMetric(t *testing.T, series string) uint64 {
+	t.Helper()
+
+	var b strings.Builder
+	if err := WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(b.String(), "\n") {
+		value, found := strings.CutPrefix(line, series+" ")
+		if !found {
+			continue
+		}
+
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		return n
+	}
+	return 0
+}
+
+func TestTracksPublishedPerCodec(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series := func(mimeType string) string {
+		return fmt.Sprintf("conference_tracks_published_total{codec=%q}", strings.ToLower(mimeType))
+	}
+	vp8, opus := scrapeMetric(t, series(testVP8.MimeType)), scrapeMetric(t, series(testOpus.MimeType))
+
+	for _, track := range []*localTrack{
+		newTestTrack("alice", "camera", testVP8),
+		newTestTrack("alice", "mic", testOpus),
+		newTestTrack("bob", "camera", testVP8),
+	} {
+		if _, err := addTrack(track, roomUUID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := scrapeMetric(t, series(testVP8.MimeType)) - vp8; got != 2 {
+		t.Errorf("got %d more VP8 tracks, want 2", got)
+	}
+	if got := scrapeMetric(t, series(testOpus.MimeType)) - opus; got != 1 {
+		t.Errorf("got %d more Opus tracks, want 1", got)
+	}
+}
+
+func TestPacketsDropped(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addFakePeer(t, roomUUID, "bob")
+
+	listLock.RLock()
+	bob := findPeer(roomUUID, "bob")
+	listLock.RUnlock()
+
+	peerSeries := fmt.Sprintf("conference_peer_packets_dropped_total{room=%q,peer=%q}", roomUUID, "bob")
+	total, stats := scrapeMetric(t, "conference_packets_dropped_total"), GetServerStats().PacketsDropped
+
+	// bob's queue holds a single packet and nothing drains it
+	track := newTestTrack("alice", "camera", testVP8)
+	d, err := track.subscribe(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	d.queue = make(chan queuedPacket, 1)
+	d.payloadTypes = map[webrtc.PayloadType]webrtc.PayloadType{96: 96}
+	d.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		track.writeRTP(&rtp.Packet{Header: rtp.Header{PayloadType: 96, SequenceNumber: uint16(i)}})
+	}
+
+	if got := scrapeMetric(t, peerSeries); got != 2 {
+		t.Errorf("got %d packets dropped for bob, want 2", got)
+	}
+	if got := scrapeMetric(t, "conference_packets_dropped_total") - total; got != 2 {
+		t.Errorf("got %d more packets dropped in total, want 2", got)
+	}
+	if got := GetServerStats().PacketsDropped - stats; got != 2 {
+		t.Errorf("got %d more packets dropped in the server stats, want 2", got)
+	}
+}
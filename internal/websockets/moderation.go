@@ -0,0 +1,129 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+	"log"
+)
+
+// SetPublisherPaused stops or restarts forwarding the media of a peer without
+// disconnecting it. Only the host may do it
+func SetPublisherPaused(roomUUID, hostKey, peerID string, paused bool) error {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !r.isHost(hostKey) {
+		return ErrNotHost
+	}
+
+	p := findPeer(roomUUID, peerID)
+	if p == nil {
+		return ErrPeerNotFound
+	}
+
+	if p.paused == paused {
+		return nil
+	}
+	p.paused = paused
+
+	for _, t := range trackLocals[roomUUID] {
+		if t.peerID != peerID {
+			continue
+		}
+
+		if paused {
+			t.hold()
+			continue
+		}
+
+		// In active speaker rooms only the speaker's video comes back
+		forward := !r.config.ActiveSpeakerOnly || t.Kind() != webrtc.RTPCodecTypeVideo || r.speaker.active() == peerID
+		t.release(forward)
+		if forward && t.Kind() == webrtc.RTPCodecTypeVideo {
+			t.keyFrame()
+		}
+	}
+
+	event, typ := "participant_resumed", AuditResume
+	if paused {
+		event, typ = "participant_paused", AuditPause
+	}
+	r.events.append(typ, peerID)
+
+	data, err := json.Marshal(peerID)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	broadcast(roomUUID, websocketMessage{Event: event, Data: string(data)}, nil)
+
+	return nil
+}
+
+// TransferHost hands the host role to another peer of the room. The host key
+// is replaced and sent to the new host only, so the previous one loses its rights
+func TransferHost(roomUUID, hostKey, peerID string) error {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !r.isHost(hostKey) {
+		return ErrNotHost
+	}
+
+	target := findPeer(roomUUID, peerID)
+	if target == nil {
+		return ErrPeerNotFound
+	}
+
+	hostKey = uuid.NewString()
+	keyData, err := json.Marshal(hostKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(peerID)
+	if err != nil {
+		return err
+	}
+
+	r.hostKey = hostKey
+	for _, p := range peerConnections[roomUUID] {
+		p.isHost = p == target
+	}
+	r.events.append(AuditHostChange, peerID)
+	applyConsent(roomUUID)
+
+	if err := target.websocket.WriteJSON(&websocketMessage{
+		Event: "host_key",
+		Data:  string(keyData),
+	}); err != nil {
+		logSampled(err)
+	}
+
+	broadcast(roomUUID, websocketMessage{Event: "host_changed", Data: string(data)}, nil)
+
+	return nil
+}
+
+// findPeer returns the state of a peer of the room. listLock must be held
+func findPeer(roomUUID, peerID string) *peerConnectionState {
+	for _, p := range peerConnections[roomUUID] {
+		if p.id == peerID {
+			return p
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/pion/rtp"
+	"testing"
+)
+
+func TestSetPublisherPaused(t *testing.T) {
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addFakePeer(t, roomUUID, "alice")
+	bob := addFakePeer(t, roomUUID, "bob")
+	camera := addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+	queue := bindTestSubscriber(t, camera, "bob")
+
+	seq := uint16(100)
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			seq++
+			camera.writeRTP(&rtp.Packet{Header: rtp.Header{PayloadType: 96, SequenceNumber: seq, Timestamp: uint32(seq) * 3000}, Payload: []byte{1}})
+		}
+	}
+
+	send(3)
+	if got := forwarded(queue); got != 3 {
+		t.Fatalf("before the pause: got %d packets, want 3", got)
+	}
+
+	if err := SetPublisherPaused(roomUUID, hostKey, "alice", true); err != nil {
+		t.Fatal(err)
+	}
+	send(5)
+	if got := forwarded(queue); got != 0 {
+		t.Fatalf("while paused: got %d packets, want 0", got)
+	}
+
+	if err := SetPublisherPaused(roomUUID, hostKey, "alice", false); err != nil {
+		t.Fatal(err)
+	}
+	send(2)
+
+	// The subscriber sees no gap in the sequence numbers
+	want := uint16(104)
+	for i := 0; i < 2; i++ {
+		select {
+		case p := <-queue:
+			if p.header.SequenceNumber != want {
+				t.Fatalf("after the resume: got sequence number %d, want %d", p.header.SequenceNumber, want)
+			}
+			want++
+		default:
+			t.Fatalf("after the resume: got %d packets, want 2", i)
+		}
+	}
+
+	events := bob.events()
+	if len(events) != 2 || events[0] != "participant_paused" || events[1] != "participant_resumed" {
+		t.Fatalf("got events %v, want [participant_paused participant_resumed]", events)
+	}
+}
+
+func TestSetPublisherPausedErrors(t *testing.T) {
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addFakePeer(t, roomUUID, "alice")
+
+	tests := []struct {
+		name     string
+		roomUUID string
+		hostKey  string
+		peerID   string
+		want     error
+	}{
+		{name: "unknown room", roomUUID: "no-such-room", hostKey: hostKey, peerID: "alice", want: ErrRoomNotFound},
+		{name: "not the host", roomUUID: roomUUID, hostKey: "guess", peerID: "alice", want: ErrNotHost},
+		{name: "unknown peer", roomUUID: roomUUID, hostKey: hostKey, peerID: "mallory", want: ErrPeerNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetPublisherPaused(tt.roomUUID, tt.hostKey, tt.peerID, true); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransferHost(t *testing.T) {
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := addFakePeer(t, roomUUID, "alice")
+	bob := addFakePeer(t, roomUUID, "bob")
+	listLock.Lock()
+	findPeer(roomUUID, "alice").isHost = true
+	listLock.Unlock()
+
+	if err := TransferHost(roomUUID, hostKey, "mallory"); !errors.Is(err, ErrPeerNotFound) {
+		t.Fatalf("transfer to a non-member: got %v, want %v", err, ErrPeerNotFound)
+	}
+	if _, ok := bob.message("host_changed"); ok {
+		t.Fatal("a refused transfer was broadcast")
+	}
+
+	if err := TransferHost(roomUUID, hostKey, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, conn := range map[string]*fakeConn{"alice": alice, "bob": bob} {
+		message, ok := conn.message("host_changed")
+		if !ok || message.Data != `"bob"` {
+			t.Fatalf("%s got host_changed %q, want \"bob\"", name, message.Data)
+		}
+	}
+	if _, ok := alice.message("host_key"); ok {
+		t.Fatal("the previous host got the new host key")
+	}
+
+	listLock.RLock()
+	aliceIsHost, bobIsHost := findPeer(roomUUID, "alice").isHost, findPeer(roomUUID, "bob").isHost
+	listLock.RUnlock()
+	if aliceIsHost || !bobIsHost {
+		t.Fatalf("got alice host %v and bob host %v, want bob only", aliceIsHost, bobIsHost)
+	}
+
+	// Only the key sent to the new host keeps the rights
+	if err := TransferHost(roomUUID, hostKey, "alice"); !errors.Is(err, ErrNotHost) {
+		t.Fatalf("previous host key: got %v, want %v", err, ErrNotHost)
+	}
+
+	message, ok := bob.message("host_key")
+	if !ok {
+		t.Fatal("the new host got no host key")
+	}
+	newKey := ""
+	if err := json.Unmarshal([]byte(message.Data), &newKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := TransferHost(roomUUID, newKey, "alice"); err != nil {
+		t.Fatalf("new host key: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/b4o4/conference-backend/internal/auth"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"time"
+)
+
+// observer is a multiplexed connection watching several rooms without taking
+// part in them, e.g. a monitoring dashboard
+type observer struct {
+	conn *threadSafeWriter
+
+	// tenant is the one of the upgrade request, only its rooms can be joined.
+	// A token bound to a room limits the connection to that room too
+	tenant string
+	room   string
+
+	// spend consumes the single use token of the connection, see auth.Spend
+	spend func() error
+
+	// rooms is only touched by the connection's read loop
+	rooms map[string]bool
+}
+
+// MultiplexHandler serves a websocket observing any number of rooms. Every
+// message carries the room it is about in "room": the client sends join and
+// leave, the server the events of the joined rooms. It carries no media, a
+// client taking part in a room joins it on Handler
+func MultiplexHandler(w http.ResponseWriter, r *http.Request) {
+	if IsDraining() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	connectionID := uuid.NewString()
+
+	unsafeConn, err := upgrader.Upgrade(w, r, http.Header{
+		"X-Server-Version": {Version},
+		"X-Server-Time":    {time.Now().UTC().Format(time.RFC3339Nano)},
+		"X-Connection-Id":  {connectionID},
+	})
+	if err != nil {
+		log.Print("upgrade:", err)
+		return
+	}
+	c := &threadSafeWriter{Conn: unsafeConn}
+	defer c.Close() //nolint
+
+	if err := sendWelcome(c, connectionID, "", iceServers); err != nil {
+		log.Println(err)
+	}
+
+	identity, _ := auth.FromContext(r.Context())
+	o := &observer{
+		conn:   c,
+		tenant: Tenant(r),
+		room:   identity.Room,
+		spend:  func() error { return auth.Spend(r.Context()) },
+		rooms:  make(map[string]bool),
+	}
+	defer o.leaveAll()
+
+	var limiter *tokenBucket
+	if maxMessagesPerSec > 0 {
+		limiter = newTokenBucket(maxMessagesPerSec)
+	}
+
+	message := &websocketMessage{}
+	for {
+		_, raw, err := c.ReadMessage()
+		if err != nil {
+			logDisconnect(err)
+			return
+		}
+
+		if !limiter.allow() {
+			continue
+		}
+
+		if err := json.Unmarshal(raw, &message); err != nil {
+			log.Println(err)
+			return
+		}
+
+		switch message.Event {
+		case "join":
+			if err := o.join(message.Room); err != nil {
+				o.sendError(message.Room, err)
+			}
+		case "leave":
+			o.leave(message.Room)
+		default:
+			o.sendError(message.Room, errUnknownEvent)
+		}
+	}
+}
+
+// join starts forwarding the events of the room, beginning with its participants
+func (o *observer) join(roomUUID string) error {
+	if o.room != "" && o.room != roomUUID {
+		return ErrTokenForOtherRoom
+	}
+
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok || r.tenant != o.tenant {
+		return ErrRoomNotFound
+	}
+
+	// The first room joined spends a single use token
+	if err := o.spend(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(participants(roomUUID))
+	if err != nil {
+		return err
+	}
+
+	r.observers[o] = true
+	o.rooms[roomUUID] = true
+
+	return o.conn.WriteJSON(&websocketMessage{
+		Event: "joined",
+		Data:  string(data),
+		Room:  roomUUID,
+	})
+}
+
+func (o *observer) leave(roomUUID string) {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	if r, ok := conferences[roomUUID]; ok {
+		delete(r.observers, o)
+	}
+	delete(o.rooms, roomUUID)
+}
+
+func (o *observer) leaveAll() {
+	for roomUUID := range o.rooms {
+		o.leave(roomUUID)
+	}
+}
+
+func (o *observer) sendError(roomUUID string, reason error) {
+	data, err := json.Marshal(reason.Error())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := o.conn.WriteJSON(&websocketMessage{
+		Event: "error",
+		Data:  string(data),
+		Room:  roomUUID,
+	}); err != nil {
+		logSampled(err)
+	}
+}
+
+// notifyObservers hands a room event to the connections observing the room. listLock must be held
+func notifyObservers(roomUUID string, message websocketMessage) {
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return
+	}
+
+	message.Room = roomUUID
+	for o := range r.observers {
+		if err := o.conn.WriteJSON(&message); err != nil {
+			logSampled(err)
+		}
+	}
+}
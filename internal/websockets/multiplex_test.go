@@ -0,0 +1,93 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/auth"
+	"github.com/gorilla/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiplexTokenOfOneRoom(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServerAs(t, auth.Identity{Room: roomUUID})
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/websocket/multiplex", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// The reply to each join, after the welcome
+	reply := func(room string) websocketMessage {
+		t.Helper()
+
+		if err := ws.WriteJSON(websocketMessage{Event: "join", Room: room}); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			message := websocketMessage{}
+			if err := ws.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			}
+			if message.Room == room {
+				return message
+			}
+		}
+	}
+
+	if message := reply(otherUUID); message.Event != "error" || !strings.Contains(message.Data, ErrTokenForOtherRoom.Error()) {
+		t.Fatalf("joining another room: got %+v, want an error", message)
+	}
+	if message := reply(roomUUID); message.Event != "joined" {
+		t.Fatalf("joining the token's room: got %+v, want joined", message)
+	}
+}
+
+func TestMultiplexObservesTwoRooms(t *testing.T) {
+	srv := newTestServer(t)
+
+	rooms := make([]string, 2)
+	for i := range rooms {
+		roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		rooms[i] = roomUUID
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/websocket/multiplex", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	for _, roomUUID := range rooms {
+		if err := ws.WriteJSON(websocketMessage{Event: "join", Room: roomUUID}); err != nil {
+			t.Fatal(err)
+		}
+		if message, ok := nextEvent(t, ws, "joined", 5*time.Second); !ok || message.Room != roomUUID {
+			t.Fatalf("got %+v, want joined of %s", message, roomUUID)
+		}
+	}
+
+	// A participant joining each room is reported with the room it joined
+	for _, roomUUID := range rooms {
+		_, welcome := joinRoom(t, srv, roomUUID)
+
+		message, ok := nextEvent(t, ws, "participant_joined", 5*time.Second)
+		if !ok {
+			t.Fatalf("no participant_joined from %s", roomUUID)
+		}
+		if message.Room != roomUUID || !strings.Contains(message.Data, welcome.ConnectionID) {
+			t.Fatalf("got %+v, want %s joining %s", message, welcome.ConnectionID, roomUUID)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"log"
+	"time"
+)
+
+// maxNegotiationFailures is how many offers in a row may fail to be created
+// for a peer before it is dropped, 0 retries forever
+var maxNegotiationFailures int
+
+func init() {
+	maxNegotiationFailures = config.Int("MAX_NEGOTIATION_FAILURES", 5)
+}
+
+// negotiationFailed counts a failed offer of the peer. One that keeps failing
+// is likely broken for good, it is disconnected rather than retried on every
+// sync of the room. listLock must be held
+func (p *peerConnectionState) negotiationFailed() {
+	// A pending offer, ours or the client's, fails new offers until it is
+	// answered. That is glare or a slow client, OFFER_TIMEOUT deals with those
+	if p.peerConnection.SignalingState() != webrtc.SignalingStateStable {
+		return
+	}
+
+	p.negotiationFailures++
+	if maxNegotiationFailures == 0 || p.negotiationFailures < maxNegotiationFailures {
+		return
+	}
+
+	log.Printf("peer %s failed %d negotiations in a row, dropping", p.id, p.negotiationFailures)
+	_ = p.websocket.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "negotiation failed"), time.Now().Add(time.Second))
+
+	// The next pass of the sync removes peers whose websocket failed
+	p.websocket.markFailed()
+}
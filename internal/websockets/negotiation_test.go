@@ -0,0 +1,121 @@
+package websockets
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"testing"
+)
+
+func TestFailingNegotiationsDropThePeer(t *testing.T) {
+	previous := maxNegotiationFailures
+	maxNegotiationFailures = 3
+	t.Cleanup(func() { maxNegotiationFailures = previous })
+
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := newTestPeer(t)
+	server, client := websocketPair(t)
+	peer.id, peer.websocket = "alice", server
+
+	listLock.Lock()
+	peerConnections[roomUUID] = append(peerConnections[roomUUID], peer)
+	for i := 0; i < maxNegotiationFailures-1; i++ {
+		peer.negotiationFailed()
+	}
+	listLock.Unlock()
+	if server.broken() {
+		t.Fatalf("dropped after %d failures, want %d", maxNegotiationFailures-1, maxNegotiationFailures)
+	}
+
+	listLock.Lock()
+	peer.negotiationFailed()
+	listLock.Unlock()
+	if !server.broken() {
+		t.Fatalf("still connected after %d failures", maxNegotiationFailures)
+	}
+
+	_, closeErr := readUntilClose(t, client)
+	if closeErr.Code != websocket.CloseInternalServerErr || closeErr.Text != "negotiation failed" {
+		t.Fatalf("got close %d %q, want %d %q", closeErr.Code, closeErr.Text, websocket.CloseInternalServerErr, "negotiation failed")
+	}
+
+	// The next sync of the room removes the peer instead of retrying it
+	signalPeerConnections(roomUUID)
+	listLock.RLock()
+	gone := findPeer(roomUUID, "alice") == nil
+	listLock.RUnlock()
+	if !gone {
+		t.Fatal("the peer is still in the room after a sync")
+	}
+}
+
+func TestNegotiationFailuresCount(t *testing.T) {
+	previous := maxNegotiationFailures
+	maxNegotiationFailures = 3
+	t.Cleanup(func() { maxNegotiationFailures = previous })
+
+	t.Run("not while an offer is pending", func(t *testing.T) {
+		peer := newTestPeer(t)
+		conn := &fakeConn{}
+		peer.websocket = conn
+
+		if _, err := peer.peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+			t.Fatal(err)
+		}
+		offer, err := peer.peerConnection.CreateOffer(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := peer.peerConnection.SetLocalDescription(offer); err != nil {
+			t.Fatal(err)
+		}
+
+		listLock.Lock()
+		for i := 0; i < maxNegotiationFailures*2; i++ {
+			peer.negotiationFailed()
+		}
+		listLock.Unlock()
+		if peer.negotiationFailures != 0 || conn.broken() {
+			t.Fatalf("got %d failures counted with an offer pending, want none", peer.negotiationFailures)
+		}
+	})
+
+	t.Run("reset by an offer that goes through", func(t *testing.T) {
+		roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		peer := newTestPeer(t)
+		peer.id, peer.websocket = "alice", &fakeConn{}
+		peer.negotiationFailures = maxNegotiationFailures - 1
+
+		listLock.Lock()
+		syncPeer(roomUUID, peer)
+		peer.answered()
+		listLock.Unlock()
+		if peer.negotiationFailures != 0 {
+			t.Fatalf("got %d failures after a good offer, want 0", peer.negotiationFailures)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		maxNegotiationFailures = 0
+
+		peer := newTestPeer(t)
+		conn := &fakeConn{}
+		peer.websocket = conn
+
+		listLock.Lock()
+		for i := 0; i < 100; i++ {
+			peer.negotiationFailed()
+		}
+		listLock.Unlock()
+		if conn.broken() {
+			t.Fatal("dropped with MAX_NEGOTIATION_FAILURES=0")
+		}
+	})
+}
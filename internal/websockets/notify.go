@@ -0,0 +1,76 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNotificationLength bounds the message of a notification, in runes
+const maxNotificationLength = 500
+
+var ErrInvalidNotification = errors.New("notification needs a level of info or warn and a message of up to 500 characters")
+
+// Notification is a banner operators push to the participants of rooms
+type Notification struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+func (n Notification) Validate() error {
+	if n.Level != "info" && n.Level != "warn" {
+		return ErrInvalidNotification
+	}
+
+	if strings.TrimSpace(n.Message) == "" || utf8.RuneCountInString(n.Message) > maxNotificationLength {
+		return ErrInvalidNotification
+	}
+
+	return nil
+}
+
+// NotifyRoom sends the notification to every peer of the room
+func NotifyRoom(roomUUID string, n Notification) error {
+	if err := n.Validate(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	if _, ok := conferences[roomUUID]; !ok {
+		return ErrRoomNotFound
+	}
+
+	broadcast(roomUUID, websocketMessage{Event: "notification", Data: string(data)}, nil)
+
+	return nil
+}
+
+// NotifyAll sends the notification to every peer of every room and returns
+// the number of rooms reached
+func NotifyAll(n Notification) (int, error) {
+	if err := n.Validate(); err != nil {
+		return 0, err
+	}
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return 0, err
+	}
+
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	for roomUUID := range conferences {
+		broadcast(roomUUID, websocketMessage{Event: "notification", Data: string(data)}, nil)
+	}
+
+	return len(conferences), nil
+}
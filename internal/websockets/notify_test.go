@@ -0,0 +1,75 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestNotifyRoomReachesEveryPeer(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := dialRoom(t, srv, roomUUID), dialRoom(t, srv, roomUUID)
+	outsider := dialRoom(t, srv, otherUUID)
+	waitForPeers(t, roomUUID, 2)
+	waitForPeers(t, otherUUID, 1)
+
+	sent := Notification{Level: "warn", Message: "maintenance in 5 minutes"}
+	if err := NotifyRoom(roomUUID, sent); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ws := range []*websocket.Conn{first, second} {
+		message, ok := nextEvent(t, ws, "notification", 5*time.Second)
+		if !ok {
+			t.Fatalf("peer %d got no notification", i+1)
+		}
+
+		received := Notification{}
+		if err := json.Unmarshal([]byte(message.Data), &received); err != nil {
+			t.Fatal(err)
+		}
+		if received != sent {
+			t.Fatalf("peer %d got %+v, want %+v", i+1, received, sent)
+		}
+	}
+
+	if _, ok := nextEvent(t, outsider, "notification", 200*time.Millisecond); ok {
+		t.Fatal("a peer of another room got the notification")
+	}
+}
+
+func TestNotifyRoomRejects(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		roomUUID string
+		n        Notification
+		want     error
+	}{
+		{name: "unknown level", roomUUID: roomUUID, n: Notification{Level: "error", Message: "hi"}, want: ErrInvalidNotification},
+		{name: "blank message", roomUUID: roomUUID, n: Notification{Level: "info", Message: "  "}, want: ErrInvalidNotification},
+		{name: "unknown room", roomUUID: "no-such-room", n: Notification{Level: "info", Message: "hi"}, want: ErrRoomNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NotifyRoom(tt.roomUUID, tt.n); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"time"
+)
+
+// minOfferInterval is the least time between two offers sent to the same
+// peer, 0 sends them as fast as the room syncs
+var minOfferInterval time.Duration
+
+func init() {
+	minOfferInterval = config.Duration("MIN_OFFER_INTERVAL", 0)
+}
+
+// offerThrottled reports whether the peer got an offer too recently for a new
+// one. The sync of the peer is then postponed until the interval is over, the
+// triggers arriving meanwhile all fold into that one offer. It guards against
+// a client looping through renegotiations, which room syncs alone don't
+// coalesce. listLock must be held
+func (p *peerConnectionState) offerThrottled(roomUUID string) bool {
+	if minOfferInterval == 0 || p.lastOfferAt.IsZero() {
+		return false
+	}
+
+	wait := minOfferInterval - time.Since(p.lastOfferAt)
+	if wait <= 0 {
+		return false
+	}
+
+	if !p.offerDeferred {
+		p.offerDeferred = true
+		time.AfterFunc(wait, func() {
+			listLock.Lock()
+			defer listLock.Unlock()
+
+			p.offerDeferred = false
+			if findPeer(roomUUID, p.id) != p {
+				return
+			}
+
+			// Only this peer is synced: a room sync would hold back the peers
+			// offered in the meantime, and their syncs this one in turn
+			for attempt := 0; attempt < 25; attempt++ {
+				if !syncPeer(roomUUID, p) {
+					return
+				}
+			}
+			requestSignal(roomUUID)
+		})
+	}
+
+	return true
+}
@@ -0,0 +1,162 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/pion/webrtc/v3"
+	"testing"
+	"time"
+)
+
+// useMinOfferInterval sets MIN_OFFER_INTERVAL for the test, under listLock
+// since deferred syncs read it
+func useMinOfferInterval(t *testing.T, interval time.Duration) {
+	t.Helper()
+
+	listLock.Lock()
+	previous := minOfferInterval
+	minOfferInterval = interval
+	listLock.Unlock()
+
+	t.Cleanup(func() {
+		listLock.Lock()
+		minOfferInterval = previous
+		listLock.Unlock()
+	})
+}
+
+// offersSent counts the offers written to the connection
+func offersSent(conn *fakeConn) int {
+	n := 0
+	for _, event := range conn.events() {
+		if event == "offer" {
+			n++
+		}
+	}
+	return n
+}
+
+// newNegotiatingPeer is a peer receiving video, with the client answering
+// its offers
+func newNegotiatingPeer(t *testing.T, id string) (*peerConnectionState, *fakeConn, *webrtc.PeerConnection) {
+	t.Helper()
+
+	peer := newTestPeer(t)
+	conn := &fakeConn{}
+	peer.id, peer.websocket = id, conn
+	if _, err := peer.peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return peer, conn, newTestClient(t)
+}
+
+// answerPendingOffer has client answer the offer the peer is waiting on, if
+// any. listLock must be held
+func answerPendingOffer(client *webrtc.PeerConnection, peer *peerConnectionState) error {
+	if peer.peerConnection.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+		return nil
+	}
+
+	if err := client.SetRemoteDescription(*peer.peerConnection.LocalDescription()); err != nil {
+		return err
+	}
+	answer, err := client.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := client.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	if err := peer.peerConnection.SetRemoteDescription(answer); err != nil {
+		return err
+	}
+	peer.answered()
+	return nil
+}
+
+func TestOffersAreThrottledPerPeer(t *testing.T) {
+	const interval = 400 * time.Millisecond
+	useMinOfferInterval(t, interval)
+
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, aliceConn, aliceClient := newNegotiatingPeer(t, "alice")
+	bob, bobConn, bobClient := newNegotiatingPeer(t, "bob")
+
+	listLock.Lock()
+	peerConnections[roomUUID] = append(peerConnections[roomUUID], alice, bob)
+
+	// Bob was sent an offer half an interval ago, alice never was. Their
+	// deferred syncs are further apart than SIGNAL_DEBOUNCE
+	bob.lastOfferAt = time.Now().Add(-interval / 2)
+
+	// Clients looping through renegotiations
+	for i := 0; i < 10 && err == nil; i++ {
+		syncPeer(roomUUID, alice)
+		syncPeer(roomUUID, bob)
+		err = errors.Join(answerPendingOffer(aliceClient, alice), answerPendingOffer(bobClient, bob))
+	}
+	listLock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if toAlice, toBob := offersSent(aliceConn), offersSent(bobConn); toAlice != 1 || toBob != 0 {
+		t.Fatalf("got %d offers to alice and %d to bob, want 1 and 0", toAlice, toBob)
+	}
+
+	time.Sleep(interval / 4)
+	if toAlice, toBob := offersSent(aliceConn), offersSent(bobConn); toAlice != 1 || toBob != 0 {
+		t.Fatalf("got %d offers to alice and %d to bob within the interval, want 1 and 0", toAlice, toBob)
+	}
+
+	// The syncs held back fold into one offer each once the interval is over
+	for deadline := time.Now().Add(5 * time.Second); offersSent(aliceConn) < 2 || offersSent(bobConn) < 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d offers to alice and %d to bob, want the deferred ones", offersSent(aliceConn), offersSent(bobConn))
+		}
+	}
+
+	listLock.Lock()
+	err = errors.Join(answerPendingOffer(aliceClient, alice), answerPendingOffer(bobClient, bob))
+	listLock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing changed since, so no more offers follow
+	time.Sleep(2 * interval)
+	if toAlice, toBob := offersSent(aliceConn), offersSent(bobConn); toAlice != 2 || toBob != 1 {
+		t.Fatalf("got %d offers to alice and %d to bob, want 2 and 1", toAlice, toBob)
+	}
+}
+
+func TestOffersAreNotThrottledByDefault(t *testing.T) {
+	useMinOfferInterval(t, 0)
+
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer, conn, client := newNegotiatingPeer(t, "alice")
+
+	listLock.Lock()
+	for i := 0; i < 5 && err == nil; i++ {
+		syncPeer(roomUUID, peer)
+		err = answerPendingOffer(client, peer)
+	}
+	listLock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := offersSent(conn); got != 5 {
+		t.Fatalf("got %d offers, want 5", got)
+	}
+}
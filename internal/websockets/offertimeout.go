@@ -0,0 +1,92 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"log"
+	"time"
+)
+
+// maxOfferRetries is how many times an unanswered offer is sent again before the peer is dropped
+const maxOfferRetries = 2
+
+// offerTimeout is how long the client has to answer an offer, 0 waits forever
+var offerTimeout time.Duration
+
+func init() {
+	offerTimeout = config.Duration("OFFER_TIMEOUT", 10*time.Second)
+}
+
+// watchOffer starts the answer timeout of the offer just sent to the peer.
+// listLock must be held
+func (p *peerConnectionState) watchOffer(roomUUID string) {
+	if offerTimeout == 0 {
+		return
+	}
+
+	if p.offerTimer != nil {
+		p.offerTimer.Stop()
+	}
+
+	p.offerGeneration++
+	generation := p.offerGeneration
+	p.offerTimer = time.AfterFunc(offerTimeout, func() { offerExpired(roomUUID, p, generation) })
+}
+
+// answered stops the answer timeout. listLock must be held
+func (p *peerConnectionState) answered() {
+	if p.offerTimer != nil {
+		p.offerTimer.Stop()
+		p.offerTimer = nil
+	}
+	p.offerRetries = 0
+	p.traceAnswer(nil)
+}
+
+// offerExpired sends the unanswered offer again, the first one may have been
+// lost. A peer that keeps not answering is disconnected, a half negotiated
+// PeerConnection would stall the renegotiation of the whole room. The offer
+// is resent rather than rolled back and made anew because Pion has no
+// rollback out of have-local-offer, see answerRemoteOffer
+func offerExpired(roomUUID string, p *peerConnectionState, generation uint64) {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	if p.offerGeneration != generation || p.offerTimer == nil ||
+		p.peerConnection.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+		return
+	}
+	p.offerTimer = nil
+	p.offerRetries++
+
+	if p.offerRetries > maxOfferRetries {
+		log.Printf("peer %s didn't answer %d offers", p.id, p.offerRetries)
+		_ = p.websocket.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "offer timeout"), time.Now().Add(time.Second))
+		_ = p.websocket.Close()
+		return
+	}
+
+	sent, err := withBandwidthLimit(*p.peerConnection.PendingLocalDescription())
+	if err != nil {
+		logSampled("offer timeout: bandwidth limit:", err)
+		return
+	}
+
+	offerString, err := json.Marshal(sent)
+	if err != nil {
+		return
+	}
+
+	if err = p.websocket.WriteJSON(&websocketMessage{
+		Event: "offer",
+		Data:  string(offerString),
+	}); err != nil {
+		logSampled("offer timeout: send offer:", err)
+		return
+	}
+
+	p.watchOffer(roomUUID)
+}
@@ -0,0 +1,79 @@
+package websockets
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// websocketPair returns the server end of a websocket, wrapped as peers have
+// it, and the client end
+func websocketPair(t *testing.T) (*threadSafeWriter, *websocket.Conn) {
+	t.Helper()
+
+	accepted := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	server := <-accepted
+	t.Cleanup(func() { _ = server.Close() })
+
+	return &threadSafeWriter{Conn: server}, client
+}
+
+func TestUnansweredOfferIsResentThenDropped(t *testing.T) {
+	previous := offerTimeout
+	offerTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { offerTimeout = previous })
+
+	peer := newTestPeer(t)
+	server, client := websocketPair(t)
+	peer.websocket = server
+
+	// The client never answers the offer
+	serverOffer(t, peer)
+	listLock.Lock()
+	peer.watchOffer("")
+	listLock.Unlock()
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	events, closeErr := readUntilClose(t, client)
+	if len(events) != maxOfferRetries || events[0] != "offer" || events[len(events)-1] != "offer" {
+		t.Fatalf("got events %v, want the offer resent %d times", events, maxOfferRetries)
+	}
+	if closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "offer timeout" {
+		t.Fatalf("got close %d %q, want %d \"offer timeout\"", closeErr.Code, closeErr.Text, websocket.ClosePolicyViolation)
+	}
+}
+
+// The timeout resends offers and glare keeps the server's offer because Pion
+// can't roll a local offer back. Should that change, both can roll back instead
+func TestLocalOfferCantBeRolledBack(t *testing.T) {
+	peer := newTestPeer(t)
+	serverOffer(t, peer)
+
+	rollback := webrtc.SessionDescription{
+		Type: webrtc.SDPTypeRollback,
+		SDP:  peer.peerConnection.PendingLocalDescription().SDP,
+	}
+	if err := peer.peerConnection.SetLocalDescription(rollback); err == nil {
+		t.Fatal("Pion rolled back a local offer, answerRemoteOffer and offerExpired can use rollback now")
+	}
+}
@@ -0,0 +1,95 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/auth"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// singleUseIdentity is the caller of a fresh single use token
+func singleUseIdentity() auth.Identity {
+	return auth.Identity{SingleUse: true, TokenID: uuid.NewString(), ExpiresAt: time.Now().Add(time.Minute)}
+}
+
+// refusedUpgrade checks the websocket at path is refused with 401
+func refusedUpgrade(t *testing.T, srv *httptest.Server, path string) {
+	t.Helper()
+
+	ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, nil)
+	if err == nil {
+		_ = ws.Close()
+		t.Fatal("a spent token opened a websocket")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got %v, want status %d", err, http.StatusUnauthorized)
+	}
+}
+
+func TestSingleUseTokenSpentByTheJoin(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServerAs(t, singleUseIdentity())
+
+	// A refused join leaves the token good
+	_, closeErr := readUntilClose(t, dialRoom(t, srv, "no-such-room"))
+	if closeErr.Text != ErrRoomNotFound.Error() {
+		t.Fatalf("got close %q, want %q", closeErr.Text, ErrRoomNotFound)
+	}
+
+	dialRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 1)
+
+	refusedUpgrade(t, srv, "/websocket/"+roomUUID+"/join")
+}
+
+func TestMultiplexSingleUseToken(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServerAs(t, singleUseIdentity())
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/websocket/multiplex", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	reply := func(room string) websocketMessage {
+		t.Helper()
+
+		if err := ws.WriteJSON(websocketMessage{Event: "join", Room: room}); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			message := websocketMessage{}
+			if err := ws.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			}
+			if message.Room == room {
+				return message
+			}
+		}
+	}
+
+	// Only a join that goes through spends the token, and only once
+	if message := reply("no-such-room"); message.Event != "error" {
+		t.Fatalf("joining an unknown room: got %+v, want an error", message)
+	}
+	if message := reply(roomUUID); message.Event != "joined" {
+		t.Fatalf("joining the room: got %+v, want joined", message)
+	}
+
+	// The connection may go on joining rooms, a second one may not open
+	if message := reply(roomUUID); message.Event != "joined" {
+		t.Fatalf("joining the room again: got %+v, want joined", message)
+	}
+	refusedUpgrade(t, srv, "/websocket/multiplex")
+}
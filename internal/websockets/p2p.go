@@ -0,0 +1,98 @@
+package websockets
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// tryP2PMessage tells one peer of a two peer room to attempt a direct
+// connection with the other. The SFU keeps forwarding meanwhile, so a client
+// whose direct connection fails simply keeps using it
+type tryP2PMessage struct {
+	PeerID string `json:"peerId"`
+
+	// Offerer is set on the peer that joined first, so only one side offers
+	Offerer bool `json:"offerer"`
+}
+
+// p2pSignal carries the SDP and candidates of a direct connection. Clients
+// send it with the peer it is meant for, the server relays it with the sender
+type p2pSignal struct {
+	PeerID string          `json:"peerId"`
+	Signal json.RawMessage `json:"signal"`
+}
+
+// updateP2P hints the peers of a PreferP2P room to connect directly while
+// there are exactly two of them, and to go back to the SFU once that changes.
+// listLock must be held
+func updateP2P(roomUUID string) {
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return
+	}
+
+	peers := peerConnections[roomUUID]
+	eligible := r.config.PreferP2P && len(peers) == 2
+
+	switch {
+	case eligible && !r.p2p:
+		r.p2p = true
+		sendTryP2P(peers[0], peers[1], true)
+		sendTryP2P(peers[1], peers[0], false)
+	case !eligible && r.p2p:
+		r.p2p = false
+		broadcast(roomUUID, websocketMessage{Event: "p2p_end"}, nil)
+	}
+}
+
+func sendTryP2P(p, other *peerConnectionState, offerer bool) {
+	data, err := json.Marshal(tryP2PMessage{PeerID: other.id, Offerer: offerer})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := p.websocket.WriteJSON(&websocketMessage{
+		Event: "try_p2p",
+		Data:  string(data),
+	}); err != nil {
+		logSampled(err)
+	}
+}
+
+// relayP2PSignal forwards a direct connection signal to the other peer of the
+// room, false if the room isn't in direct mode or the peer is unknown
+func relayP2PSignal(roomUUID string, from *peerConnectionState, message *websocketMessage) bool {
+	signal := p2pSignal{}
+	if err := json.Unmarshal([]byte(message.Data), &signal); err != nil {
+		return false
+	}
+
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok || !r.p2p {
+		return false
+	}
+
+	to := findPeer(roomUUID, signal.PeerID)
+	if to == nil || to == from {
+		return false
+	}
+
+	data, err := json.Marshal(p2pSignal{PeerID: from.id, Signal: signal.Signal})
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	if err := to.websocket.WriteJSON(&websocketMessage{
+		Event: "p2p_signal",
+		Data:  string(data),
+	}); err != nil {
+		logSampled(err)
+	}
+
+	return true
+}
@@ -0,0 +1,80 @@
+package websockets
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUpdateP2P(t *testing.T) {
+	config := DefaultRoomConfig()
+	config.PreferP2P = true
+	roomUUID, _, err := AddRoomUUID("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join := func(peerID string) *fakeConn {
+		conn := addFakePeer(t, roomUUID, peerID)
+		listLock.Lock()
+		updateP2P(roomUUID)
+		listLock.Unlock()
+		return conn
+	}
+
+	// hint returns the try_p2p the peer got, failing if it got none
+	hint := func(peerID string, conn *fakeConn) tryP2PMessage {
+		t.Helper()
+
+		message, ok := conn.message("try_p2p")
+		if !ok {
+			t.Fatalf("%s got no try_p2p", peerID)
+		}
+		hint := tryP2PMessage{}
+		if err := json.Unmarshal([]byte(message.Data), &hint); err != nil {
+			t.Fatal(err)
+		}
+		return hint
+	}
+
+	alice := join("alice")
+	if events := alice.events(); len(events) != 0 {
+		t.Fatalf("alone in the room, alice got %v", events)
+	}
+
+	bob := join("bob")
+	if got := hint("alice", alice); got != (tryP2PMessage{PeerID: "bob", Offerer: true}) {
+		t.Fatalf("alice got %+v, want to offer to bob", got)
+	}
+	if got := hint("bob", bob); got != (tryP2PMessage{PeerID: "alice"}) {
+		t.Fatalf("bob got %+v, want to answer alice", got)
+	}
+
+	// A third peer sends everyone back to the SFU
+	carol := join("carol")
+	if _, ok := carol.message("try_p2p"); ok {
+		t.Fatal("carol got try_p2p in a room of three")
+	}
+	for peerID, conn := range map[string]*fakeConn{"alice": alice, "bob": bob, "carol": carol} {
+		if _, ok := conn.message("p2p_end"); !ok {
+			t.Fatalf("%s got no p2p_end", peerID)
+		}
+	}
+}
+
+func TestUpdateP2PNeedsPreferP2P(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conns := []*fakeConn{addFakePeer(t, roomUUID, "alice"), addFakePeer(t, roomUUID, "bob")}
+	listLock.Lock()
+	updateP2P(roomUUID)
+	listLock.Unlock()
+
+	for _, conn := range conns {
+		if events := conn.events(); len(events) != 0 {
+			t.Fatalf("got %v in a room not preferring P2P", events)
+		}
+	}
+}
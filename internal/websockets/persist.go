@@ -0,0 +1,184 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/b4o4/conference-backend/internal/config"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// roomStoreInterval is how often the registry is written while running,
+// bounding what a crash loses
+const roomStoreInterval = 5 * time.Second
+
+var (
+	// roomStoreFile keeps the rooms across restarts, empty keeps them in memory only
+	roomStoreFile string
+
+	// reconnectWindow is how long rooms loaded at startup wait for their
+	// clients to come back
+	reconnectWindow time.Duration
+
+	// saveMu keeps the periodic save and the one on shutdown from sharing the
+	// tmp file, storeClosed ends the saves once the shutdown wrote the rooms
+	saveMu      sync.Mutex
+	storeClosed bool
+)
+
+func init() {
+	roomStoreFile = config.String("ROOM_STORE_FILE", "")
+	reconnectWindow = config.Duration("RECONNECT_WINDOW", 2*time.Minute)
+}
+
+// roomRegistry is the content of the ROOM_STORE_FILE
+type roomRegistry struct {
+	SavedAt time.Time      `json:"savedAt"`
+	Rooms   []RoomSnapshot `json:"rooms"`
+}
+
+// LoadRooms restores the rooms saved by the previous run and starts saving
+// them in turn. It must be called once, before clients connect. Rooms nobody
+// rejoins within the RECONNECT_WINDOW are removed, and a registry saved
+// longer ago than that is ignored altogether
+func LoadRooms() error {
+	if roomStoreFile == "" {
+		return nil
+	}
+
+	restored, err := loadRooms(roomStoreFile, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if len(restored) > 0 {
+		log.Printf("restored %d rooms, waiting %s for their clients", len(restored), reconnectWindow)
+		time.AfterFunc(reconnectWindow, func() { purgeUnclaimedRooms(restored) })
+	}
+
+	go saveRoomsEvery(roomStoreInterval)
+
+	return nil
+}
+
+// loadRooms registers the rooms of the file and returns their UUIDs, a
+// missing file is a first start
+func loadRooms(path string, now time.Time) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var registry roomRegistry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, err
+	}
+
+	if now.Sub(registry.SavedAt) > reconnectWindow {
+		log.Printf("ROOM_STORE_FILE saved %s ago, past the reconnect window, not restoring", now.Sub(registry.SavedAt).Round(time.Second))
+		return nil, nil
+	}
+
+	restored := []string{}
+	for _, s := range registry.Rooms {
+		r, err := restoreRoom(s)
+		if err != nil {
+			log.Printf("not restoring room %s: %v", s.UUID, err)
+			continue
+		}
+
+		listLock.Lock()
+		r.unclaimed = true
+		listLock.Unlock()
+
+		restored = append(restored, s.UUID)
+	}
+
+	return restored, nil
+}
+
+// purgeUnclaimedRooms removes the restored rooms nobody came back to
+func purgeUnclaimedRooms(roomUUIDs []string) {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	for _, roomUUID := range roomUUIDs {
+		if r, ok := conferences[roomUUID]; ok && r.unclaimed {
+			log.Printf("room %s wasn't reclaimed after the restart, removing it", roomUUID)
+			unregisterRoom(roomUUID, "room_expired")
+		}
+	}
+}
+
+// closeRoomStore writes the rooms a last time on shutdown, before the peers
+// leave and the emptied rooms get removed
+func closeRoomStore() {
+	if roomStoreFile == "" {
+		return
+	}
+
+	if err := saveRooms(); err != nil {
+		log.Printf("ROOM_STORE_FILE: %v", err)
+	}
+
+	saveMu.Lock()
+	storeClosed = true
+	saveMu.Unlock()
+}
+
+func saveRoomsEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := saveRooms(); err != nil {
+			log.Printf("ROOM_STORE_FILE: %v", err)
+		}
+	}
+}
+
+// saveRooms writes the registry through a rename so a crash never leaves it
+// half written. It is rewritten even unchanged, savedAt tells the next run
+// how long the server was down
+func saveRooms() error {
+	listLock.RLock()
+	rooms := make([]RoomSnapshot, 0, len(conferences))
+	for roomUUID, r := range conferences {
+		if r.expired {
+			continue
+		}
+
+		rooms = append(rooms, RoomSnapshot{
+			UUID:      roomUUID,
+			HostKey:   r.hostKey,
+			Tenant:    r.tenant,
+			Config:    r.config,
+			CreatedAt: r.createdAt,
+			Locked:    r.locked,
+		})
+	}
+	listLock.RUnlock()
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].UUID < rooms[j].UUID })
+
+	data, err := json.MarshalIndent(roomRegistry{SavedAt: time.Now().UTC(), Rooms: rooms}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	if storeClosed {
+		return nil
+	}
+
+	if err := os.WriteFile(roomStoreFile+".tmp", data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(roomStoreFile+".tmp", roomStoreFile)
+}
@@ -0,0 +1,138 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useRoomStore points ROOM_STORE_FILE at a file of the test
+func useRoomStore(t *testing.T) string {
+	t.Helper()
+
+	previous := roomStoreFile
+	roomStoreFile = filepath.Join(t.TempDir(), "rooms.json")
+	t.Cleanup(func() { roomStoreFile = previous })
+
+	return roomStoreFile
+}
+
+// writeRegistry saves the rooms to path as a run ended at savedAt would have
+func writeRegistry(t *testing.T, path string, savedAt time.Time, rooms ...RoomSnapshot) {
+	t.Helper()
+
+	data, err := json.Marshal(roomRegistry{SavedAt: savedAt, Rooms: rooms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// roomExists reports whether the room is registered
+func roomExists(roomUUID string) bool {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	_, ok := conferences[roomUUID]
+	return ok
+}
+
+func TestSaveRooms(t *testing.T) {
+	path := useRoomStore(t)
+
+	roomUUID, hostKey, err := AddRoomUUID("acme", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRoomLocked(roomUUID, hostKey, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := saveRooms(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("the tmp file is left behind: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry roomRegistry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(registry.SavedAt) > time.Minute {
+		t.Fatalf("got saved at %v, want now", registry.SavedAt)
+	}
+	for _, s := range registry.Rooms {
+		if s.UUID != roomUUID {
+			continue
+		}
+		if s.HostKey != hostKey || s.Tenant != "acme" || !s.Locked {
+			t.Fatalf("got %+v, want the locked room of acme with its host key", s)
+		}
+		return
+	}
+	t.Fatalf("room %s isn't in the registry", roomUUID)
+}
+
+func TestLoadRooms(t *testing.T) {
+	path := useRoomStore(t)
+	now := time.Now()
+
+	t.Run("missing file", func(t *testing.T) {
+		restored, err := loadRooms(path, now)
+		if err != nil || len(restored) != 0 {
+			t.Fatalf("got %v, %v, want nothing restored", restored, err)
+		}
+	})
+
+	t.Run("saved past the reconnect window", func(t *testing.T) {
+		s := RoomSnapshot{UUID: uuid.NewString(), HostKey: uuid.NewString(), Config: DefaultRoomConfig()}
+		writeRegistry(t, path, now.Add(-reconnectWindow-time.Second), s)
+
+		restored, err := loadRooms(path, now)
+		if err != nil || len(restored) != 0 {
+			t.Fatalf("got %v, %v, want nothing restored", restored, err)
+		}
+		if roomExists(s.UUID) {
+			t.Fatal("a stale registry was restored")
+		}
+	})
+
+	t.Run("rooms nobody rejoins are purged", func(t *testing.T) {
+		claimed := RoomSnapshot{UUID: uuid.NewString(), HostKey: uuid.NewString(), Config: DefaultRoomConfig()}
+		abandoned := RoomSnapshot{UUID: uuid.NewString(), HostKey: uuid.NewString(), Config: DefaultRoomConfig()}
+		expired := RoomSnapshot{UUID: uuid.NewString(), HostKey: uuid.NewString(), Config: DefaultRoomConfig(), Expired: true}
+		writeRegistry(t, path, now.Add(-time.Second), claimed, abandoned, expired)
+
+		restored, err := loadRooms(path, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(restored) != 2 || !roomExists(claimed.UUID) || !roomExists(abandoned.UUID) || roomExists(expired.UUID) {
+			t.Fatalf("got rooms %v restored, want %s and %s", restored, claimed.UUID, abandoned.UUID)
+		}
+
+		// A client comes back to one of them within the window
+		srv := newTestServer(t)
+		joinRoom(t, srv, claimed.UUID)
+		waitForPeers(t, claimed.UUID, 1)
+
+		purgeUnclaimedRooms(restored)
+		if !roomExists(claimed.UUID) {
+			t.Fatal("the rejoined room was purged")
+		}
+		if roomExists(abandoned.UUID) {
+			t.Fatal("the abandoned room is still there")
+		}
+	})
+}
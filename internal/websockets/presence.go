@@ -0,0 +1,62 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/gorilla/websocket"
+	"log"
+	"time"
+)
+
+// presenceTTL is how long a peer may stay silent, neither messages nor pongs,
+// before it is considered gone. 0 disables the sweeper
+var presenceTTL time.Duration
+
+func init() {
+	presenceTTL = config.Duration("PRESENCE_TTL", 30*time.Second)
+	if presenceTTL > 0 {
+		go sweepPresenceEvery(presenceTTL / 3)
+	}
+}
+
+// touch records that the peer just showed signs of life
+func (p *peerConnectionState) touch() {
+	p.lastSeen.Store(time.Now().UnixNano())
+}
+
+// silentFor returns how long ago the peer was last seen
+func (p *peerConnectionState) silentFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, p.lastSeen.Load()))
+}
+
+// sweepPresenceEvery sweeps the peers every interval. Three sweeps fit in a
+// TTL, a single lost pong doesn't get a live peer dropped
+func sweepPresenceEvery(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		sweepPresence(now, presenceTTL)
+	}
+}
+
+// sweepPresence pings every peer so idle ones have something to answer, and
+// drops those silent for longer than ttl
+func sweepPresence(now time.Time, ttl time.Duration) {
+	listLock.RLock()
+	peers := []*peerConnectionState{}
+	for _, roomPeers := range peerConnections {
+		peers = append(peers, roomPeers...)
+	}
+	listLock.RUnlock()
+
+	// Outside the lock, a ping may wait for a slow connection
+	for _, p := range peers {
+		if silent := p.silentFor(now); silent > ttl {
+			log.Printf("peer %s silent for %s, dropping", p.id, silent.Round(time.Second))
+			// Closing the socket ends the read loop, which cleans the peer up
+			p.websocket.markFailed()
+			continue
+		}
+
+		if err := p.websocket.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+			logSampled("presence: ping:", err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package websockets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSweepPresence(t *testing.T) {
+	const ttl = time.Minute
+
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		silent   time.Duration
+		wantGone bool
+	}{
+		{name: "just seen"},
+		{name: "silent within the TTL", silent: ttl - time.Second},
+		{name: "silent past the TTL", silent: ttl + time.Second, wantGone: true},
+	}
+
+	conns := map[string]*fakeConn{}
+	for _, tt := range tests {
+		conns[tt.name] = addFakePeer(t, roomUUID, tt.name)
+
+		listLock.RLock()
+		findPeer(roomUUID, tt.name).lastSeen.Store(now.Add(-tt.silent).UnixNano())
+		listLock.RUnlock()
+	}
+
+	sweepPresence(now, ttl)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := conns[tt.name]
+			if conn.broken() != tt.wantGone {
+				t.Fatalf("got dropped %v, want %v", conn.broken(), tt.wantGone)
+			}
+
+			// Peers still present are pinged so they have something to answer
+			wantPings := int32(1)
+			if tt.wantGone {
+				wantPings = 0
+			}
+			if got := conn.pings.Load(); got != wantPings {
+				t.Fatalf("got %d pings, want %d", got, wantPings)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package websockets
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+const (
+	// ProtocolVersion is the newest signaling protocol the server speaks,
+	// bumped whenever messages change in a way older clients can't handle
+	ProtocolVersion = 1
+
+	// MinProtocolVersion is the oldest protocol still accepted
+	MinProtocolVersion = 1
+)
+
+// helloMessage is sent by the client with the newest protocol it speaks and
+// answered by the server with the version both will use
+type helloMessage struct {
+	Version int `json:"version"`
+}
+
+type helloRejectedMessage struct {
+	Message    string `json:"message"`
+	MinVersion int    `json:"minVersion"`
+	MaxVersion int    `json:"maxVersion"`
+}
+
+// negotiateProtocol picks the version to speak with a client supporting up
+// to clientVersion, false if the client is too old
+func negotiateProtocol(clientVersion int) (int, bool) {
+	if clientVersion < MinProtocolVersion {
+		return 0, false
+	}
+
+	return min(clientVersion, ProtocolVersion), true
+}
+
+// handleHello answers the client's hello, false if the client must be disconnected
+func handleHello(conn signalConn, message *websocketMessage) bool {
+	hello := helloMessage{}
+	if err := json.Unmarshal([]byte(message.Data), &hello); err != nil {
+		sendError(conn, "malformed hello")
+		return true
+	}
+
+	version, ok := negotiateProtocol(hello.Version)
+	if !ok {
+		data, err := json.Marshal(helloRejectedMessage{
+			Message:    fmt.Sprintf("protocol version %d is not supported, update the client", hello.Version),
+			MinVersion: MinProtocolVersion,
+			MaxVersion: ProtocolVersion,
+		})
+		if err == nil {
+			err = conn.WriteJSON(&websocketMessage{Event: "hello_rejected", Data: string(data)})
+		}
+		if err != nil {
+			log.Println(err)
+		}
+
+		return false
+	}
+
+	data, err := json.Marshal(helloMessage{Version: version})
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+
+	if err := conn.WriteJSON(&websocketMessage{Event: "hello", Data: string(data)}); err != nil {
+		log.Println(err)
+	}
+
+	return true
+}
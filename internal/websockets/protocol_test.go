@@ -0,0 +1,66 @@
+package websockets
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNegotiateProtocol(t *testing.T) {
+	tests := []struct {
+		name   string
+		client int
+		want   int
+		wantOK bool
+	}{
+		{name: "too old", client: MinProtocolVersion - 1, wantOK: false},
+		{name: "oldest accepted", client: MinProtocolVersion, want: MinProtocolVersion, wantOK: true},
+		{name: "current", client: ProtocolVersion, want: ProtocolVersion, wantOK: true},
+		{name: "newer than the server", client: ProtocolVersion + 5, want: ProtocolVersion, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := negotiateProtocol(tt.client)
+			if ok != tt.wantOK || ok && got != tt.want {
+				t.Fatalf("negotiateProtocol(%d) = %d, %v, want %d, %v", tt.client, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHandleHello(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantOK    bool
+		wantEvent string
+	}{
+		{name: "supported", data: `{"version":1}`, wantOK: true, wantEvent: "hello"},
+		{name: "newer client", data: `{"version":99}`, wantOK: true, wantEvent: "hello"},
+		{name: "too old", data: `{"version":0}`, wantOK: false, wantEvent: "hello_rejected"},
+		{name: "malformed", data: `{`, wantOK: true, wantEvent: "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{}
+			if ok := handleHello(conn, &websocketMessage{Event: "hello", Data: tt.data}); ok != tt.wantOK {
+				t.Fatalf("handleHello returned %v, want %v", ok, tt.wantOK)
+			}
+
+			if len(conn.written) != 1 || conn.written[0].Event != tt.wantEvent {
+				t.Fatalf("got events %v, want [%s]", conn.events(), tt.wantEvent)
+			}
+
+			if tt.wantEvent == "hello" {
+				reply := helloMessage{}
+				if err := json.Unmarshal([]byte(conn.written[0].Data), &reply); err != nil {
+					t.Fatal(err)
+				}
+				if reply.Version != ProtocolVersion {
+					t.Fatalf("got version %d, want %d", reply.Version, ProtocolVersion)
+				}
+			}
+		})
+	}
+}
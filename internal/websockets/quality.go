@@ -0,0 +1,44 @@
+package websockets
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// QualityReport is the connection quality a client measured on its side,
+// as the browser's stats see it
+type QualityReport struct {
+	// RTT and Jitter are in milliseconds
+	RTT    float64 `json:"rtt"`
+	Jitter float64 `json:"jitter"`
+
+	// PacketLoss is the fraction of packets lost, from 0 to 1
+	PacketLoss float64 `json:"packetLoss"`
+
+	ReportedAt time.Time `json:"reportedAt"`
+}
+
+func (q QualityReport) valid() bool {
+	for _, v := range []float64{q.RTT, q.Jitter, q.PacketLoss} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+
+	return q.PacketLoss <= 1
+}
+
+// handleQualityReport records the latest quality report of the peer
+func handleQualityReport(conn signalConn, peer *peerConnectionState, message *websocketMessage) {
+	report := QualityReport{}
+	if err := json.Unmarshal([]byte(message.Data), &report); err != nil || !report.valid() {
+		sendError(conn, "malformed quality report")
+		return
+	}
+	report.ReportedAt = time.Now()
+
+	listLock.Lock()
+	peer.quality = &report
+	listLock.Unlock()
+}
@@ -0,0 +1,65 @@
+package websockets
+
+import "testing"
+
+func TestQualityReport(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := addFakePeer(t, roomUUID, "alice")
+
+	listLock.RLock()
+	alice := findPeer(roomUUID, "alice")
+	listLock.RUnlock()
+
+	// quality returns what the room stats report of alice's connection
+	quality := func() *QualityReport {
+		stats, err := GetRoomStats(roomUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats.Peers[0].Quality
+	}
+
+	if got := quality(); got != nil {
+		t.Fatalf("got %+v before any report", got)
+	}
+
+	report := websocketMessage{Event: "quality_report", Data: `{"rtt":42.5,"jitter":3,"packetLoss":0.02}`}
+	if !handleMessage(conn, alice, roomUUID, &report) {
+		t.Fatal("the quality report closed the connection")
+	}
+	got := quality()
+	if got == nil || got.RTT != 42.5 || got.Jitter != 3 || got.PacketLoss != 0.02 || got.ReportedAt.IsZero() {
+		t.Fatalf("got %+v, want rtt 42.5, jitter 3 and packetLoss 0.02 with a time", got)
+	}
+
+	invalid := []struct {
+		name string
+		data string
+	}{
+		{name: "malformed JSON", data: `{"rtt":`},
+		{name: "negative RTT", data: `{"rtt":-1,"jitter":3,"packetLoss":0}`},
+		{name: "negative jitter", data: `{"rtt":40,"jitter":-3,"packetLoss":0}`},
+		{name: "loss above 1", data: `{"rtt":40,"jitter":3,"packetLoss":1.5}`},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			conn.mu.Lock()
+			conn.written = nil
+			conn.mu.Unlock()
+
+			if !handleMessage(conn, alice, roomUUID, &websocketMessage{Event: "quality_report", Data: tt.data}) {
+				t.Fatal("the invalid report closed the connection")
+			}
+			if events := conn.events(); len(events) != 1 || events[0] != "error" {
+				t.Fatalf("got events %v, want [error]", events)
+			}
+			if current := quality(); current != got {
+				t.Fatalf("got %+v, want the previous report kept", current)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/b4o4/conference-backend/internal/config"
+	"log"
+	"time"
+)
+
+// maxMessagesPerSec limits the signaling messages a connection may send, 0 disables the limit
+var maxMessagesPerSec int
+
+func init() {
+	maxMessagesPerSec = config.Int("MAX_MESSAGES_PER_SEC", 50)
+}
+
+// tokenBucket refills rate tokens a second up to burst, each message takes one
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token if there is one. A nil bucket allows everything
+func (b *tokenBucket) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// sendError reports a problem with the client's messages without closing the connection
+func sendError(c signalConn, message string) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := c.WriteJSON(&websocketMessage{
+		Event: "error",
+		Data:  string(data),
+	}); err != nil {
+		log.Println(err)
+	}
+}
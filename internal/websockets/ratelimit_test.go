@@ -0,0 +1,49 @@
+package websockets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(5)
+
+	for i := 0; i < 5; i++ {
+		if !b.allow() {
+			t.Fatalf("message %d of the burst was refused", i+1)
+		}
+	}
+	if b.allow() {
+		t.Fatal("a message past the burst was allowed")
+	}
+
+	// Refills at rate tokens a second
+	b.last = b.last.Add(-400 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if !b.allow() {
+			t.Fatalf("refilled message %d was refused", i+1)
+		}
+	}
+	if b.allow() {
+		t.Fatal("more messages were allowed than refilled")
+	}
+
+	// Never holds more than the burst however long it was idle
+	b.last = b.last.Add(-time.Hour)
+	allowed := 0
+	for b.allow() {
+		allowed++
+	}
+	if allowed != 5 {
+		t.Fatalf("got %d messages after a long pause, want the burst of 5", allowed)
+	}
+}
+
+func TestTokenBucketNil(t *testing.T) {
+	var b *tokenBucket
+	for i := 0; i < 1000; i++ {
+		if !b.allow() {
+			t.Fatal("a nil bucket refused a message")
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package websockets
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/stun"
+	"github.com/pion/webrtc/v3"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// iceProbeTimeout bounds the binding request sent to one server
+const iceProbeTimeout = 2 * time.Second
+
+var errNoBindingResponse = errors.New("no binding response")
+
+var (
+	// checkICEServers has /readyz probe the ICE_SERVERS, probeCacheTTL is how
+	// long a round of probes answers for, so scrapes don't flood the servers
+	checkICEServers bool
+	probeCacheTTL   time.Duration
+
+	probes probeCache
+)
+
+func init() {
+	checkICEServers = config.Bool("READYZ_CHECK_ICE", false)
+	probeCacheTTL = config.Duration("READYZ_CACHE_TTL", 30*time.Second)
+}
+
+// ICEServerStatus is the outcome of probing one STUN or TURN URL
+type ICEServerStatus struct {
+	URL string `json:"url"`
+
+	// Required servers make the instance unready when unreachable, TURN ones
+	// since calls behind strict NATs can't do without them
+	Required  bool   `json:"required"`
+	Reachable bool   `json:"reachable"`
+	RTTMillis int64  `json:"rttMs,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type probeCache struct {
+	mu       sync.Mutex
+	statuses []ICEServerStatus
+	probedAt time.Time
+}
+
+// ICEReadiness probes the configured ICE servers with a STUN binding request
+// each and reports whether every required one answered. Results are reused
+// for READYZ_CACHE_TTL. Without READYZ_CHECK_ICE nothing is probed
+func ICEReadiness() ([]ICEServerStatus, bool) {
+	if !checkICEServers {
+		return nil, true
+	}
+
+	probes.mu.Lock()
+	defer probes.mu.Unlock()
+
+	if probes.statuses == nil || time.Since(probes.probedAt) >= probeCacheTTL {
+		probes.statuses = probeICEServers(iceServers)
+		probes.probedAt = time.Now()
+	}
+
+	ready := true
+	for _, s := range probes.statuses {
+		if s.Required && !s.Reachable {
+			ready = false
+		}
+	}
+
+	return append([]ICEServerStatus{}, probes.statuses...), ready
+}
+
+// probeICEServers probes every URL of the servers at once
+func probeICEServers(servers []webrtc.ICEServer) []ICEServerStatus {
+	statuses := []ICEServerStatus{}
+	for _, server := range servers {
+		for _, url := range server.URLs {
+			statuses = append(statuses, ICEServerStatus{URL: url})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(s *ICEServerStatus) {
+			defer wg.Done()
+
+			uri, err := stun.ParseURI(s.URL)
+			if err != nil {
+				s.Error = err.Error()
+				return
+			}
+			s.Required = uri.Scheme == stun.SchemeTypeTURN || uri.Scheme == stun.SchemeTypeTURNS
+
+			rtt, err := probeICEServer(uri)
+			if err != nil {
+				s.Error = err.Error()
+				return
+			}
+			s.Reachable = true
+			s.RTTMillis = rtt.Milliseconds()
+		}(&statuses[i])
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+// probeICEServer sends a binding request over the transport of the URI and
+// waits for the matching response. TURN servers answer them too, without
+// credentials, which is enough to tell they are up
+func probeICEServer(uri *stun.URI) (time.Duration, error) {
+	address := net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port))
+	secure := uri.Scheme == stun.SchemeTypeSTUNS || uri.Scheme == stun.SchemeTypeTURNS
+
+	start := time.Now()
+	dialer := &net.Dialer{Timeout: iceProbeTimeout}
+
+	var conn net.Conn
+	var err error
+	switch {
+	case secure:
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: uri.Host})
+	case uri.Proto == stun.ProtoTypeTCP:
+		conn, err = dialer.Dial("tcp", address)
+	default:
+		conn, err = dialer.Dial("udp", address)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close() //nolint
+
+	if err = conn.SetDeadline(start.Add(iceProbeTimeout)); err != nil {
+		return 0, err
+	}
+
+	request, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = conn.Write(request.Raw); err != nil {
+		return 0, err
+	}
+
+	response := &stun.Message{}
+	if secure || uri.Proto == stun.ProtoTypeTCP {
+		err = readStreamMessage(conn, response)
+	} else {
+		buf := make([]byte, 1500)
+		var n int
+		if n, err = conn.Read(buf); err == nil {
+			response.Raw = buf[:n]
+			err = response.Decode()
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errNoBindingResponse, err)
+	}
+
+	if response.TransactionID != request.TransactionID || response.Type.Method != stun.MethodBinding {
+		return 0, errNoBindingResponse
+	}
+
+	return time.Since(start), nil
+}
+
+// readStreamMessage reads one STUN message off a stream, where messages
+// follow each other with their length in the header
+func readStreamMessage(r io.Reader, m *stun.Message) error {
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+
+	raw := make([]byte, 20+int(binary.BigEndian.Uint16(header[2:4])))
+	copy(raw, header)
+	if _, err := io.ReadFull(r, raw[20:]); err != nil {
+		return err
+	}
+
+	m.Raw = raw
+	return m.Decode()
+}
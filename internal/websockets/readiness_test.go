@@ -0,0 +1,158 @@
+package websockets
+
+import (
+	"github.com/pion/stun"
+	"github.com/pion/webrtc/v3"
+	"net"
+	"testing"
+	"time"
+)
+
+// stubSTUNServer answers binding requests over UDP until the test ends and
+// returns its host:port
+func stubSTUNServer(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			request := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+			if err := request.Decode(); err != nil || request.Type != stun.BindingRequest {
+				continue
+			}
+
+			udpAddr := addr.(*net.UDPAddr)
+			response, err := stun.Build(
+				stun.NewTransactionIDSetter(request.TransactionID),
+				stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: udpAddr.IP, Port: udpAddr.Port},
+			)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(response.Raw, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// deadAddress is a UDP port nothing listens on
+func deadAddress(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := conn.LocalAddr().String()
+	_ = conn.Close()
+	return address
+}
+
+func TestProbeICEServers(t *testing.T) {
+	stub, dead := stubSTUNServer(t), deadAddress(t)
+
+	tests := []struct {
+		name          string
+		url           string
+		wantRequired  bool
+		wantReachable bool
+	}{
+		{name: "stun server", url: "stun:" + stub, wantReachable: true},
+		{name: "turn server", url: "turn:" + stub, wantRequired: true, wantReachable: true},
+		{name: "stun server down", url: "stun:" + dead},
+		{name: "turn server down", url: "turn:" + dead, wantRequired: true},
+		{name: "invalid url", url: "http://" + stub},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statuses := probeICEServers([]webrtc.ICEServer{{URLs: []string{tt.url}}})
+			if len(statuses) != 1 {
+				t.Fatalf("got %d statuses, want 1", len(statuses))
+			}
+
+			s := statuses[0]
+			if s.URL != tt.url || s.Required != tt.wantRequired || s.Reachable != tt.wantReachable {
+				t.Fatalf("got %+v, want required %v and reachable %v", s, tt.wantRequired, tt.wantReachable)
+			}
+			if !s.Reachable && s.Error == "" {
+				t.Fatal("an unreachable server has no error")
+			}
+		})
+	}
+}
+
+func TestICEReadiness(t *testing.T) {
+	stub, dead := stubSTUNServer(t), deadAddress(t)
+
+	previousCheck, previousTTL, previousServers := checkICEServers, probeCacheTTL, iceServers
+	t.Cleanup(func() {
+		checkICEServers, probeCacheTTL, iceServers = previousCheck, previousTTL, previousServers
+		probes = probeCache{}
+	})
+	checkICEServers, probeCacheTTL = true, time.Hour
+
+	tests := []struct {
+		name      string
+		urls      []string
+		wantReady bool
+	}{
+		{name: "every server up", urls: []string{"stun:" + stub, "turn:" + stub}, wantReady: true},
+		{name: "stun server down", urls: []string{"stun:" + dead, "turn:" + stub}, wantReady: true},
+		{name: "required turn server down", urls: []string{"stun:" + stub, "turn:" + dead}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iceServers = []webrtc.ICEServer{{URLs: tt.urls}}
+			probes = probeCache{}
+
+			statuses, ready := ICEReadiness()
+			if ready != tt.wantReady {
+				t.Fatalf("got ready %v with %+v, want %v", ready, statuses, tt.wantReady)
+			}
+			if len(statuses) != len(tt.urls) {
+				t.Fatalf("got %d statuses, want %d", len(statuses), len(tt.urls))
+			}
+		})
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		iceServers = []webrtc.ICEServer{{URLs: []string{"turn:" + stub}}}
+		probes = probeCache{}
+		if _, ready := ICEReadiness(); !ready {
+			t.Fatal("not ready with the turn server up")
+		}
+
+		// Within READYZ_CACHE_TTL the first round answers for the servers
+		iceServers = []webrtc.ICEServer{{URLs: []string{"turn:" + dead}}}
+		if _, ready := ICEReadiness(); !ready {
+			t.Fatal("probed again within the cache TTL")
+		}
+
+		probeCacheTTL = 0
+		if _, ready := ICEReadiness(); ready {
+			t.Fatal("still ready once the cache expired")
+		}
+	})
+
+	t.Run("not checked", func(t *testing.T) {
+		checkICEServers = false
+		if statuses, ready := ICEReadiness(); !ready || statuses != nil {
+			t.Fatalf("got %+v, %v without READYZ_CHECK_ICE, want nothing probed", statuses, ready)
+		}
+	})
+}
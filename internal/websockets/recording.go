@@ -0,0 +1,433 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAlreadyRecording   = errors.New("room is already being recorded")
+	ErrNotRecording       = errors.New("room is not being recorded")
+	ErrCodecNotRecordable = errors.New("codec can't be recorded")
+)
+
+// recordingDir is where every recording gets its own directory
+var recordingDir string
+
+func init() {
+	recordingDir = config.String("RECORDING_DIR", "recordings")
+}
+
+// RecordedTrack describes one media file of a recording
+type RecordedTrack struct {
+	PeerID    string     `json:"peerId"`
+	TrackID   string     `json:"trackId"`
+	Kind      string     `json:"kind"`
+	Codec     string     `json:"codec"`
+	File      string     `json:"file"`
+	StartedAt time.Time  `json:"startedAt"`
+	StoppedAt *time.Time `json:"stoppedAt,omitempty"`
+}
+
+// RecordingManifest lists the files of a recording so they can be assembled
+// later. It is kept up to date in manifest.json next to the media files
+type RecordingManifest struct {
+	RoomID    string          `json:"roomId"`
+	StartedAt time.Time       `json:"startedAt"`
+	StoppedAt *time.Time      `json:"stoppedAt,omitempty"`
+	Tracks    []RecordedTrack `json:"tracks"`
+}
+
+// recording is the recording in progress of a room. Its file work is queued
+// while listLock is held and done in order by a goroutine of its own, so a
+// slow disk never holds up the rooms
+type recording struct {
+	mu       sync.Mutex
+	dir      string
+	manifest RecordingManifest
+
+	// err is the first error creating the directory or writing the manifest
+	err error
+
+	// pending is the file work not done yet, working tells a goroutine runs it
+	pending []func()
+	working bool
+}
+
+// trackRecorder writes the packets of a single track to its file
+type trackRecorder struct {
+	mu          sync.Mutex
+	payloadType uint8
+	recording   *recording
+
+	// writer is nil until the file is open, and after it is closed. index
+	// is the entry of the file in the manifest
+	writer media.Writer
+	index  int
+}
+
+// StartRecording records every track of the room, current and future ones,
+// until StopRecording. Only the host may start it. Unless the room's consent
+// policy is none the peers are asked first, ErrAwaitingConsent tells the
+// recording starts once they agree
+func StartRecording(roomUUID, hostKey string) (RecordingManifest, error) {
+	rec, err := requestRecording(roomUUID, hostKey)
+	if err != nil {
+		return RecordingManifest{}, err
+	}
+
+	// The manifest is complete once the files are open
+	return rec.wait()
+}
+
+// requestRecording starts the recording, or asks the peers to consent to it
+func requestRecording(roomUUID, hostKey string) (*recording, error) {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	if !r.isHost(hostKey) {
+		return nil, ErrNotHost
+	}
+
+	if r.recording != nil || r.recordingRequested {
+		return nil, ErrAlreadyRecording
+	}
+
+	// Every recording asks anew, consent to an earlier one doesn't carry over
+	r.recordingRequested = true
+	for _, p := range peerConnections[roomUUID] {
+		p.recordingConsent = false
+	}
+	requestConsent(roomUUID, r, nil)
+
+	if !r.consentGiven(roomUUID) {
+		return nil, ErrAwaitingConsent
+	}
+
+	return r.startRecording(roomUUID), nil
+}
+
+// startRecording records the tracks of the room, the directory and the files
+// are created once listLock is released. listLock must be held
+func (r *room) startRecording(roomUUID string) *recording {
+	startedAt := time.Now().UTC()
+	rec := &recording{
+		dir: filepath.Join(recordingDir, roomUUID, startedAt.Format("20060102T150405.000Z")),
+		manifest: RecordingManifest{
+			RoomID:    roomUUID,
+			StartedAt: startedAt,
+			Tracks:    []RecordedTrack{},
+		},
+	}
+	r.recording = rec
+
+	rec.do(func() {
+		if err := os.MkdirAll(rec.dir, 0o755); err != nil {
+			rec.fail(err)
+			return
+		}
+		rec.fail(rec.save())
+	})
+
+	for _, t := range trackLocals[roomUUID] {
+		rec.start(t)
+	}
+
+	return rec
+}
+
+// StopRecording closes the files of the room recording and returns its final
+// manifest, a recording still waiting for consent is cancelled
+func StopRecording(roomUUID, hostKey string) (RecordingManifest, error) {
+	rec, err := endRecording(roomUUID, hostKey)
+	if err != nil {
+		return RecordingManifest{}, err
+	}
+
+	// Still waiting for consent, there is nothing to finish
+	if rec == nil {
+		return RecordingManifest{RoomID: roomUUID, Tracks: []RecordedTrack{}}, nil
+	}
+
+	return rec.wait()
+}
+
+// endRecording stops the recording, or cancels the request for consent in
+// which case there is no recording to return
+func endRecording(roomUUID, hostKey string) (*recording, error) {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	if !r.isHost(hostKey) {
+		return nil, ErrNotHost
+	}
+
+	if r.recording == nil && !r.recordingRequested {
+		return nil, ErrNotRecording
+	}
+	r.recordingRequested = false
+
+	if r.recording == nil {
+		return nil, nil
+	}
+
+	return r.finishRecording(roomUUID), nil
+}
+
+// finishRecording stops the recording in progress, the files are closed
+// once listLock is released. listLock must be held
+func (r *room) finishRecording(roomUUID string) *recording {
+	for _, t := range trackLocals[roomUUID] {
+		t.stopRecording()
+	}
+
+	rec := r.recording
+	r.recording = nil
+
+	rec.mu.Lock()
+	stoppedAt := time.Now().UTC()
+	rec.manifest.StoppedAt = &stoppedAt
+	rec.mu.Unlock()
+
+	rec.do(func() { rec.fail(rec.save()) })
+
+	return rec
+}
+
+// do queues file work of the recording, done in the order it was queued
+func (r *recording) do(work func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending = append(r.pending, work)
+	if !r.working {
+		r.working = true
+		go r.work()
+	}
+}
+
+func (r *recording) work() {
+	for {
+		r.mu.Lock()
+		if len(r.pending) == 0 {
+			r.working = false
+			r.mu.Unlock()
+			return
+		}
+		work := r.pending[0]
+		r.pending = r.pending[1:]
+		r.mu.Unlock()
+
+		work()
+	}
+}
+
+// wait returns the manifest once the file work queued so far is done, with
+// the first error met. listLock must not be held
+func (r *recording) wait() (RecordingManifest, error) {
+	done := make(chan struct{})
+	r.do(func() { close(done) })
+	<-done
+
+	r.mu.Lock()
+	err := r.err
+	r.mu.Unlock()
+
+	return r.snapshot(), err
+}
+
+// fail logs an error of the recording, keeping the first one for wait
+func (r *recording) fail(err error) {
+	if err == nil {
+		return
+	}
+	log.Println(err)
+
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.mu.Unlock()
+}
+
+// start records the track from now on, its file is opened once listLock is
+// released. listLock must be held
+func (r *recording) start(t *localTrack) {
+	recorder := &trackRecorder{
+		payloadType: uint8(t.payloadType),
+		recording:   r,
+		index:       -1,
+	}
+
+	t.mu.Lock()
+	t.recorder = recorder
+	t.mu.Unlock()
+
+	r.do(func() { r.open(t, recorder) })
+}
+
+// open creates the file of a track and adds it to the manifest. Tracks in a
+// codec no writer supports are left out
+func (r *recording) open(t *localTrack, recorder *trackRecorder) {
+	r.mu.Lock()
+	index := len(r.manifest.Tracks)
+	r.mu.Unlock()
+
+	writer, file, err := newMediaWriter(t.codec, filepath.Join(r.dir, fmt.Sprintf("%s-%d", t.peerID, index)))
+	if err != nil {
+		log.Printf("not recording track %s: %v", t.id, err)
+		return
+	}
+
+	r.mu.Lock()
+	r.manifest.Tracks = append(r.manifest.Tracks, RecordedTrack{
+		PeerID:    t.peerID,
+		TrackID:   t.id,
+		Kind:      t.Kind().String(),
+		Codec:     t.codec.MimeType,
+		File:      file,
+		StartedAt: time.Now().UTC(),
+	})
+	r.mu.Unlock()
+
+	recorder.mu.Lock()
+	recorder.writer, recorder.index = writer, index
+	recorder.mu.Unlock()
+
+	if err := r.save(); err != nil {
+		log.Println(err)
+	}
+
+	// Video files must start on a keyframe to be decodable
+	if t.Kind() == webrtc.RTPCodecTypeVideo {
+		t.keyFrame()
+	}
+}
+
+// stopped marks the track file of the manifest as complete
+func (r *recording) stopped(index int) {
+	r.mu.Lock()
+	stoppedAt := time.Now().UTC()
+	r.manifest.Tracks[index].StoppedAt = &stoppedAt
+	r.mu.Unlock()
+
+	if err := r.save(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *recording) snapshot() RecordingManifest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	manifest := r.manifest
+	manifest.Tracks = append([]RecordedTrack{}, r.manifest.Tracks...)
+	return manifest
+}
+
+// save rewrites manifest.json, through a rename so readers never see it half written
+func (r *recording) save() error {
+	data, err := json.MarshalIndent(r.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(r.dir, "manifest.json")
+	if err = os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// newMediaWriter opens the container matching the codec, the extension is added to base
+func newMediaWriter(codec webrtc.RTPCodecCapability, base string) (media.Writer, string, error) {
+	switch strings.ToLower(codec.MimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		w, err := ivfwriter.New(base+".ivf", ivfwriter.WithCodec(webrtc.MimeTypeVP8))
+		return w, base + ".ivf", err
+	case strings.ToLower(webrtc.MimeTypeAV1):
+		w, err := ivfwriter.New(base+".ivf", ivfwriter.WithCodec(webrtc.MimeTypeAV1))
+		return w, base + ".ivf", err
+	case strings.ToLower(webrtc.MimeTypeH264):
+		w, err := h264writer.New(base + ".h264")
+		return w, base + ".h264", err
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		w, err := oggwriter.New(base+".ogg", codec.ClockRate, codec.Channels)
+		return w, base + ".ogg", err
+	default:
+		return nil, "", fmt.Errorf("%w: %s", ErrCodecNotRecordable, codec.MimeType)
+	}
+}
+
+// writeRTP records a packet of the primary codec, FEC and other payloads are skipped
+func (r *trackRecorder) writeRTP(p *rtp.Packet) {
+	if p.PayloadType != r.payloadType {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.writer == nil {
+		return
+	}
+
+	if err := r.writer.WriteRTP(p); err != nil {
+		log.Println(err)
+	}
+}
+
+// close closes the file of the track, if it could be opened
+func (r *trackRecorder) close() {
+	r.mu.Lock()
+	writer := r.writer
+	r.writer = nil
+	r.mu.Unlock()
+
+	if writer == nil {
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Println(err)
+	}
+	r.recording.stopped(r.index)
+}
+
+// stopRecording stops recording the track, if it is being recorded. The file
+// is closed once listLock is released
+func (t *localTrack) stopRecording() {
+	t.mu.Lock()
+	recorder := t.recorder
+	t.recorder = nil
+	t.mu.Unlock()
+
+	if recorder != nil {
+		recorder.recording.do(recorder.close)
+	}
+}
@@ -0,0 +1,127 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/pion/webrtc/v3"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testVP8  = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+	testOpus = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+)
+
+// useRecordingDir records to a directory removed after the test
+func useRecordingDir(t *testing.T) string {
+	t.Helper()
+
+	previous := recordingDir
+	recordingDir = t.TempDir()
+	t.Cleanup(func() { recordingDir = previous })
+	return recordingDir
+}
+
+// readManifest reads the manifest.json of the recording on disk
+func readManifest(t *testing.T, rec *recording) RecordingManifest {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(rec.dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := RecordingManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestRecordingManifestOfTwoTracks(t *testing.T) {
+	useRecordingDir(t)
+
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+	addTestTrack(t, roomUUID, "alice", "mic", testOpus)
+
+	started, err := StartRecording(roomUUID, hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(started.Tracks) != 2 {
+		t.Fatalf("got %d tracks in the manifest, want 2", len(started.Tracks))
+	}
+
+	listLock.RLock()
+	rec := conferences[roomUUID].recording
+	listLock.RUnlock()
+
+	if onDisk := readManifest(t, rec); len(onDisk.Tracks) != 2 {
+		t.Fatalf("got %d tracks in manifest.json, want 2", len(onDisk.Tracks))
+	}
+
+	kinds := map[string]bool{}
+	for _, track := range started.Tracks {
+		if _, err := os.Stat(track.File); err != nil {
+			t.Errorf("track %s: %v", track.TrackID, err)
+		}
+		kinds[track.Kind] = true
+	}
+	if !kinds["audio"] || !kinds["video"] {
+		t.Errorf("got kinds %v, want audio and video", kinds)
+	}
+
+	stopped, err := StopRecording(roomUUID, hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	onDisk := readManifest(t, rec)
+	if stopped.StoppedAt == nil || onDisk.StoppedAt == nil {
+		t.Fatal("the stopped recording has no stoppedAt")
+	}
+	for _, track := range onDisk.Tracks {
+		if track.StoppedAt == nil {
+			t.Errorf("track %s has no stoppedAt in manifest.json", track.TrackID)
+		}
+	}
+}
+
+func TestRecordingFilesOpenOutsideListLock(t *testing.T) {
+	useRecordingDir(t)
+
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StartRecording(roomUUID, hostKey); err != nil {
+		t.Fatal(err)
+	}
+
+	listLock.RLock()
+	rec := conferences[roomUUID].recording
+	listLock.RUnlock()
+
+	// Hold up the file work, publishing must not wait for it
+	release := make(chan struct{})
+	rec.do(func() { <-release })
+
+	addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+	if tracks := len(rec.snapshot().Tracks); tracks != 0 {
+		t.Fatalf("got %d tracks before the file work ran, want 0", tracks)
+	}
+
+	close(release)
+	manifest, err := rec.wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Tracks) != 1 {
+		t.Fatalf("got %d tracks once the file work ran, want 1", len(manifest.Tracks))
+	}
+}
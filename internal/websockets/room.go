@@ -0,0 +1,179 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/webrtc/v3"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	ErrNoMediaAllowed     = errors.New("room must allow audio or video")
+	ErrUnknownTemplate    = errors.New("unknown room template")
+	ErrInvalidMaxDuration = errors.New("maxDurationSeconds must not be negative")
+	ErrInvalidICEServer   = errors.New("ICE server URLs must start with stun:, turn: or turns:")
+	ErrICEServerForbidden = errors.New("ICE server is not in ICE_SERVERS or ICE_SERVERS_ALLOWED")
+	ErrInvalidLimit       = errors.New("maxParticipants and maxPublishers must not be negative")
+)
+
+// roomTemplates are named configs rooms can be created from, read once at startup
+var roomTemplates = map[string]RoomConfig{}
+
+func init() {
+	if path := config.String("ROOM_TEMPLATES_FILE", ""); path != "" {
+		templates, err := loadRoomTemplates(path)
+		if err != nil {
+			log.Fatalf("ROOM_TEMPLATES_FILE: %v", err)
+		}
+		roomTemplates = templates
+	}
+}
+
+// RoomConfig holds the options a room is created with
+type RoomConfig struct {
+	// Name is shown to the participants, it can be changed with RenameRoom.
+	// No two rooms of a tenant share a name
+	Name string `json:"name,omitempty"`
+
+	AllowAudio bool `json:"allowAudio"`
+	AllowVideo bool `json:"allowVideo"`
+
+	// ActiveSpeakerOnly forwards only the video of the current speaker, audio is always forwarded
+	ActiveSpeakerOnly bool `json:"activeSpeakerOnly"`
+
+	// MaxDurationSeconds ends the room that long after its creation, 0 keeps it open
+	MaxDurationSeconds int `json:"maxDurationSeconds,omitempty"`
+
+	// ICEServers replace the global ICE_SERVERS for the room, e.g. a customer's
+	// own TURN. They must be among ICE_SERVERS or ICE_SERVERS_ALLOWED
+	ICEServers []webrtc.ICEServer `json:"iceServers,omitempty"`
+
+	// MaxParticipants replaces MAX_PARTICIPANTS for the room, 0 keeps it
+	MaxParticipants int `json:"maxParticipants,omitempty"`
+
+	// MaxPublishers bounds the peers publishing media, e.g. the presenters of
+	// a webinar, the others may still join and watch. 0 means no limit
+	MaxPublishers int `json:"maxPublishers,omitempty"`
+
+	// PreferP2P hints the peers of a 1:1 call to connect directly, see updateP2P
+	PreferP2P bool `json:"preferP2P,omitempty"`
+}
+
+// DefaultRoomConfig returns the options used when none are given
+func DefaultRoomConfig() RoomConfig {
+	return RoomConfig{
+		AllowAudio: true,
+		AllowVideo: true,
+	}
+}
+
+// Validate reports the first invalid option
+func (c RoomConfig) Validate() error {
+	if !c.AllowAudio && !c.AllowVideo {
+		return ErrNoMediaAllowed
+	}
+
+	if c.MaxDurationSeconds < 0 {
+		return ErrInvalidMaxDuration
+	}
+
+	if c.MaxParticipants < 0 || c.MaxPublishers < 0 {
+		return ErrInvalidLimit
+	}
+
+	for _, server := range c.ICEServers {
+		if len(server.URLs) == 0 {
+			return ErrInvalidICEServer
+		}
+
+		for _, url := range server.URLs {
+			if !strings.HasPrefix(url, "stun:") && !strings.HasPrefix(url, "turn:") && !strings.HasPrefix(url, "turns:") {
+				return fmt.Errorf("%w: %q", ErrInvalidICEServer, url)
+			}
+
+			// Clients mustn't point the peers at relays of their choosing
+			if !iceServerAllowed(url) {
+				return fmt.Errorf("%w: %q", ErrICEServerForbidden, url)
+			}
+		}
+	}
+
+	return nil
+}
+
+// NormalizeRoomConfig validates the config and returns it the way a room
+// created with it would keep it
+func NormalizeRoomConfig(c RoomConfig) (RoomConfig, error) {
+	if err := c.Validate(); err != nil {
+		return c, err
+	}
+
+	c.Name = displayName(c.Name)
+
+	return c, nil
+}
+
+// allows reports whether tracks of the given kind may be published in the room
+func (c RoomConfig) allows(kind webrtc.RTPCodecType) bool {
+	switch kind {
+	case webrtc.RTPCodecTypeAudio:
+		return c.AllowAudio
+	case webrtc.RTPCodecTypeVideo:
+		return c.AllowVideo
+	default:
+		return false
+	}
+}
+
+// roomICEServers returns the ICE servers peers of the room use
+func (c RoomConfig) roomICEServers() []webrtc.ICEServer {
+	if len(c.ICEServers) > 0 {
+		return c.ICEServers
+	}
+
+	return iceServers
+}
+
+// RoomTemplate returns the config of a named template
+func RoomTemplate(name string) (RoomConfig, error) {
+	template, ok := roomTemplates[name]
+	if !ok {
+		return RoomConfig{}, fmt.Errorf("%w: %q", ErrUnknownTemplate, name)
+	}
+
+	return template, nil
+}
+
+// loadRoomTemplates reads a JSON object of template name to room config.
+// Options a template leaves out keep their defaults
+func loadRoomTemplates(path string) (map[string]RoomConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]json.RawMessage{}
+	if err = json.Unmarshal(raw, &entries); err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]RoomConfig, len(entries))
+	for name, entry := range entries {
+		template := DefaultRoomConfig()
+		if err = json.Unmarshal(entry, &template); err != nil {
+			return nil, fmt.Errorf("template %q: %w", name, err)
+		}
+
+		if err = template.Validate(); err != nil {
+			return nil, fmt.Errorf("template %q: %w", name, err)
+		}
+
+		templates[name] = template
+	}
+
+	return templates, nil
+}
@@ -0,0 +1,120 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/pion/webrtc/v3"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestICEServerAddress(t *testing.T) {
+	tests := map[string][2]string{
+		"stun:stun.l.google.com:19302":             {"stun.l.google.com", "19302"},
+		"turn:TURN.example.com:3478?transport=tcp": {"turn.example.com", "3478"},
+		"turns:turn.example.com":                   {"turn.example.com", ""},
+		"turn:[2001:db8::1]:3478":                  {"2001:db8::1", "3478"},
+		"turn:turn.example.com?transport=udp":      {"turn.example.com", ""},
+		"stun:stun.example.com:3478?transport=udp": {"stun.example.com", "3478"},
+		"turns:turn.example.com:443?transport=tcp": {"turn.example.com", "443"},
+	}
+
+	for url, want := range tests {
+		if host, port := iceServerAddress(url); host != want[0] || port != want[1] {
+			t.Errorf("iceServerAddress(%q) = %q, %q, want %q, %q", url, host, port, want[0], want[1])
+		}
+	}
+}
+
+func TestRoomICEServersAllowList(t *testing.T) {
+	previous := allowedICEServers
+	allowedICEServers = map[string]bool{}
+	t.Cleanup(func() { allowedICEServers = previous })
+
+	// As if ICE_SERVERS held the first and ICE_SERVERS_ALLOWED the others
+	allowICEServer("stun.l.google.com", "19302")
+	allowICEServer("turn.customer.com", "")
+	allowICEServer("relay.customer.com", "3478")
+
+	tests := []struct {
+		name string
+		url  string
+		want error
+	}{
+		{name: "global server", url: "stun:stun.l.google.com:19302"},
+		{name: "allowed host on any port", url: "turns:turn.customer.com:5349?transport=tcp"},
+		{name: "allowed host and port", url: "turn:relay.customer.com:3478"},
+		{name: "allowed host on another port", url: "turn:relay.customer.com:3479", want: ErrICEServerForbidden},
+		{name: "global host on another port", url: "stun:stun.l.google.com:3478", want: ErrICEServerForbidden},
+		{name: "unknown host", url: "turn:attacker.example:3478", want: ErrICEServerForbidden},
+		{name: "not an ICE URL", url: "http://turn.customer.com", want: ErrInvalidICEServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultRoomConfig()
+			c.ICEServers = []webrtc.ICEServer{{URLs: []string{tt.url}}}
+
+			if err := c.Validate(); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoomTemplates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	raw := `{
+		"webinar": {"maxPublishers": 2, "activeSpeakerOnly": true},
+		"podcast": {"allowVideo": false}
+	}`
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := loadRoomTemplates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := roomTemplates
+	roomTemplates = templates
+	t.Cleanup(func() { roomTemplates = previous })
+
+	webinar, err := RoomTemplate("webinar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roomUUID, _, err := AddRoomUUID("", webinar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listLock.RLock()
+	got := conferences[roomUUID].config
+	listLock.RUnlock()
+
+	// The options the template leaves out keep their defaults
+	if got.MaxPublishers != 2 || !got.ActiveSpeakerOnly || !got.AllowAudio || !got.AllowVideo {
+		t.Fatalf("got %+v, want the webinar template on top of the defaults", got)
+	}
+
+	if podcast, err := RoomTemplate("podcast"); err != nil || podcast.AllowVideo || !podcast.AllowAudio {
+		t.Fatalf("got %+v, %v, want an audio only template", podcast, err)
+	}
+
+	if _, err := RoomTemplate("concert"); !errors.Is(err, ErrUnknownTemplate) {
+		t.Fatalf("got %v, want %v", err, ErrUnknownTemplate)
+	}
+}
+
+func TestLoadRoomTemplatesRejectsInvalidTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	if err := os.WriteFile(path, []byte(`{"silent": {"allowAudio": false, "allowVideo": false}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadRoomTemplates(path); !errors.Is(err, ErrNoMediaAllowed) {
+		t.Fatalf("got %v, want %v", err, ErrNoMediaAllowed)
+	}
+}
@@ -0,0 +1,80 @@
+package websockets
+
+import (
+	"crypto/rand"
+	"errors"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/google/uuid"
+	"log"
+	"math/big"
+	"strings"
+)
+
+// maxRoomIDAttempts bounds the retries when a generated ID is already taken
+const maxRoomIDAttempts = 10
+
+// shortCodeAlphabet leaves out characters that are easily confused
+const shortCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+var ErrRoomIDExhausted = errors.New("no free room ID found")
+
+// IDGenerator produces the IDs of new rooms
+type IDGenerator interface {
+	NewID() string
+}
+
+// UUIDGenerator produces random UUIDs, collisions are not a concern
+type UUIDGenerator struct{}
+
+func (UUIDGenerator) NewID() string {
+	return uuid.NewString()
+}
+
+// ShortCodeGenerator produces short random codes that read well in URLs.
+// The space is small enough that AddRoomUUID checks them for collisions
+type ShortCodeGenerator struct {
+	Length int
+}
+
+func (g ShortCodeGenerator) NewID() string {
+	var code strings.Builder
+	size := big.NewInt(int64(len(shortCodeAlphabet)))
+
+	for i := 0; i < g.Length; i++ {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			panic(err)
+		}
+		code.WriteByte(shortCodeAlphabet[n.Int64()])
+	}
+
+	return code.String()
+}
+
+// roomIDs generates the IDs of rooms created through AddRoomUUID
+var roomIDs IDGenerator = UUIDGenerator{}
+
+func init() {
+	switch style := strings.ToLower(config.String("ROOM_ID_STYLE", "uuid")); style {
+	case "uuid":
+	case "short":
+		length := config.Int("ROOM_ID_LENGTH", 6)
+		if length < 4 {
+			log.Fatal("ROOM_ID_LENGTH must be at least 4")
+		}
+		roomIDs = ShortCodeGenerator{Length: length}
+	default:
+		log.Fatalf("ROOM_ID_STYLE must be uuid or short, got %q", style)
+	}
+}
+
+// newRoomID returns an ID no room uses yet. listLock must be held
+func newRoomID(generator IDGenerator) (string, error) {
+	for i := 0; i < maxRoomIDAttempts; i++ {
+		if id := generator.NewID(); conferences[id] == nil {
+			return id, nil
+		}
+	}
+
+	return "", ErrRoomIDExhausted
+}
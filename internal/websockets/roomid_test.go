@@ -0,0 +1,91 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"strings"
+	"testing"
+)
+
+// sequenceGenerator hands out the given IDs in order, then the last one forever
+type sequenceGenerator struct {
+	ids   []string
+	calls int
+}
+
+func (g *sequenceGenerator) NewID() string {
+	id := g.ids[min(g.calls, len(g.ids)-1)]
+	g.calls++
+	return id
+}
+
+func TestUUIDGenerator(t *testing.T) {
+	id := UUIDGenerator{}.NewID()
+	if _, err := uuid.Parse(id); err != nil {
+		t.Fatalf("got %q: %v", id, err)
+	}
+}
+
+func TestShortCodeGenerator(t *testing.T) {
+	g := ShortCodeGenerator{Length: 6}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code := g.NewID()
+		if len(code) != 6 {
+			t.Fatalf("got %q, want 6 characters", code)
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(shortCodeAlphabet, c) {
+				t.Fatalf("got %q, %q is not in the alphabet", code, c)
+			}
+		}
+		seen[code] = true
+	}
+
+	// 32^6 codes make a repeat among 100 very unlikely
+	if len(seen) < 99 {
+		t.Fatalf("got %d distinct codes out of 100", len(seen))
+	}
+}
+
+func TestNewRoomIDRetriesCollisions(t *testing.T) {
+	taken, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("free after a collision", func(t *testing.T) {
+		g := &sequenceGenerator{ids: []string{taken, taken, "free-room-id"}}
+
+		listLock.RLock()
+		id, err := newRoomID(g)
+		listLock.RUnlock()
+
+		if err != nil || id != "free-room-id" || g.calls != 3 {
+			t.Fatalf("got %q, %v after %d attempts, want free-room-id after 3", id, err, g.calls)
+		}
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		g := &sequenceGenerator{ids: []string{taken}}
+
+		listLock.RLock()
+		_, err := newRoomID(g)
+		listLock.RUnlock()
+
+		if !errors.Is(err, ErrRoomIDExhausted) || g.calls != maxRoomIDAttempts {
+			t.Fatalf("got %v after %d attempts, want %v after %d", err, g.calls, ErrRoomIDExhausted, maxRoomIDAttempts)
+		}
+	})
+
+	t.Run("through AddRoomUUID", func(t *testing.T) {
+		previous := roomIDs
+		roomIDs = &sequenceGenerator{ids: []string{taken}}
+		t.Cleanup(func() { roomIDs = previous })
+
+		if _, _, err := AddRoomUUID("", DefaultRoomConfig()); !errors.Is(err, ErrRoomIDExhausted) {
+			t.Fatalf("got %v, want %v", err, ErrRoomIDExhausted)
+		}
+	})
+}
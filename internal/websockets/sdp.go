@@ -0,0 +1,46 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// maxIncomingBitrate caps, in kbps, the video each client publishes. 0 disables the cap
+var maxIncomingBitrate uint64
+
+func init() {
+	maxIncomingBitrate = uint64(config.Int("MAX_INCOMING_BITRATE", 0))
+}
+
+// withBandwidthLimit returns the description as sent to the client: the
+// local description stays untouched since pion refuses a modified one, only
+// the copy on the wire gets b=AS and b=TIAS lines on its video sections
+func withBandwidthLimit(desc webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if maxIncomingBitrate == 0 {
+		return desc, nil
+	}
+
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(desc.SDP)); err != nil {
+		return desc, err
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "video" {
+			continue
+		}
+
+		media.Bandwidth = []sdp.Bandwidth{
+			{Type: "AS", Bandwidth: maxIncomingBitrate},
+			{Type: "TIAS", Bandwidth: maxIncomingBitrate * 1000},
+		}
+	}
+
+	raw, err := parsed.Marshal()
+	if err != nil {
+		return desc, err
+	}
+
+	return webrtc.SessionDescription{Type: desc.Type, SDP: string(raw)}, nil
+}
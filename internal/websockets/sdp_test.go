@@ -0,0 +1,58 @@
+package websockets
+
+import "testing"
+
+func TestOfferBandwidthLimit(t *testing.T) {
+	previous := maxIncomingBitrate
+	maxIncomingBitrate = 500
+	t.Cleanup(func() { maxIncomingBitrate = previous })
+
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+	addTestTrack(t, roomUUID, "alice", "microphone", testOpus)
+
+	offer := parseSDP(t, nextOffer(t, dialRoom(t, srv, roomUUID)))
+
+	video := 0
+	for _, media := range offer.MediaDescriptions {
+		bandwidths := make(map[string]uint64)
+		for _, b := range media.Bandwidth {
+			bandwidths[b.Type] = b.Bandwidth
+		}
+
+		if media.MediaName.Media != "video" {
+			if len(bandwidths) != 0 {
+				t.Fatalf("got %v on the %s section, want no bandwidth line", bandwidths, media.MediaName.Media)
+			}
+			continue
+		}
+
+		video++
+		if bandwidths["AS"] != 500 || bandwidths["TIAS"] != 500000 {
+			t.Fatalf("got %v, want AS 500 and TIAS 500000", bandwidths)
+		}
+	}
+	if video == 0 {
+		t.Fatal("the offer has no video section")
+	}
+}
+
+func TestWithBandwidthLimitDisabled(t *testing.T) {
+	previous := maxIncomingBitrate
+	maxIncomingBitrate = 0
+	t.Cleanup(func() { maxIncomingBitrate = previous })
+
+	answer := serverOffer(t, newTestPeer(t))
+
+	sent, err := withBandwidthLimit(answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != answer {
+		t.Fatal("the description was changed with no limit configured")
+	}
+}
@@ -0,0 +1,74 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"sync"
+	"time"
+)
+
+var (
+	// signalDebounce is how long renegotiation requests are collected before a room is synced
+	signalDebounce time.Duration
+
+	signalLock     sync.Mutex
+	pendingSignals = make(map[string]bool)
+)
+
+func init() {
+	signalDebounce = config.Duration("SIGNAL_DEBOUNCE", 100*time.Millisecond)
+}
+
+// requestSignal schedules a renegotiation of the room. Requests made while
+// one is pending are merged into it, so a burst of joins or tracks costs a
+// single pass. The pending mark is cleared before the pass starts, changes
+// made during it schedule the next one
+func requestSignal(roomUUID string) {
+	signalLock.Lock()
+	defer signalLock.Unlock()
+
+	if pendingSignals[roomUUID] {
+		return
+	}
+	pendingSignals[roomUUID] = true
+
+	time.AfterFunc(signalDebounce, func() {
+		signalLock.Lock()
+		delete(pendingSignals, roomUUID)
+		signalLock.Unlock()
+
+		signalPeerConnections(roomUUID)
+	})
+}
+
+// Renegotiate sends a fresh offer to a single peer of the room, for a peer
+// whose tracks got out of sync. Only the host may do it
+func Renegotiate(roomUUID, hostKey, peerID string) error {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !r.isHost(hostKey) {
+		return ErrNotHost
+	}
+
+	p := findPeer(roomUUID, peerID)
+	if p == nil {
+		return ErrPeerNotFound
+	}
+
+	// Like signalPeerConnections, retry a few times and then leave it to a
+	// room sync rather than hold the lock
+	for attempt := 0; attempt < 25; attempt++ {
+		if !syncPeer(roomUUID, p) {
+			return nil
+		}
+	}
+
+	requestSignal(roomUUID)
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/pion/webrtc/v3"
+	"testing"
+	"time"
+)
+
+// signalPending reports whether a renegotiation of the room is scheduled
+func signalPending(roomUUID string) bool {
+	signalLock.Lock()
+	defer signalLock.Unlock()
+
+	return pendingSignals[roomUUID]
+}
+
+func TestRequestSignalCoalesces(t *testing.T) {
+	const roomUUID = "coalesce-room"
+
+	for i := 0; i < 5; i++ {
+		requestSignal(roomUUID)
+	}
+	if !signalPending(roomUUID) {
+		t.Fatal("no renegotiation is scheduled")
+	}
+
+	// The burst is served by one pass, which clears the mark
+	deadline := time.Now().Add(signalDebounce + time.Second)
+	for signalPending(roomUUID) {
+		if time.Now().After(deadline) {
+			t.Fatal("the scheduled renegotiation never ran")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A request made after the pass schedules the next one
+	requestSignal(roomUUID)
+	if !signalPending(roomUUID) {
+		t.Fatal("a request after the pass wasn't scheduled")
+	}
+}
+
+func TestSyncPeerSkipsOwnTracks(t *testing.T) {
+	type published struct{ peerID, trackID string }
+
+	tests := []struct {
+		name   string
+		tracks []published
+	}{
+		{
+			name:   "track ID equal to the publisher's peer ID",
+			tracks: []published{{"alice", "alice"}, {"bob", "camera"}},
+		},
+		{
+			name:   "track ID equal to the other peer's ID",
+			tracks: []published{{"alice", "bob"}, {"bob", "alice"}},
+		},
+		{
+			name:   "same track ID for both publishers",
+			tracks: []published{{"alice", "camera"}, {"bob", "camera"}},
+		},
+		{
+			name:   "several tracks per publisher",
+			tracks: []published{{"alice", "camera"}, {"alice", "mic"}, {"alice", "screen"}, {"bob", "camera"}, {"bob", "mic"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]bool{}
+			for _, track := range tt.tracks {
+				codec := testVP8
+				if track.trackID == "mic" {
+					codec = testOpus
+				}
+				added := addTestTrack(t, roomUUID, track.peerID, track.trackID, codec)
+				if track.peerID != "alice" {
+					want[added.ID()] = true
+				}
+			}
+
+			alice := newTestPeer(t)
+			alice.id = "alice"
+			alice.websocket = &fakeConn{}
+
+			listLock.Lock()
+			syncPeer(roomUUID, alice)
+			alice.answered()
+			listLock.Unlock()
+
+			got := map[string]bool{}
+			for _, sender := range alice.peerConnection.GetSenders() {
+				if sender.Track() != nil {
+					got[sender.Track().ID()] = true
+				}
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got tracks %v, want %v", got, want)
+			}
+			for trackID := range want {
+				if !got[trackID] {
+					t.Fatalf("got tracks %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenegotiate(t *testing.T) {
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, bob := newTestPeer(t), newTestPeer(t)
+	alice.id, bob.id = "alice", "bob"
+	aliceConn, bobConn := &fakeConn{}, &fakeConn{}
+	alice.websocket, bob.websocket = aliceConn, bobConn
+
+	listLock.Lock()
+	peerConnections[roomUUID] = append(peerConnections[roomUUID], alice, bob)
+	listLock.Unlock()
+
+	addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+
+	if err := Renegotiate(roomUUID, hostKey, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	listLock.Lock()
+	bob.answered()
+	listLock.Unlock()
+
+	message, ok := bobConn.message("offer")
+	if !ok {
+		t.Fatal("bob got no offer")
+	}
+	offer := webrtc.SessionDescription{}
+	if err := json.Unmarshal([]byte(message.Data), &offer); err != nil {
+		t.Fatal(err)
+	}
+	if kinds := sendingKinds(t, offer); kinds["video"] != 1 {
+		t.Fatalf("bob's offer sends %v, want alice's camera", kinds)
+	}
+	if events := aliceConn.events(); len(events) != 0 {
+		t.Fatalf("alice got %v, want nothing", events)
+	}
+	if signalPending(roomUUID) {
+		t.Fatal("renegotiating one peer scheduled a room sync")
+	}
+
+	errorTests := []struct {
+		name     string
+		roomUUID string
+		hostKey  string
+		peerID   string
+		want     error
+	}{
+		{name: "missing room", roomUUID: "missing-room", hostKey: hostKey, peerID: "bob", want: ErrRoomNotFound},
+		{name: "not the host", roomUUID: roomUUID, hostKey: "not the host", peerID: "bob", want: ErrNotHost},
+		{name: "missing peer", roomUUID: roomUUID, hostKey: hostKey, peerID: "carol", want: ErrPeerNotFound},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Renegotiate(tt.roomUUID, tt.hostKey, tt.peerID); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package websockets
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrRoomExists      = errors.New("room already exists")
+	ErrInvalidSnapshot = errors.New("snapshot needs a uuid and a hostKey")
+)
+
+// RoomSnapshot is the shell of a room, enough for a standby server to take
+// it over: its config, state and who was connected. Media is not part of it,
+// clients reconnect to the restored room and publish again
+type RoomSnapshot struct {
+	UUID      string     `json:"uuid"`
+	HostKey   string     `json:"hostKey"`
+	Tenant    string     `json:"tenant,omitempty"`
+	Config    RoomConfig `json:"config"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Locked    bool       `json:"locked"`
+	Expired   bool       `json:"expired"`
+	TakenAt   time.Time  `json:"takenAt"`
+
+	// Participants is the roster at the time of the snapshot. Peers get new
+	// IDs when they reconnect, so restoring doesn't bring them back
+	Participants []Participant `json:"participants"`
+}
+
+// SnapshotRoom exports the shell of the room. Only the host may do it, as the
+// snapshot carries the host key
+func SnapshotRoom(roomUUID, hostKey string) (RoomSnapshot, error) {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return RoomSnapshot{}, ErrRoomNotFound
+	}
+
+	if !r.isHost(hostKey) {
+		return RoomSnapshot{}, ErrNotHost
+	}
+
+	return RoomSnapshot{
+		UUID:         roomUUID,
+		HostKey:      r.hostKey,
+		Tenant:       r.tenant,
+		Config:       r.config,
+		CreatedAt:    r.createdAt,
+		Locked:       r.locked,
+		Expired:      r.expired,
+		TakenAt:      time.Now(),
+		Participants: participants(roomUUID),
+	}, nil
+}
+
+// RestoreRoom recreates the room of a snapshot under the same UUID and host
+// key, so the links handed out before keep working. The room keeps its
+// creation time, a maximum duration still counts from it
+func RestoreRoom(s RoomSnapshot) error {
+	_, err := restoreRoom(s)
+	return err
+}
+
+func restoreRoom(s RoomSnapshot) (*room, error) {
+	if s.UUID == "" || s.HostKey == "" {
+		return nil, ErrInvalidSnapshot
+	}
+
+	if s.Expired {
+		return nil, ErrRoomExpired
+	}
+
+	config, err := NormalizeRoomConfig(s.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	r := newRoom(config)
+	r.hostKey = s.HostKey
+	r.tenant = s.Tenant
+	r.locked = s.Locked
+	if !s.CreatedAt.IsZero() {
+		r.createdAt = s.CreatedAt
+	}
+
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	if _, ok := conferences[s.UUID]; ok {
+		return nil, ErrRoomExists
+	}
+
+	if roomsFull() && !(evictStaleRooms && evictStaleRoom()) {
+		return nil, ErrTooManyRooms
+	}
+
+	registerRoom(s.UUID, r)
+
+	return r, nil
+}
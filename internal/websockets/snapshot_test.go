@@ -0,0 +1,99 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoom(t *testing.T) {
+	config := DefaultRoomConfig()
+	config.Name = "Planning"
+	config.AllowVideo = false
+	roomUUID, hostKey, err := AddRoomUUID("acme", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRoomLocked(roomUUID, hostKey, true); err != nil {
+		t.Fatal(err)
+	}
+	addFakePeer(t, roomUUID, "alice")
+
+	if _, err := SnapshotRoom(roomUUID, "not the host"); !errors.Is(err, ErrNotHost) {
+		t.Fatalf("got %v without the host key, want %v", err, ErrNotHost)
+	}
+	if _, err := SnapshotRoom("missing-room", hostKey); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("got %v for a missing room, want %v", err, ErrRoomNotFound)
+	}
+
+	snapshot, err := SnapshotRoom(roomUUID, hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.UUID != roomUUID || snapshot.HostKey != hostKey || snapshot.Tenant != "acme" || !snapshot.Locked {
+		t.Fatalf("got snapshot %+v, want the locked room of acme with its host key", snapshot)
+	}
+	if !reflect.DeepEqual(snapshot.Config, config) {
+		t.Fatalf("got config %+v, want %+v", snapshot.Config, config)
+	}
+	if len(snapshot.Participants) != 1 || snapshot.Participants[0].ID != "alice" {
+		t.Fatalf("got participants %+v, want alice", snapshot.Participants)
+	}
+}
+
+func TestRestoreRoom(t *testing.T) {
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRoomLocked(roomUUID, hostKey, true); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := SnapshotRoom(roomUUID, hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The room is still here, as on a server that didn't fail
+	if err := RestoreRoom(snapshot); !errors.Is(err, ErrRoomExists) {
+		t.Fatalf("got %v restoring over the room, want %v", err, ErrRoomExists)
+	}
+
+	// Restored elsewhere, the room keeps its host key, state and age
+	snapshot.UUID = uuid.NewString()
+	snapshot.CreatedAt = time.Now().Add(-time.Hour).Round(0)
+	if err := RestoreRoom(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := SnapshotRoom(snapshot.UUID, hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Locked || !restored.CreatedAt.Equal(snapshot.CreatedAt) || !reflect.DeepEqual(restored.Config, snapshot.Config) {
+		t.Fatalf("got restored room %+v, want it as in %+v", restored, snapshot)
+	}
+
+	errorTests := []struct {
+		name   string
+		modify func(s *RoomSnapshot)
+		want   error
+	}{
+		{name: "no UUID", modify: func(s *RoomSnapshot) { s.UUID = "" }, want: ErrInvalidSnapshot},
+		{name: "no host key", modify: func(s *RoomSnapshot) { s.HostKey = "" }, want: ErrInvalidSnapshot},
+		{name: "expired", modify: func(s *RoomSnapshot) { s.Expired = true }, want: ErrRoomExpired},
+		{name: "invalid config", modify: func(s *RoomSnapshot) { s.Config.AllowAudio, s.Config.AllowVideo = false, false }, want: ErrNoMediaAllowed},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := snapshot
+			s.UUID = uuid.NewString()
+			tt.modify(&s)
+
+			if err := RestoreRoom(s); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/pion/webrtc/v3"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// speakerInterval is how often the dominant speaker is re-evaluated
+	speakerInterval = 500 * time.Millisecond
+
+	// speakerThreshold is the quietest audio level, in -dBov, still counted as speech
+	speakerThreshold = 50
+
+	// speakerMargin is how much louder, in dB, another peer must be to take over
+	speakerMargin = 6
+)
+
+// speakerDetector picks the dominant speaker of a room from the audio
+// levels clients put in their RTP header extensions
+type speakerDetector struct {
+	sync.Mutex
+	levels    map[string]*speakerLevel
+	current   string
+	evaluated time.Time
+}
+
+type speakerLevel struct {
+	sum   int
+	count int
+}
+
+func newSpeakerDetector() *speakerDetector {
+	return &speakerDetector{levels: make(map[string]*speakerLevel)}
+}
+
+// observe records an audio level (0 is the loudest, 127 silence). It reports
+// the new dominant speaker when the evaluation that's due changed it
+func (d *speakerDetector) observe(peerID string, level uint8) (string, bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	l, ok := d.levels[peerID]
+	if !ok {
+		l = &speakerLevel{}
+		d.levels[peerID] = l
+	}
+	l.sum += int(level)
+	l.count++
+
+	if time.Since(d.evaluated) < speakerInterval {
+		return "", false
+	}
+
+	return d.evaluate()
+}
+
+// evaluate compares the average levels of the last interval and resets them
+func (d *speakerDetector) evaluate() (string, bool) {
+	d.evaluated = time.Now()
+
+	loudest, loudestLevel, currentLevel := "", speakerThreshold+1, 128
+	for peerID, l := range d.levels {
+		if l.count == 0 {
+			continue
+		}
+
+		average := l.sum / l.count
+		if peerID == d.current {
+			currentLevel = average
+		}
+
+		if average < loudestLevel {
+			loudest, loudestLevel = peerID, average
+		}
+
+		l.sum, l.count = 0, 0
+	}
+
+	if loudest == "" || loudest == d.current {
+		return "", false
+	}
+
+	// The current speaker keeps the floor unless clearly talked over
+	if currentLevel <= speakerThreshold && currentLevel-loudestLevel < speakerMargin {
+		return "", false
+	}
+
+	d.current = loudest
+	return loudest, true
+}
+
+func (d *speakerDetector) active() string {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.current
+}
+
+// setActiveIfNone makes peerID the active speaker if nobody holds the floor yet
+func (d *speakerDetector) setActiveIfNone(peerID string) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.current != "" {
+		return false
+	}
+
+	d.current = peerID
+	return true
+}
+
+func (d *speakerDetector) forget(peerID string) {
+	d.Lock()
+	defer d.Unlock()
+
+	delete(d.levels, peerID)
+	if d.current == peerID {
+		d.current = ""
+	}
+}
+
+// switchActiveSpeaker forwards only the video of the new active speaker to
+// the room and asks it for a keyframe so subscribers can decode right away
+func switchActiveSpeaker(roomUUID, peerID string) {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	for _, track := range trackLocals[roomUUID] {
+		if track.Kind() != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+
+		if track.peerID == peerID && !track.isHeld() {
+			track.resumeAll()
+			track.keyFrame()
+		} else {
+			track.pauseAll()
+		}
+	}
+
+	data, err := json.Marshal(peerID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	broadcast(roomUUID, websocketMessage{Event: "active_speaker", Data: string(data)}, nil)
+}
@@ -0,0 +1,75 @@
+package websockets
+
+import (
+	"github.com/pion/rtp"
+	"testing"
+	"time"
+)
+
+func TestActiveSpeakerVideo(t *testing.T) {
+	config := DefaultRoomConfig()
+	config.ActiveSpeakerOnly = true
+	roomUUID, _, err := AddRoomUUID("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listLock.RLock()
+	detector := conferences[roomUUID].speaker
+	listLock.RUnlock()
+
+	// Every peer publishes a camera and watches the two others
+	peers := []string{"alice", "bob", "carol"}
+	cameras := make(map[string]*localTrack)
+	queues := make(map[string]map[string]chan queuedPacket)
+	for _, publisher := range peers {
+		cameras[publisher] = addTestTrack(t, roomUUID, publisher, "camera", testVP8)
+		queues[publisher] = make(map[string]chan queuedPacket)
+		for _, subscriber := range peers {
+			if subscriber != publisher {
+				queues[publisher][subscriber] = bindTestSubscriber(t, cameras[publisher], subscriber)
+			}
+		}
+	}
+
+	// speak has loudest talk over the others for a whole interval
+	speak := func(loudest string) {
+		detector.Lock()
+		detector.evaluated = time.Now()
+		detector.Unlock()
+		for _, peerID := range peers {
+			if peerID != loudest {
+				detector.observe(peerID, 100)
+			}
+		}
+
+		detector.Lock()
+		detector.evaluated = time.Now().Add(-speakerInterval)
+		detector.Unlock()
+		speaker, changed := detector.observe(loudest, 10)
+		if !changed || speaker != loudest {
+			t.Fatalf("got speaker %q changed=%v, want %q", speaker, changed, loudest)
+		}
+		switchActiveSpeaker(roomUUID, speaker)
+	}
+
+	seq := uint16(0)
+	for _, loudest := range []string{"alice", "bob", "carol", "alice"} {
+		speak(loudest)
+
+		for _, publisher := range peers {
+			seq++
+			cameras[publisher].writeRTP(&rtp.Packet{Header: rtp.Header{PayloadType: 96, SequenceNumber: seq}, Payload: []byte{1}})
+
+			for subscriber, queue := range queues[publisher] {
+				want := 0
+				if publisher == loudest {
+					want = 1
+				}
+				if got := forwarded(queue); got != want {
+					t.Fatalf("%s speaking: %s got %d packets of %s's camera, want %d", loudest, subscriber, got, publisher, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package websockets
+
+// PeerStats are the traffic counters of a connected peer
+type PeerStats struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	BytesIn  uint64 `json:"bytesIn"`
+	BytesOut uint64 `json:"bytesOut"`
+
+	// PacketsDropped counts the packets a slow subscriber missed
+	PacketsDropped uint64 `json:"packetsDropped"`
+
+	// Quality is what the client last reported of its connection
+	Quality *QualityReport `json:"quality,omitempty"`
+}
+
+// RoomStats is a point in time snapshot of a room
+type RoomStats struct {
+	Participants int         `json:"participants"`
+	Tracks       int         `json:"tracks"`
+	Peers        []PeerStats `json:"peers"`
+}
+
+// GetRoomStats returns the current stats of a room
+func GetRoomStats(roomUUID string) (RoomStats, error) {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	if _, ok := conferences[roomUUID]; !ok {
+		return RoomStats{}, ErrRoomNotFound
+	}
+
+	stats := RoomStats{
+		Participants: len(peerConnections[roomUUID]),
+		Tracks:       len(trackLocals[roomUUID]),
+		Peers:        make([]PeerStats, 0, len(peerConnections[roomUUID])),
+	}
+
+	for _, p := range peerConnections[roomUUID] {
+		stats.Peers = append(stats.Peers, PeerStats{
+			ID:             p.id,
+			Name:           p.name,
+			BytesIn:        p.bytesIn.Load(),
+			BytesOut:       p.bytesOut.Load(),
+			PacketsDropped: p.packetsDropped.Load(),
+			Quality:        p.quality,
+		})
+	}
+
+	return stats, nil
+}
+
+// ServerStats counts what the instance is serving across all rooms
+type ServerStats struct {
+	Rooms  int `json:"rooms"`
+	Peers  int `json:"peers"`
+	Tracks int `json:"tracks"`
+
+	// PacketsDropped counts the packets subscribers missed since startup
+	PacketsDropped uint64 `json:"packetsDropped"`
+}
+
+// GetServerStats returns the current totals of the instance
+func GetServerStats() ServerStats {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	stats := ServerStats{Rooms: len(conferences), PacketsDropped: packetsDroppedTotal.Load()}
+	for roomUUID := range conferences {
+		stats.Peers += len(peerConnections[roomUUID])
+		stats.Tracks += len(trackLocals[roomUUID])
+	}
+
+	return stats
+}
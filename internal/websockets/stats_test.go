@@ -0,0 +1,68 @@
+package websockets
+
+import (
+	"github.com/pion/rtp"
+	"testing"
+	"time"
+)
+
+// countingStream is the write stream of a subscriber taking every packet whole
+type countingStream struct{}
+
+func (countingStream) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	return header.MarshalSize() + len(payload), nil
+}
+
+func (countingStream) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func TestRoomStatsBandwidth(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publisher, subscriber := newTestPeer(t), newTestPeer(t)
+	publisher.id, subscriber.id = "alice", "bob"
+	publisher.websocket, subscriber.websocket = &fakeConn{}, &fakeConn{}
+
+	listLock.Lock()
+	peerConnections[roomUUID] = append(peerConnections[roomUUID], publisher, subscriber)
+	listLock.Unlock()
+
+	track := addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+	bindTestStream(t, track, subscriber, countingStream{})
+
+	// Ten packets of 12 bytes of header and 100 of payload, counted in as the
+	// read loop does and forwarded out
+	for i := 0; i < 10; i++ {
+		publisher.bytesIn.Add(112)
+		track.writeRTP(&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: uint16(i)}, Payload: make([]byte, 100)})
+	}
+
+	want := map[string]PeerStats{
+		"alice": {ID: "alice", BytesIn: 1120},
+		"bob":   {ID: "bob", BytesOut: 1120},
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := GetRoomStats(roomUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make(map[string]PeerStats)
+		for _, peer := range stats.Peers {
+			got[peer.ID] = peer
+		}
+		if got["alice"] == want["alice"] && got["bob"] == want["bob"] {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
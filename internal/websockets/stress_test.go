@@ -0,0 +1,97 @@
+package websockets
+
+import (
+	"github.com/gorilla/websocket"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConnectDisconnectStress joins and leaves many peers at once while the
+// rooms are read, meant to be run with -race. A deadlock fails it with the
+// stacks of every goroutine
+func TestConnectDisconnectStress(t *testing.T) {
+	const (
+		rooms         = 2
+		peersPerRoom  = 16
+		rounds        = 3
+		stressTimeout = 30 * time.Second
+	)
+
+	srv := newTestServer(t)
+
+	roomUUIDs := make([]string, rooms)
+	for i := range roomUUIDs {
+		roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		roomUUIDs[i] = roomUUID
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		stop := make(chan struct{})
+		var readers sync.WaitGroup
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, roomUUID := range roomUUIDs {
+					_, _ = ListParticipants(roomUUID)
+					_, _ = RoomEvents(roomUUID)
+				}
+				_ = GetServerStats()
+			}
+		}()
+
+		var peers sync.WaitGroup
+		for round := 0; round < rounds; round++ {
+			for _, roomUUID := range roomUUIDs {
+				for i := 0; i < peersPerRoom; i++ {
+					peers.Add(1)
+					go func(roomUUID string, i int) {
+						defer peers.Done()
+
+						url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/websocket/" + roomUUID + "/join"
+						ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+
+						// Half leave at once, the others once negotiation started
+						if i%2 == 1 {
+							nextEvent(t, ws, "offer", 2*time.Second)
+						}
+						_ = ws.Close()
+					}(roomUUID, i)
+				}
+			}
+			peers.Wait()
+		}
+
+		close(stop)
+		readers.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(stressTimeout):
+		buf := make([]byte, 1<<20)
+		t.Fatalf("peers still connecting after %s:\n%s", stressTimeout, buf[:runtime.Stack(buf, true)])
+	}
+
+	for _, roomUUID := range roomUUIDs {
+		waitForPeers(t, roomUUID, 0)
+	}
+}
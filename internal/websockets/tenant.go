@@ -0,0 +1,75 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Tenant modes, see TENANT_MODE
+const (
+	tenantNone   = "none"
+	tenantOrigin = "origin"
+	tenantHeader = "header"
+)
+
+var (
+	// tenantMode says where the tenant of a request comes from, rooms are only
+	// reachable from the tenant that created them
+	tenantMode string
+
+	// tenantHeader carries the tenant in header mode
+	tenantHeaderName string
+
+	// tenantPattern keeps header supplied tenants to names safe in logs and paths
+	tenantPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+)
+
+func init() {
+	tenantMode = strings.ToLower(config.String("TENANT_MODE", tenantNone))
+	switch tenantMode {
+	case tenantNone, tenantOrigin, tenantHeader:
+	default:
+		log.Fatalf("TENANT_MODE must be none, origin or header, got %q", tenantMode)
+	}
+
+	tenantHeaderName = config.String("TENANT_HEADER", "X-Tenant-ID")
+}
+
+// Tenant returns the tenant a request acts for: the host of its Origin or
+// the TENANT_HEADER, as TENANT_MODE says. Requests without one, or with an
+// invalid one, belong to the empty tenant
+func Tenant(r *http.Request) string {
+	switch tenantMode {
+	case tenantOrigin:
+		origin, err := url.Parse(r.Header.Get("Origin"))
+		if err != nil {
+			return ""
+		}
+		return strings.ToLower(origin.Host)
+	case tenantHeader:
+		if tenant := r.Header.Get(tenantHeaderName); tenantPattern.MatchString(tenant) {
+			return tenant
+		}
+	}
+
+	return ""
+}
+
+// ForeignRoom reports whether the room exists but belongs to another tenant.
+// Such rooms are treated as unknown, so nothing tells they exist
+func ForeignRoom(roomUUID, tenant string) bool {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	return foreignRoom(roomUUID, tenant)
+}
+
+// foreignRoom is ForeignRoom with listLock held
+func foreignRoom(roomUUID, tenant string) bool {
+	r, ok := conferences[roomUUID]
+	return ok && r.tenant != tenant
+}
@@ -0,0 +1,90 @@
+package websockets
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenant(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		header map[string]string
+		want   string
+	}{
+		{name: "none ignores the origin", mode: tenantNone, header: map[string]string{"Origin": "https://a.example", "X-Tenant-ID": "a"}},
+		{name: "origin host", mode: tenantOrigin, header: map[string]string{"Origin": "https://A.example:8443"}, want: "a.example:8443"},
+		{name: "no origin", mode: tenantOrigin},
+		{name: "header", mode: tenantHeader, header: map[string]string{"X-Tenant-ID": "acme-1"}, want: "acme-1"},
+		{name: "invalid header", mode: tenantHeader, header: map[string]string{"X-Tenant-ID": "../acme"}},
+		{name: "header mode ignores the origin", mode: tenantHeader, header: map[string]string{"Origin": "https://a.example"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := tenantMode
+			tenantMode = tt.mode
+			t.Cleanup(func() { tenantMode = previous })
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+
+			if got := Tenant(r); got != tt.want {
+				t.Fatalf("got tenant %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForeignRoom(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("acme", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		roomUUID string
+		tenant   string
+		want     bool
+	}{
+		{name: "own room", roomUUID: roomUUID, tenant: "acme"},
+		{name: "room of another tenant", roomUUID: roomUUID, tenant: "globex", want: true},
+		{name: "room of a tenant, asked without one", roomUUID: roomUUID, want: true},
+		{name: "unknown room", roomUUID: "missing-room", tenant: "globex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ForeignRoom(tt.roomUUID, tt.tenant); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerRejectsRoomOfOtherTenant(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("acme", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without TENANT_MODE the join comes from the empty tenant
+	ws := dialRoom(t, srv, roomUUID)
+	_, closeErr := readUntilClose(t, ws)
+	if closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != ErrRoomNotFound.Error() {
+		t.Fatalf("got close %d %q, want %d %q", closeErr.Code, closeErr.Text, websocket.ClosePolicyViolation, ErrRoomNotFound)
+	}
+
+	listLock.RLock()
+	tenant, peers := conferences[roomUUID].tenant, len(peerConnections[roomUUID])
+	listLock.RUnlock()
+	if tenant != "acme" || peers != 0 {
+		t.Fatalf("got room of %q with %d peers, want acme's room left alone", tenant, peers)
+	}
+}
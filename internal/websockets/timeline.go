@@ -0,0 +1,49 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/b4o4/conference-backend/internal/config"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// logRoomEvents writes a room_event line for every join and leave, the
+// timeline analytics are built from
+var logRoomEvents atomic.Bool
+
+func init() {
+	logRoomEvents.Store(config.Bool("ROOM_EVENT_LOG", false))
+}
+
+// roomEvent is one entry of the timeline, a JSON object after the
+// room_event marker so the log pipeline can pick the lines out
+type roomEvent struct {
+	Room  string    `json:"room"`
+	Peer  string    `json:"peer"`
+	Name  string    `json:"name,omitempty"`
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+}
+
+// logRoomEvent logs the peer joining or leaving the room, typ being AuditJoin
+// or AuditLeave
+func logRoomEvent(roomUUID, typ string, p *peerConnectionState) {
+	if !logRoomEvents.Load() {
+		return
+	}
+
+	data, err := json.Marshal(roomEvent{
+		Room:  roomUUID,
+		Peer:  p.id,
+		Name:  p.name,
+		Event: typ,
+		Time:  time.Now().UTC(),
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	log.Printf("room_event %s", data)
+}
@@ -0,0 +1,126 @@
+package websockets
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a log output the test can read while peers still log to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// timeline returns the room_event entries of the room found in the logs
+func timeline(t *testing.T, logs string, roomUUID string) []roomEvent {
+	t.Helper()
+
+	events := []roomEvent{}
+	for _, line := range strings.Split(logs, "\n") {
+		_, data, ok := strings.Cut(line, "room_event ")
+		if !ok {
+			continue
+		}
+
+		event := roomEvent{}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("room_event %q isn't JSON: %v", data, err)
+		}
+		if event.Room == roomUUID {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestRoomEventTimeline(t *testing.T) {
+	previous := logRoomEvents.Load()
+	logRoomEvents.Store(true)
+	t.Cleanup(func() { logRoomEvents.Store(previous) })
+
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().UTC().Add(-time.Second)
+	alice := dialRoomWith(t, srv, roomUUID, url.Values{"name": {"Alice"}})
+	message, ok := nextEvent(t, alice, "welcome", 5*time.Second)
+	if !ok {
+		t.Fatal("no welcome")
+	}
+	aliceWelcome := welcomeMessage{}
+	if err := json.Unmarshal([]byte(message.Data), &aliceWelcome); err != nil {
+		t.Fatal(err)
+	}
+	waitForPeers(t, roomUUID, 1)
+	_, bobWelcome := joinRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 2)
+	_ = alice.Close()
+	waitForPeers(t, roomUUID, 1)
+
+	want := []roomEvent{
+		{Room: roomUUID, Peer: aliceWelcome.ConnectionID, Name: "Alice", Event: AuditJoin},
+		{Room: roomUUID, Peer: bobWelcome.ConnectionID, Event: AuditJoin},
+		{Room: roomUUID, Peer: aliceWelcome.ConnectionID, Name: "Alice", Event: AuditLeave},
+	}
+
+	// The leave is logged once the peer is gone, so wait for it
+	var events []roomEvent
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if events = timeline(t, logs.String(), roomUUID); len(events) >= len(want) {
+			break
+		}
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(events), events, len(want))
+	}
+	for i, event := range events {
+		if event.Peer != want[i].Peer || event.Name != want[i].Name || event.Event != want[i].Event {
+			t.Errorf("event %d: got %s of %s (%q), want %s of %s (%q)", i, event.Event, event.Peer, event.Name, want[i].Event, want[i].Peer, want[i].Name)
+		}
+		if event.Time.Location() != time.UTC || event.Time.Before(start) || event.Time.After(time.Now()) {
+			t.Errorf("event %d: got time %v, want now in UTC", i, event.Time)
+		}
+	}
+}
+
+func TestRoomEventLogOff(t *testing.T) {
+	previous := logRoomEvents.Load()
+	logRoomEvents.Store(false)
+	t.Cleanup(func() { logRoomEvents.Store(previous) })
+
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	logRoomEvent("room", AuditJoin, &peerConnectionState{id: "alice"})
+	if strings.Contains(logs.String(), "room_event") {
+		t.Fatalf("got %q logged without ROOM_EVENT_LOG", logs.String())
+	}
+}
@@ -0,0 +1,40 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"log"
+	"time"
+)
+
+// connectTimeout is how long a peer has to get its PeerConnection connected, 0 waits forever
+var connectTimeout time.Duration
+
+func init() {
+	connectTimeout = config.Duration("CONNECT_TIMEOUT", 30*time.Second)
+}
+
+// watchConnect closes the websocket of a peer whose PeerConnection didn't
+// connect in time, which tears the whole connection down. The returned timer
+// must be stopped once connected
+func watchConnect(c *threadSafeWriter, peerConnection *webrtc.PeerConnection, peerID string) *time.Timer {
+	if connectTimeout == 0 {
+		return nil
+	}
+
+	return time.AfterFunc(connectTimeout, func() {
+		if peerConnection.ConnectionState() == webrtc.PeerConnectionStateConnected {
+			return
+		}
+
+		log.Printf("peer %s didn't connect within %s", peerID, connectTimeout)
+
+		if err := c.WriteJSON(&websocketMessage{Event: "connect_timeout"}); err != nil {
+			logSampled(err)
+		}
+		_ = c.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "connect timeout"), time.Now().Add(time.Second))
+		_ = c.Close()
+	})
+}
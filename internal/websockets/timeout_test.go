@@ -0,0 +1,34 @@
+package websockets
+
+import (
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestUnconnectedPeerIsReaped(t *testing.T) {
+	previous := connectTimeout
+	connectTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { connectTimeout = previous })
+
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The websocket joins but the client never answers nor sends candidates
+	ws := dialRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 1)
+
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	events, closeErr := readUntilClose(t, ws)
+	if len(events) == 0 || events[len(events)-1] != "connect_timeout" {
+		t.Fatalf("got events %v, want them to end with connect_timeout", events)
+	}
+	if closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "connect timeout" {
+		t.Fatalf("got close %d %q, want %d \"connect timeout\"", closeErr.Code, closeErr.Text, websocket.ClosePolicyViolation)
+	}
+
+	waitForPeers(t, roomUUID, 0)
+}
@@ -0,0 +1,27 @@
+package websockets
+
+import (
+	"errors"
+	"github.com/b4o4/conference-backend/internal/tracing"
+)
+
+var errPeerLeft = errors.New("peer left before answering")
+
+// traceOffer starts timing the offer/answer exchange of the peer, resent
+// offers count toward the one already waiting. listLock must be held
+func (p *peerConnectionState) traceOffer(roomUUID string) {
+	if p.offerSpan != nil || p.trace == nil {
+		return
+	}
+
+	_, p.offerSpan = tracing.Start(p.trace, "webrtc.offer_answer",
+		tracing.String("room.id", roomUUID), tracing.String("peer.id", p.id))
+}
+
+// traceAnswer ends the offer/answer span, err is why it didn't complete.
+// listLock must be held
+func (p *peerConnectionState) traceAnswer(err error) {
+	p.offerSpan.SetError(err)
+	p.offerSpan.End()
+	p.offerSpan = nil
+}
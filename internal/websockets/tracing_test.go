@@ -0,0 +1,68 @@
+package websockets
+
+import (
+	"github.com/b4o4/conference-backend/internal/tracing"
+	"github.com/b4o4/conference-backend/internal/tracing/tracetest"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectionSpanTree(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracing.SetExporter(exporter)
+	t.Cleanup(func() { tracing.SetExporter(nil) })
+
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t)
+
+	const callerTraceID, callerSpanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	header := http.Header{"Traceparent": {"00-" + callerTraceID + "-" + callerSpanID + "-01"}}
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/websocket/"+roomUUID+"/join", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nextEvent(t, ws, "offer", 5*time.Second); !ok {
+		t.Fatal("the server sent no offer")
+	}
+	_ = ws.Close()
+
+	// The connection span ends last, once the handler is done with the peer
+	spans := map[string]tracing.SpanData{}
+	deadline := time.Now().Add(5 * time.Second)
+	for spans["websocket.connection"].SpanID == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("the connection span never ended, got %+v", exporter.GetSpans())
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		for _, span := range exporter.GetSpans() {
+			spans[span.Name] = span
+		}
+	}
+
+	connection := spans["websocket.connection"]
+	if connection.TraceID != callerTraceID || connection.ParentSpanID != callerSpanID || !connection.Server {
+		t.Fatalf("connection span %+v doesn't continue the caller's trace", connection)
+	}
+
+	for _, name := range []string{"websocket.upgrade", "webrtc.peerconnection_create", "media.first_packet", "webrtc.offer_answer"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no %s span", name)
+		}
+		if span.TraceID != callerTraceID || span.ParentSpanID != connection.SpanID || span.Server {
+			t.Fatalf("%s span %+v isn't an internal child of the connection span", name, span)
+		}
+	}
+
+	// The peer left without answering the server's offer
+	if offer := spans["webrtc.offer_answer"]; offer.Error != errPeerLeft.Error() {
+		t.Fatalf("got offer/answer error %q, want %q", offer.Error, errPeerLeft)
+	}
+}
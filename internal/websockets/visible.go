@@ -0,0 +1,107 @@
+package websockets
+
+import (
+	"encoding/json"
+	"github.com/b4o4/conference-backend/internal/config"
+	"github.com/pion/webrtc/v3"
+	"log"
+	"sort"
+)
+
+// maxVisibleTracks bounds the visible_tracks list a client may send
+const maxVisibleTracks = 256
+
+// maxVideoTracks is how many video tracks are forwarded to each subscriber at
+// once, e.g. 9 for a 3x3 grid. 0 forwards them all
+var maxVideoTracks int
+
+func init() {
+	maxVideoTracks = config.Int("MAX_VIDEO_TRACKS_PER_SUBSCRIBER", 0)
+}
+
+// setVisibleTracks handles visible_tracks: the client lists the video tracks
+// it shows, most wanted first, and only those are forwarded to it, up to
+// maxVideoTracks. null goes back to forwarding any
+func setVisibleTracks(conn signalConn, roomUUID string, peer *peerConnectionState, message *websocketMessage) bool {
+	var trackIDs []string
+	if err := json.Unmarshal([]byte(message.Data), &trackIDs); err != nil {
+		log.Println(err)
+		return false
+	}
+
+	if len(trackIDs) > maxVisibleTracks {
+		sendError(conn, "too many visible tracks")
+		return true
+	}
+
+	listLock.Lock()
+	peer.visibleTracks = trackIDs
+	listLock.Unlock()
+
+	requestSignal(roomUUID)
+
+	return true
+}
+
+// forwardedVideo returns the IDs of the video tracks the peer gets, nil when
+// it gets all of them. Without a visible_tracks list the tracks already
+// forwarded keep their place, so a new publisher doesn't reshuffle the grid.
+// listLock must be held
+func (p *peerConnectionState) forwardedVideo(roomUUID string) map[string]bool {
+	if p.visibleTracks == nil && maxVideoTracks == 0 {
+		return nil
+	}
+
+	isVideo := func(trackID string) bool {
+		t, ok := trackLocals[roomUUID][trackID]
+		return ok && t.peerID != p.id && t.Kind() == webrtc.RTPCodecTypeVideo
+	}
+
+	wanted := []string{}
+	if p.visibleTracks != nil {
+		for _, trackID := range p.visibleTracks {
+			if isVideo(trackID) {
+				wanted = append(wanted, trackID)
+			}
+		}
+	} else {
+		sending := map[string]bool{}
+		for _, sender := range p.peerConnection.GetSenders() {
+			if sender.Track() != nil {
+				sending[sender.Track().ID()] = true
+			}
+		}
+
+		for trackID := range trackLocals[roomUUID] {
+			if isVideo(trackID) {
+				wanted = append(wanted, trackID)
+			}
+		}
+		sort.Slice(wanted, func(i, j int) bool {
+			if sending[wanted[i]] != sending[wanted[j]] {
+				return sending[wanted[i]]
+			}
+			return wanted[i] < wanted[j]
+		})
+	}
+
+	forwarded := map[string]bool{}
+	for _, trackID := range wanted {
+		if maxVideoTracks > 0 && len(forwarded) == maxVideoTracks {
+			break
+		}
+		forwarded[trackID] = true
+	}
+
+	return forwarded
+}
+
+// wants reports whether the track is forwarded to the peer, forwarded being
+// what forwardedVideo returned. listLock must be held
+func (p *peerConnectionState) wants(t *localTrack, forwarded map[string]bool) bool {
+	if t.peerID == p.id || !p.receives(t.Kind()) {
+		return false
+	}
+
+	return forwarded == nil || t.Kind() != webrtc.RTPCodecTypeVideo || forwarded[t.ID()]
+}
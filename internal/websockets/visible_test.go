@@ -0,0 +1,89 @@
+package websockets
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestVisibleTracks(t *testing.T) {
+	previous := maxVideoTracks
+	maxVideoTracks = 2
+	t.Cleanup(func() { maxVideoTracks = previous })
+
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mic := addTestTrack(t, roomUUID, "alice", "mic", testOpus).ID()
+	alice := addTestTrack(t, roomUUID, "alice", "camera", testVP8).ID()
+	bob := addTestTrack(t, roomUUID, "bob", "camera", testVP8).ID()
+	carol := addTestTrack(t, roomUUID, "carol", "camera", testVP8).ID()
+
+	dave := newTestPeer(t)
+	dave.id = "dave"
+	conn := &fakeConn{}
+	dave.websocket = conn
+
+	steps := []struct {
+		name    string
+		visible string
+		want    []string
+	}{
+		{name: "capped without a list", want: []string{mic, alice, bob}},
+		{name: "only the listed track", visible: `["` + carol + `"]`, want: []string{mic, carol}},
+		{name: "listed tracks within the cap", visible: `["` + carol + `","` + bob + `","` + alice + `"]`, want: []string{mic, bob, carol}},
+		{name: "unknown and audio tracks are skipped", visible: `["nobody-camera","` + mic + `","` + alice + `"]`, want: []string{mic, alice}},
+		{name: "empty list turns video off", visible: `[]`, want: []string{mic}},
+		{name: "listed tracks", visible: `["` + carol + `","` + bob + `"]`, want: []string{mic, bob, carol}},
+		{name: "null keeps the forwarded tracks", visible: `null`, want: []string{mic, bob, carol}},
+	}
+
+	for _, step := range steps {
+		if step.visible != "" {
+			message := websocketMessage{Event: "visible_tracks", Data: step.visible}
+			if !handleMessage(conn, dave, roomUUID, &message) {
+				t.Fatalf("%s: visible_tracks closed the connection", step.name)
+			}
+		}
+
+		listLock.Lock()
+		syncPeer(roomUUID, dave)
+		dave.answered()
+		listLock.Unlock()
+
+		got := []string{}
+		for _, sender := range dave.peerConnection.GetSenders() {
+			if sender.Track() != nil {
+				got = append(got, sender.Track().ID())
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(step.want)
+		if !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s %s: got tracks %v, want %v", step.name, step.visible, got, step.want)
+		}
+	}
+}
+
+func TestTooManyVisibleTracks(t *testing.T) {
+	peer := newTestPeer(t)
+	conn := &fakeConn{}
+
+	data, err := json.Marshal(make([]string, maxVisibleTracks+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := websocketMessage{Event: "visible_tracks", Data: string(data)}
+	if !handleMessage(conn, peer, "", &message) {
+		t.Fatal("an overlong list closed the connection")
+	}
+	if _, ok := conn.message("error"); !ok {
+		t.Fatalf("got %v, want an error", conn.events())
+	}
+	if peer.visibleTracks != nil {
+		t.Fatalf("got visible tracks %v, want the list refused", peer.visibleTracks)
+	}
+}
@@ -1,17 +1,28 @@
 package websockets
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/b4o4/conference-backend/internal/auth"
+	"github.com/b4o4/conference-backend/internal/tracing"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -19,39 +30,271 @@ var (
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
 
+	// listLock guards the room maps below. Lock ordering, outermost first:
+	//
+	//	listLock -> localTrack.mu -> downTrack.mu
+	//	listLock -> threadSafeWriter, auditLog, statsHistory, speakerDetector
+	//
+	// signalLock is never held while taking another lock. Code holding
+	// listLock must not call anything that takes it again: renegotiation is
+	// requested through requestSignal, which runs later, and PeerConnections
+	// are closed asynchronously since pion fires callbacks that take it.
+	// Websocket writes under listLock are bounded by writeWait, so a stalled
+	// client can't hold up the other rooms
 	listLock        sync.RWMutex
-	conferences     = make(map[string]int)
-	peerConnections = make(map[string][]peerConnectionState)
-	trackLocals     = make(map[string]map[string]*webrtc.TrackLocalStaticRTP)
+	conferences     = make(map[string]*room)
+	peerConnections = make(map[string][]*peerConnectionState)
+	trackLocals     = make(map[string]map[string]*localTrack)
+
+	// Version is reported to clients, set at build time with
+	// -ldflags "-X github.com/b4o4/conference-backend/internal/websockets.Version=..."
+	Version = "dev"
+
+	// draining is set while the instance is being taken out of rotation:
+	// new rooms and joins are refused, existing calls keep running
+	draining atomic.Bool
+)
+
+var (
+	ErrRoomNotFound        = errors.New("room not found")
+	ErrTokenForOtherRoom   = errors.New("token is for another room")
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrNotHost             = fmt.Errorf("%w: not the room host", ErrUnauthorized)
+	ErrTrackKindNotAllowed = errors.New("track kind not allowed in room")
+	ErrRoomLocked          = errors.New("room is locked")
+	ErrRoomExpired         = errors.New("room has expired")
+	ErrPeerNotFound        = errors.New("peer not found")
+	ErrDuplicateTrack      = errors.New("track ID already published")
+	ErrRoomNameTaken       = errors.New("room name already taken")
+
+	errUnknownEvent = errors.New("unknown event")
 )
 
 type websocketMessage struct {
 	Event string `json:"event"`
 	Data  string `json:"data"`
+
+	// Room is only set on multiplexed connections, see MultiplexHandler
+	Room string `json:"room,omitempty"`
+}
+
+type room struct {
+	config    RoomConfig
+	createdAt time.Time
+	hostKey   string
+
+	// tenant is who created the room, other tenants can't reach it
+	tenant string
+
+	locked    bool
+	expired   bool
+	events    *auditLog
+	history   *statsHistory
+	speaker   *speakerDetector
+	recording *recording
+	observers map[*observer]bool
+
+	// recordingRequested is set from StartRecording to StopRecording, the
+	// recording only runs while the consentPolicy is met
+	recordingRequested bool
+	consentPolicy      string
+
+	// hands is the raise hand queue, oldest first
+	hands []RaisedHand
+
+	// emptySince is when the last peer left, zero while peers are connected
+	emptySince time.Time
+
+	// p2p is set while the two peers of a PreferP2P room were hinted to connect directly
+	p2p bool
+
+	// unclaimed is set on rooms loaded from the ROOM_STORE_FILE until a peer
+	// joins, those still unclaimed after the RECONNECT_WINDOW are removed
+	unclaimed bool
 }
 
+// isHost reports whether key grants host rights in the room
+func (r *room) isHost(key string) bool {
+	return r.hostKey != "" && subtle.ConstantTimeCompare([]byte(r.hostKey), []byte(key)) == 1
+}
+
+// welcomeMessage is the first event sent on every connection
+type welcomeMessage struct {
+	ServerTime   time.Time          `json:"serverTime"`
+	Version      string             `json:"version"`
+	ConnectionID string             `json:"connectionId"`
+	ICEServers   []webrtc.ICEServer `json:"iceServers"`
+
+	// ReconnectToken lets the client take its tracks back after reconnecting
+	// within PUBLISHER_GRACE_PERIOD, passed as ?reconnect= on the next join
+	ReconnectToken string `json:"reconnectToken,omitempty"`
+
+	// ICECandidatePoolSize, BundlePolicy and RTCPMuxPolicy are meant for the
+	// client's RTCPeerConnection config
+	ICECandidatePoolSize uint8  `json:"iceCandidatePoolSize,omitempty"`
+	BundlePolicy         string `json:"bundlePolicy"`
+	RTCPMuxPolicy        string `json:"rtcpMuxPolicy"`
+}
+
+type trackEvent struct {
+	TrackID string `json:"trackId"`
+	Kind    string `json:"kind"`
+	PeerID  string `json:"peerId,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// maxNameLength is the longest display name kept, in runes
+const maxNameLength = 64
+
 type peerConnectionState struct {
+	id       string
+	name     string
+	identity auth.Identity
+	isHost   bool
+	joinedAt time.Time
+	muted    bool
+
+	// reconnectToken was given to the client in welcome, resumeToken is the
+	// one it brought from its previous connection. See localTrack.ownedBy
+	reconnectToken string
+	resumeToken    string
+
+	// paused is set by the host to stop forwarding the peer's media
+	paused bool
+
+	// audioOnly skips video when the peer's subscriptions are synced, to save bandwidth
+	audioOnly bool
+
+	// visibleTracks are the video tracks the client shows, nil when it didn't
+	// say, see setVisibleTracks
+	visibleTracks []string
+
+	// metadata is what the client chose to show the room about itself, a JSON object
+	metadata json.RawMessage
+
+	// quality is the last quality_report of the client, nil until it sends one
+	quality *QualityReport
+
+	// recordingConsent is the answer of the peer to the last consent request
+	recordingConsent bool
+
+	// offerTimer runs while an offer waits for its answer, see watchOffer
+	offerTimer      *time.Timer
+	offerGeneration uint64
+	offerRetries    int
+
+	// negotiationFailures counts the offers in a row that couldn't be created
+	negotiationFailures int
+
+	// lastOfferAt is when the last offer was sent, offerDeferred is set while
+	// a sync waits for MIN_OFFER_INTERVAL, see offerThrottled
+	lastOfferAt   time.Time
+	offerDeferred bool
+
+	// pendingCandidates are the client's candidates that came before the
+	// remote description, only the peer's read loop touches them
+	pendingCandidates []webrtc.ICECandidateInit
+
+	// trace carries the connection span, offerSpan times the offer waiting
+	// for its answer. Both are nil unless tracing is enabled
+	trace     context.Context
+	offerSpan *tracing.Span
+
+	// bytesIn counts the media received from the peer, bytesOut the media
+	// forwarded to it and packetsDropped what it couldn't take in time
+	bytesIn        atomic.Uint64
+	bytesOut       atomic.Uint64
+	packetsDropped atomic.Uint64
+
+	// graceful is set when the client closed its websocket normally
+	graceful atomic.Bool
+
+	// lastSeen is when the client last sent a message or a pong, in Unix
+	// nanoseconds, see presenceTTL
+	lastSeen atomic.Int64
+
 	peerConnection *webrtc.PeerConnection
-	websocket      *threadSafeWriter
+	websocket      peerSocket
+}
+
+// Receive modes a peer can switch between with set_receive_mode
+const (
+	receiveAll       = "all"
+	receiveAudioOnly = "audio"
+)
+
+// receives reports whether tracks of the kind are forwarded to the peer. listLock must be held
+func (p *peerConnectionState) receives(kind webrtc.RTPCodecType) bool {
+	return !p.audioOnly || kind == webrtc.RTPCodecTypeAudio
+}
+
+// signalConn is the transport signaling messages travel over. threadSafeWriter
+// implements it on a websocket, an in-memory one is enough to drive handleMessage
+type signalConn interface {
+	WriteJSON(v interface{}) error
+	ReadMessage() (messageType int, p []byte, err error)
+	Close() error
+}
+
+var _ signalConn = (*threadSafeWriter)(nil)
+
+// peerSocket is the websocket of a peer in a room: next to signaling it is
+// pinged, closed with a reason, and flagged once a write failed
+type peerSocket interface {
+	signalConn
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	markFailed()
+	broken() bool
 }
 
+var _ peerSocket = (*threadSafeWriter)(nil)
+
+// writeWait bounds every websocket write, including those made holding listLock
+const writeWait = 5 * time.Second
+
 // Helper to make Gorilla Websockets threadsafe
 type threadSafeWriter struct {
 	*websocket.Conn
 	sync.Mutex
+
+	// failed is set once a write errored, the peer is then reaped
+	failed atomic.Bool
 }
 
 func (t *threadSafeWriter) WriteJSON(v interface{}) error {
 	t.Lock()
 	defer t.Unlock()
 
-	return t.Conn.WriteJSON(v)
+	if err := t.Conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		t.markFailed()
+		return err
+	}
+
+	if err := t.Conn.WriteJSON(v); err != nil {
+		t.markFailed()
+		return err
+	}
+
+	return nil
+}
+
+// markFailed flags the connection as broken and closes the socket, so a read
+// loop blocked on a half-broken connection returns and cleans up
+func (t *threadSafeWriter) markFailed() {
+	if t.failed.CompareAndSwap(false, true) {
+		_ = t.Conn.Close()
+	}
+}
+
+// broken reports whether a write failed, see markFailed
+func (t *threadSafeWriter) broken() bool {
+	return t.failed.Load()
 }
 
-// dispatchKeyFrame sends a keyframe to all PeerConnections, used everytime a new user joins the call
+// dispatchKeyFrame sends a keyframe to all PeerConnections of the room, see KEYFRAME_MODE for when
 func dispatchKeyFrame(roomUUID string) {
-	listLock.Lock()
-	defer listLock.Unlock()
+	listLock.RLock()
+	defer listLock.RUnlock()
 
 	for i := range peerConnections[roomUUID] {
 		for _, receiver := range peerConnections[roomUUID][i].peerConnection.GetReceivers() {
@@ -68,15 +311,396 @@ func dispatchKeyFrame(roomUUID string) {
 	}
 }
 
-func AddRoomUUID() string {
-	roomUUID := uuid.New()
+// requestKeyFrame sends a PLI for a single track, or for every track of the
+// room when trackID is empty. It reports false for an unknown track
+func requestKeyFrame(roomUUID, trackID string) bool {
+	if trackID == "" {
+		dispatchKeyFrame(roomUUID)
+		return true
+	}
+
+	listLock.RLock()
+	track, ok := trackLocals[roomUUID][trackID]
+	listLock.RUnlock()
+
+	if ok {
+		track.keyFrame()
+	}
+
+	return ok
+}
+
+// SetDraining switches drain mode on or off
+func SetDraining(v bool) {
+	draining.Store(v)
+}
+
+// IsDraining reports whether the instance refuses new rooms and joins
+func IsDraining() bool {
+	return draining.Load()
+}
+
+// AddRoomUUID creates a room of the tenant and returns its ID, generated as
+// ROOM_ID_STYLE says, with the key granting host rights in it
+func AddRoomUUID(tenant string, config RoomConfig) (string, string, error) {
+	config, err := NormalizeRoomConfig(config)
+	if err != nil {
+		return "", "", err
+	}
+
+	r := newRoom(config)
+	r.hostKey = uuid.NewString()
+	r.tenant = tenant
+
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	if roomNameTaken(tenant, config.Name, "") {
+		return "", "", ErrRoomNameTaken
+	}
+
+	if roomsFull() && !(evictStaleRooms && evictStaleRoom()) {
+		return "", "", ErrTooManyRooms
+	}
+
+	roomUUID, err := newRoomID(roomIDs)
+	if err != nil {
+		return "", "", err
+	}
+	registerRoom(roomUUID, r)
+
+	return roomUUID, r.hostKey, nil
+}
+
+// RoomParticipants returns the number of peers connected to a room and whether the room exists
+func RoomParticipants(roomUUID string) (int, bool) {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	if _, ok := conferences[roomUUID]; !ok {
+		return 0, false
+	}
+
+	return len(peerConnections[roomUUID]), true
+}
 
-	conferences[roomUUID.String()] = 1
+// CheckJoinable reports why a guest can't join the room, if anything prevents it
+func CheckJoinable(roomUUID string) error {
+	listLock.RLock()
+	defer listLock.RUnlock()
 
-	return roomUUID.String()
+	return joinError(roomUUID, false)
+}
+
+// joinError reports why a peer can't join the room, nil if it can. Hosts
+// get into full and locked rooms. listLock must be held
+func joinError(roomUUID string, isHost bool) error {
+	r, ok := conferences[roomUUID]
+	switch {
+	case !ok:
+		return ErrRoomNotFound
+	case r.expired:
+		return ErrRoomExpired
+	case isHost:
+		return nil
+	case roomFull(roomUUID):
+		return ErrRoomFull
+	case r.locked:
+		return ErrRoomLocked
+	default:
+		return nil
+	}
+}
+
+// disconnect tells the client why it can't be in the room and closes its websocket
+func disconnect(c peerSocket, err error) {
+	switch {
+	case errors.Is(err, ErrRoomExpired):
+		closeWith(c, "room_expired", websocket.CloseNormalClosure, "room expired")
+	case errors.Is(err, ErrRoomFull):
+		// The client may retry later
+		closeWith(c, "room_full", websocket.CloseTryAgainLater, "room full")
+	case errors.Is(err, ErrRoomLocked):
+		closeWith(c, "room_locked", websocket.ClosePolicyViolation, "room locked")
+	default:
+		closeWith(c, "error", websocket.ClosePolicyViolation, err.Error())
+	}
+}
+
+// closeWith sends the client an event, then closes its websocket with code and reason
+func closeWith(c peerSocket, event string, code int, reason string) {
+	if err := c.WriteJSON(&websocketMessage{Event: event}); err != nil {
+		logSampled(err)
+	}
+
+	_ = c.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	_ = c.Close()
+}
+
+// CloseAll tells every connected peer the server is going down, then closes
+// their connections. New joins are refused from then on
+func CloseAll() {
+	SetDraining(true)
+
+	closeRoomStore()
+
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	for _, peers := range peerConnections {
+		for _, p := range peers {
+			if err := p.websocket.WriteJSON(&websocketMessage{Event: "server_shutdown"}); err != nil {
+				log.Println(err)
+			}
+
+			_ = p.websocket.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"), time.Now().Add(time.Second))
+			_ = p.websocket.Close()
+		}
+	}
+
+	for _, r := range conferences {
+		for o := range r.observers {
+			if err := o.conn.WriteJSON(&websocketMessage{Event: "server_shutdown"}); err != nil {
+				logSampled(err)
+			}
+			_ = o.conn.Close()
+		}
+	}
+}
+
+// Participant describes a peer connected to a room
+type Participant struct {
+	ID       string    `json:"id"`
+	UserID   string    `json:"userId,omitempty"`
+	Name     string    `json:"name"`
+	IsHost   bool      `json:"isHost"`
+	JoinedAt time.Time `json:"joinedAt"`
+	Muted    bool      `json:"muted"`
+	Paused   bool      `json:"paused"`
+
+	// Metadata is the JSON object the peer attached to itself
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// ListParticipants returns the peers connected to a room
+func ListParticipants(roomUUID string) ([]Participant, error) {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	if _, ok := conferences[roomUUID]; !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	return participants(roomUUID), nil
+}
+
+// TrackInfo describes a track the room forwards
+type TrackInfo struct {
+	TrackID     string `json:"trackId"`
+	Kind        string `json:"kind"`
+	Codec       string `json:"codec"`
+	PeerID      string `json:"peerId"`
+	Subscribers int    `json:"subscribers"`
+	Held        bool   `json:"held"`
+	Orphaned    bool   `json:"orphaned"`
+}
+
+// ListTracks returns the tracks published to a room, ordered by ID
+func ListTracks(roomUUID string) ([]TrackInfo, error) {
+	listLock.RLock()
+	defer listLock.RUnlock()
+
+	if _, ok := conferences[roomUUID]; !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	list := make([]TrackInfo, 0, len(trackLocals[roomUUID]))
+	for _, t := range trackLocals[roomUUID] {
+		t.mu.RLock()
+		list = append(list, TrackInfo{
+			TrackID:     t.ID(),
+			Kind:        t.Kind().String(),
+			Codec:       t.codec.MimeType,
+			PeerID:      t.peerID,
+			Subscribers: len(t.subscribers),
+			Held:        t.held,
+			Orphaned:    t.orphaned != nil,
+		})
+		t.mu.RUnlock()
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].TrackID < list[j].TrackID })
+
+	return list, nil
+}
+
+// participants describes the peers of a room. listLock must be held
+func participants(roomUUID string) []Participant {
+	list := make([]Participant, 0, len(peerConnections[roomUUID]))
+	for _, p := range peerConnections[roomUUID] {
+		list = append(list, p.participant())
+	}
+
+	return list
+}
+
+func (p *peerConnectionState) participant() Participant {
+	return Participant{
+		ID:       p.id,
+		UserID:   p.identity.Subject,
+		Name:     p.name,
+		IsHost:   p.isHost,
+		JoinedAt: p.joinedAt,
+		Muted:    p.muted,
+		Paused:   p.paused,
+		Metadata: p.metadata,
+	}
+}
+
+// displayName trims the name a peer joined with to a sane length
+func displayName(name string) string {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxNameLength {
+		name = string([]rune(name)[:maxNameLength])
+	}
+
+	return name
+}
+
+// SetRoomLocked locks or unlocks a room. Locked rooms accept no new peers except the host
+func SetRoomLocked(roomUUID, hostKey string, locked bool) error {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !r.isHost(hostKey) {
+		return ErrNotHost
+	}
+
+	r.locked = locked
+
+	if locked {
+		r.events.append(AuditLock, "")
+	} else {
+		r.events.append(AuditUnlock, "")
+	}
+
+	return nil
+}
+
+// RenameRoom changes the name of a room and tells its peers. Only the host may rename it
+func RenameRoom(roomUUID, hostKey, name string) error {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	r, ok := conferences[roomUUID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !r.isHost(hostKey) {
+		return ErrNotHost
+	}
+
+	name = displayName(name)
+	if roomNameTaken(r.tenant, name, roomUUID) {
+		return ErrRoomNameTaken
+	}
+
+	r.config.Name = name
+	r.events.append(AuditRename, "")
+
+	data, err := json.Marshal(r.config.Name)
+	if err != nil {
+		return err
+	}
+
+	broadcast(roomUUID, websocketMessage{Event: "room_renamed", Data: string(data)}, nil)
+
+	return nil
+}
+
+// roomNameTaken reports whether a room of the tenant other than except goes
+// by name, ignoring case. Unnamed rooms never clash. listLock must be held
+func roomNameTaken(tenant, name, except string) bool {
+	if name == "" {
+		return false
+	}
+
+	for roomUUID, r := range conferences {
+		if roomUUID != except && r.tenant == tenant && strings.EqualFold(r.config.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// registerRoom sets up every per-room structure at once, so code handling
+// an existing room never has to initialize them. listLock must be held
+func registerRoom(roomUUID string, r *room) {
+	conferences[roomUUID] = r
+	peerConnections[roomUUID] = []*peerConnectionState{}
+	trackLocals[roomUUID] = make(map[string]*localTrack)
+	scheduleExpiry(roomUUID, r)
+}
+
+// unregisterRoom removes a room and every per-room structure, peers still
+// connected are told with event and disconnected. listLock must be held
+func unregisterRoom(roomUUID, event string) {
+	for _, p := range peerConnections[roomUUID] {
+		closeWith(p.websocket, event, websocket.CloseGoingAway, event)
+	}
+	notifyObservers(roomUUID, websocketMessage{Event: event})
+
+	removeRoom(roomUUID)
+}
+
+// removeRoom removes a room whose peers were already disconnected, and every
+// per-room structure. listLock must be held
+func removeRoom(roomUUID string) {
+	r := conferences[roomUUID]
+	if r.recording != nil {
+		r.finishRecording(roomUUID)
+	}
+
+	for _, t := range trackLocals[roomUUID] {
+		if t.orphaned != nil {
+			t.orphaned.Stop()
+		}
+	}
+
+	delete(conferences, roomUUID)
+	delete(peerConnections, roomUUID)
+	delete(trackLocals, roomUUID)
+}
+
+func newRoom(config RoomConfig) *room {
+	now := time.Now()
+	return &room{
+		config:     config,
+		createdAt:  now,
+		emptySince: now,
+		events:     newAuditLog(),
+		history:    newStatsHistory(),
+		speaker:    newSpeakerDetector(),
+		observers:  make(map[*observer]bool),
+
+		consentPolicy: defaultConsentPolicy,
+	}
 }
 
 func Handler(w http.ResponseWriter, r *http.Request) {
+	if IsDraining() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
 
 	vars := mux.Vars(r)
 	roomUUID, ok := vars["uuid"]
@@ -84,19 +708,60 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Идентификатор комнаты отсутствует")
 	}
 
+	peerID := uuid.NewString()
+
+	// The caller's traceparent, if any, is the parent of the connection span
+	traceAttributes := []tracing.Attribute{tracing.String("room.id", roomUUID), tracing.String("peer.id", peerID)}
+	trace, connectionSpan := tracing.Start(tracing.Extract(r.Context(), r.Header), "websocket.connection", traceAttributes...)
+	defer connectionSpan.End()
+
 	// Upgrade HTTP request to Websocket
-	unsafeConn, err := upgrader.Upgrade(w, r, nil)
+	_, upgradeSpan := tracing.Start(trace, "websocket.upgrade", traceAttributes...)
+	unsafeConn, err := upgrader.Upgrade(w, r, http.Header{
+		"X-Server-Version": {Version},
+		"X-Server-Time":    {time.Now().UTC().Format(time.RFC3339Nano)},
+		"X-Connection-Id":  {peerID},
+	})
+	upgradeSpan.SetError(err)
+	upgradeSpan.End()
 	if err != nil {
 		log.Print("upgrade:", err)
 		return
 	}
-	c := &threadSafeWriter{unsafeConn, sync.Mutex{}}
+	c := &threadSafeWriter{Conn: unsafeConn}
 
-	go func() {
-		for range time.NewTicker(time.Second * 3).C {
-			dispatchKeyFrame(roomUUID)
-		}
-	}()
+	tenant := Tenant(r)
+	identity, _ := auth.FromContext(r.Context())
+
+	listLock.RLock()
+	joinedRoom, exist := conferences[roomUUID]
+
+	// The room of another tenant is as good as unknown
+	foreign := foreignRoom(roomUUID, tenant)
+	isHost := exist && !foreign && joinedRoom.isHost(r.URL.Query().Get("host"))
+	joinErr := joinError(roomUUID, isHost)
+	roomConfig := DefaultRoomConfig()
+	if foreign {
+		joinErr = ErrRoomNotFound
+	} else if identity.Room != "" && identity.Room != roomUUID {
+		joinErr = ErrTokenForOtherRoom
+	} else if exist {
+		roomConfig = joinedRoom.config
+	}
+	listLock.RUnlock()
+
+	reconnectToken := uuid.NewString()
+	if err := sendWelcome(c, peerID, reconnectToken, roomConfig.roomICEServers()); err != nil {
+		log.Println(err)
+	}
+
+	// Rooms are only created through AddRoomUUID, so the room limits and
+	// creation quotas can't be bypassed by joining an unknown ID
+	if joinErr != nil {
+		connectionSpan.SetError(joinErr)
+		disconnect(c, joinErr)
+		return
+	}
 
 	// When this frame returns close the Websocket
 	defer func(c *threadSafeWriter) {
@@ -107,7 +772,16 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	}(c) //nolint
 
 	// Create new PeerConnection
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	_, createSpan := tracing.Start(trace, "webrtc.peerconnection_create", traceAttributes...)
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{
+		ICEServers:           roomConfig.roomICEServers(),
+		ICETransportPolicy:   iceTransportPolicy,
+		ICECandidatePoolSize: iceCandidatePoolSize,
+		BundlePolicy:         bundlePolicy,
+		RTCPMuxPolicy:        rtcpMuxPolicy,
+	})
+	createSpan.SetError(err)
+	createSpan.End()
 	if err != nil {
 		log.Print(err)
 		return
@@ -121,11 +795,21 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		}
 	}(peerConnection) //nolint
 
-	// Accept one audio and one video track incoming
+	// Accept one audio and one video track incoming, as far as the room allows them
 	for _, typ := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
-		if _, err := peerConnection.AddTransceiverFromKind(typ, webrtc.RTPTransceiverInit{
+		if !roomConfig.allows(typ) {
+			continue
+		}
+
+		transceiver, err := peerConnection.AddTransceiverFromKind(typ, webrtc.RTPTransceiverInit{
 			Direction: webrtc.RTPTransceiverDirectionRecvonly,
-		}); err != nil {
+		})
+		if err != nil {
+			log.Print(err)
+			return
+		}
+
+		if err := preferCodecs(transceiver); err != nil {
 			log.Print(err)
 			return
 		}
@@ -133,16 +817,99 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 	// Add our new PeerConnection to global list
 	listLock.Lock()
-	peerConnections[roomUUID] = append(peerConnections[roomUUID], peerConnectionState{peerConnection, c})
+	joinedRoom, exist = conferences[roomUUID]
+	if !exist || foreignRoom(roomUUID, tenant) {
+		// The room was closed, or its ID reused by another tenant, while
+		// the connection was being set up
+		listLock.Unlock()
+		disconnect(c, ErrRoomNotFound)
+		return
+	}
+
+	// A single use token is spent by the join going through, not the attempt
+	if err := auth.Spend(r.Context()); err != nil {
+		listLock.Unlock()
+		connectionSpan.SetError(err)
+		disconnect(c, err)
+		return
+	}
+
+	name := displayName(r.URL.Query().Get("name"))
+	if name == "" {
+		name = displayName(identity.Name)
+	}
+
+	// Invalid metadata given on join is dropped, set_metadata reports what is wrong
+	metadata, _ := parseMetadata([]byte(r.URL.Query().Get("metadata")))
+
+	peerState := &peerConnectionState{
+		id:             peerID,
+		name:           name,
+		metadata:       metadata,
+		identity:       identity,
+		isHost:         isHost,
+		reconnectToken: reconnectToken,
+		resumeToken:    r.URL.Query().Get("reconnect"),
+		joinedAt:       time.Now(),
+		audioOnly:      r.URL.Query().Get("audioOnly") == "true",
+		peerConnection: peerConnection,
+		websocket:      c,
+		trace:          trace,
+	}
+	peerState.touch()
+	c.SetPongHandler(func(string) error {
+		peerState.touch()
+		return nil
+	})
+	peerConnections[roomUUID] = append(peerConnections[roomUUID], peerState)
+	joinedRoom.emptySince = time.Time{}
+	joinedRoom.unclaimed = false
+	if data, err := json.Marshal(peerState.participant()); err == nil {
+		notifyObservers(roomUUID, websocketMessage{Event: "participant_joined", Data: string(data)})
+	}
+	updateP2P(roomUUID)
+	requestConsent(roomUUID, joinedRoom, peerState)
+	applyConsent(roomUUID)
 	listLock.Unlock()
 
+	recordEvent(roomUUID, AuditJoin, peerID)
+	logRoomEvent(roomUUID, AuditJoin, peerState)
+	defer recordEvent(roomUUID, AuditLeave, peerID)
+	defer logRoomEvent(roomUUID, AuditLeave, peerState)
+	defer func() {
+		log.Printf("peer %s left room %s: %d bytes in, %d bytes out",
+			peerID, roomUUID, peerState.bytesIn.Load(), peerState.bytesOut.Load())
+	}()
+	defer joinedRoom.speaker.forget(peerID)
+	defer func() {
+		listLock.Lock()
+		if peerState.offerSpan != nil {
+			peerState.traceAnswer(errPeerLeft)
+		}
+		listLock.Unlock()
+	}()
+
+	// firstMediaSpan lasts until the peer's first media packet, or the whole
+	// connection when it only watches
+	_, firstMediaSpan := tracing.Start(trace, "media.first_packet", traceAttributes...)
+	var firstMedia sync.Once
+	defer func() {
+		firstMediaSpan.SetAttributes(tracing.String("media.received", "false"))
+		firstMediaSpan.End()
+	}()
+
 	// Trickle ICE. Emit server candidate to client
 	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
 		if i == nil {
 			return
 		}
 
-		candidateString, err := json.Marshal(i.ToJSON())
+		candidate := i.ToJSON()
+		if !allowCandidate(candidate.Candidate) {
+			return
+		}
+
+		candidateString, err := json.Marshal(candidate)
 		if err != nil {
 			log.Println(err)
 			return
@@ -152,83 +919,349 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			Event: "candidate",
 			Data:  string(candidateString),
 		}); writeErr != nil {
-			log.Println(writeErr)
+			logSampled(writeErr)
 		}
 	})
 
+	// Peers that never finish the handshake mustn't hold their slot forever
+	connectTimer := watchConnect(c, peerConnection, peerID)
+	if connectTimer != nil {
+		defer connectTimer.Stop()
+	}
+
 	// If PeerConnection is closed remove it from global list
 	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
 		switch p {
+		case webrtc.PeerConnectionStateConnected:
+			if connectTimer != nil {
+				connectTimer.Stop()
+			}
 		case webrtc.PeerConnectionStateFailed:
 			if err := peerConnection.Close(); err != nil {
 				log.Print(err)
 			}
 		case webrtc.PeerConnectionStateClosed:
-			signalPeerConnections(roomUUID)
+			requestSignal(roomUUID)
 		default:
 		}
 	})
 
-	peerConnection.OnTrack(func(t *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
-		// Create a track to fan out our incoming video to all peers
-		trackLocal := addTrack(t, roomUUID)
+	peerConnection.OnTrack(func(t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		// Create a track to fan out our incoming media to all peers
+		trackLocal, err := addTrack(newLocalTrack(t, receiver, peerConnection, peerID), roomUUID)
+		if err != nil {
+			log.Println(err)
+			rejectTrack(c, t, err)
+			return
+		}
 		defer removeTrack(trackLocal, roomUUID)
 
+		// In active speaker rooms audio levels decide whose video is forwarded
+		audioLevelID := uint8(0)
+		if roomConfig.ActiveSpeakerOnly && t.Kind() == webrtc.RTPCodecTypeAudio {
+			for _, ext := range receiver.GetParameters().HeaderExtensions {
+				if ext.URI == sdp.AudioLevelURI {
+					audioLevelID = uint8(ext.ID)
+				}
+			}
+		}
+
+		var jitter *jitterBuffer
+		if jitterBufferDelay > 0 {
+			jitter = newJitterBuffer(jitterBufferDelay, trackLocal.writeRTP)
+			defer jitter.close()
+		}
+
 		buf := make([]byte, 1500)
+		packet := &rtp.Packet{}
+		audioLevel := &rtp.AudioLevelExtension{}
 		for {
 			i, _, err := t.Read(buf)
 			if err != nil {
 				return
 			}
 
-			if _, err = trackLocal.Write(buf[:i]); err != nil {
-				return
+			peerState.bytesIn.Add(uint64(i))
+			firstMedia.Do(func() {
+				firstMediaSpan.SetAttributes(tracing.String("media.received", "true"), tracing.String("media.kind", t.Kind().String()))
+				firstMediaSpan.End()
+			})
+
+			if err = packet.Unmarshal(buf[:i]); err != nil {
+				continue
+			}
+
+			if audioLevelID != 0 {
+				if ext := packet.GetExtension(audioLevelID); ext != nil && audioLevel.Unmarshal(ext) == nil {
+					if speaker, changed := joinedRoom.speaker.observe(peerID, audioLevel.Level); changed {
+						go switchActiveSpeaker(roomUUID, speaker)
+					}
+				}
+			}
+
+			if jitter == nil {
+				trackLocal.writeRTP(packet)
+				continue
 			}
+
+			// The buffer keeps the packet, the next one needs its own memory
+			jitter.push(packet)
+			buf, packet = make([]byte, 1500), &rtp.Packet{}
 		}
 	})
 
 	// Signal for the new PeerConnection
-	signalPeerConnections(roomUUID)
+	requestSignal(roomUUID)
+
+	var limiter *tokenBucket
+	if maxMessagesPerSec > 0 {
+		limiter = newTokenBucket(maxMessagesPerSec)
+	}
+	throttled := false
 
 	message := &websocketMessage{}
 	for {
 		_, raw, err := c.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+			peerState.graceful.Store(logDisconnect(err))
+			return
+		}
+		peerState.touch()
+
+		// Messages over the limit are dropped, the client is told once per burst
+		if !limiter.allow() {
+			if !throttled {
+				throttled = true
+				log.Printf("peer %s exceeds %d messages/s, dropping", peerID, maxMessagesPerSec)
+				sendError(c, "rate limit exceeded")
 			}
+			continue
+		}
+		throttled = false
+
+		if err := json.Unmarshal(raw, &message); err != nil {
 			log.Println(err)
 			return
-		} else if err := json.Unmarshal(raw, &message); err != nil {
-			log.Println(err)
+		}
+
+		if !handleMessage(c, peerState, roomUUID, message) {
 			return
 		}
+	}
+}
 
-		switch message.Event {
-		case "candidate":
-			candidate := webrtc.ICECandidateInit{}
-			if err := json.Unmarshal([]byte(message.Data), &candidate); err != nil {
-				log.Println(err)
-				return
-			}
+// handleMessage acts on a signaling message from the peer. It returns false
+// when the connection must be closed
+func handleMessage(conn signalConn, peer *peerConnectionState, roomUUID string, message *websocketMessage) bool {
+	switch message.Event {
+	case "hello":
+		return handleHello(conn, message)
+	case "candidate":
+		candidate := webrtc.ICECandidateInit{}
+		if err := json.Unmarshal([]byte(message.Data), &candidate); err != nil {
+			log.Println(err)
+			return false
+		}
 
-			if err := peerConnection.AddICECandidate(candidate); err != nil {
-				log.Println(err)
-				return
+		candidate.Candidate = normalizeCandidate(candidate.Candidate)
+		if !allowCandidate(candidate.Candidate) {
+			return true
+		}
+
+		if err := peer.addCandidate(candidate); err != nil {
+			log.Println(err)
+			return false
+		}
+	case "answer":
+		answer := webrtc.SessionDescription{}
+		if err := json.Unmarshal([]byte(message.Data), &answer); err != nil {
+			log.Println(err)
+			return false
+		}
+
+		// An answer when no offer of ours is pending is a stale one
+		if peer.peerConnection.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+			log.Println("answer ignored, no pending offer")
+			return true
+		}
+
+		if err := checkBundle(answer); err != nil {
+			log.Println(err)
+			return false
+		}
+
+		if err := peer.peerConnection.SetRemoteDescription(answer); err != nil {
+			log.Println(err)
+			return false
+		}
+
+		if err := peer.flushCandidates(); err != nil {
+			log.Println(err)
+			return false
+		}
+
+		listLock.Lock()
+		peer.answered()
+		listLock.Unlock()
+	case "mute":
+		muted := false
+		if err := json.Unmarshal([]byte(message.Data), &muted); err != nil {
+			log.Println(err)
+			return false
+		}
+
+		listLock.Lock()
+		if r, ok := conferences[roomUUID]; ok && peer.muted != muted {
+			typ := AuditUnmute
+			if muted {
+				typ = AuditMute
 			}
-		case "answer":
-			answer := webrtc.SessionDescription{}
-			if err := json.Unmarshal([]byte(message.Data), &answer); err != nil {
+			r.events.append(typ, peer.id)
+		}
+		peer.muted = muted
+		listLock.Unlock()
+	case "refresh":
+		// A subscriber that can't decode asks for a keyframe, of one track or all of them
+		trackID := ""
+		if message.Data != "" {
+			if err := json.Unmarshal([]byte(message.Data), &trackID); err != nil {
 				log.Println(err)
-				return
+				return false
 			}
+		}
 
-			if err := peerConnection.SetRemoteDescription(answer); err != nil {
-				log.Println(err)
-				return
-			}
+		if !requestKeyFrame(roomUUID, trackID) {
+			sendError(conn, "unknown track")
+		}
+	case "visible_tracks":
+		return setVisibleTracks(conn, roomUUID, peer, message)
+	case "set_receive_mode":
+		mode := ""
+		if err := json.Unmarshal([]byte(message.Data), &mode); err != nil {
+			log.Println(err)
+			return false
+		}
+
+		if mode != receiveAll && mode != receiveAudioOnly {
+			sendError(conn, "unknown receive mode")
+			return true
+		}
+
+		listLock.Lock()
+		peer.audioOnly = mode == receiveAudioOnly
+		listLock.Unlock()
+
+		requestSignal(roomUUID)
+	case "offer":
+		offer := webrtc.SessionDescription{}
+		if err := json.Unmarshal([]byte(message.Data), &offer); err != nil {
+			log.Println(err)
+			return false
+		}
+
+		if err := answerRemoteOffer(peer.peerConnection, conn, offer); err != nil {
+			log.Println(err)
+			return false
+		}
+
+		if err := peer.flushCandidates(); err != nil {
+			log.Println(err)
+			return false
+		}
+	case "raise_hand":
+		setHandRaised(roomUUID, peer, true)
+	case "lower_hand":
+		setHandRaised(roomUUID, peer, false)
+	case "e2ee_key":
+		relayE2EEKey(conn, roomUUID, peer, message)
+	case "consent":
+		setRecordingConsent(conn, roomUUID, peer, message)
+	case "set_metadata":
+		setMetadata(conn, roomUUID, peer, message)
+	case "quality_report":
+		handleQualityReport(conn, peer, message)
+	case "p2p_signal":
+		if !relayP2PSignal(roomUUID, peer, message) {
+			sendError(conn, "direct connection not available")
 		}
 	}
+
+	return true
+}
+
+// sendWelcome gives the client what it needs to know about the server right after connecting
+func sendWelcome(c *threadSafeWriter, connectionID, reconnectToken string, servers []webrtc.ICEServer) error {
+	data, err := json.Marshal(welcomeMessage{
+		ServerTime:           time.Now().UTC(),
+		Version:              Version,
+		ConnectionID:         connectionID,
+		ICEServers:           servers,
+		ReconnectToken:       reconnectToken,
+		ICECandidatePoolSize: iceCandidatePoolSize,
+		BundlePolicy:         bundlePolicy.String(),
+		RTCPMuxPolicy:        rtcpMuxPolicy.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.WriteJSON(&websocketMessage{
+		Event: "welcome",
+		Data:  string(data),
+	})
+}
+
+func closePeerConnection(peerConnection *webrtc.PeerConnection) {
+	if err := peerConnection.Close(); err != nil {
+		log.Println(err)
+	}
+}
+
+// answerRemoteOffer handles renegotiation started by the client. If the
+// server's own offer is still pending the two collided. Pion has no rollback
+// out of have-local-offer, so the server can't be the polite peer: it keeps
+// its offer and ignores the client's one. A client following perfect
+// negotiation rolls its offer back, answers ours and then offers again
+func answerRemoteOffer(peerConnection *webrtc.PeerConnection, c signalConn, offer webrtc.SessionDescription) error {
+	if err := checkBundle(offer); err != nil {
+		return err
+	}
+
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	if peerConnection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		log.Println("offer ignored, it collided with ours")
+		return nil
+	}
+
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+
+	if err = peerConnection.SetLocalDescription(answer); err != nil {
+		return err
+	}
+
+	sent, err := withBandwidthLimit(answer)
+	if err != nil {
+		return err
+	}
+
+	answerString, err := json.Marshal(sent)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteJSON(&websocketMessage{
+		Event: "answer",
+		Data:  string(answerString),
+	})
 }
 
 // signalPeerConnections updates each PeerConnection so that it is getting all the expected media tracks
@@ -236,70 +1269,42 @@ func signalPeerConnections(roomUUID string) {
 	listLock.Lock()
 	defer func() {
 		listLock.Unlock()
-		dispatchKeyFrame(roomUUID)
+		if keyFrameOnSignal(keyFrameMode) {
+			dispatchKeyFrame(roomUUID)
+		}
 	}()
 
 	attemptSync := func() (tryAgain bool) {
 		for i := range peerConnections[roomUUID] {
-			if peerConnections[roomUUID][i].peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
-				peerConnections[roomUUID] = append(peerConnections[roomUUID][:i], peerConnections[roomUUID][i+1:]...)
-				return true // We modified the slice, start from the beginning
+			// Peers whose websocket failed can't be signaled anymore, drop them now
+			// rather than when their read loop notices
+			if peerConnections[roomUUID][i].websocket.broken() {
+				go closePeerConnection(peerConnections[roomUUID][i].peerConnection)
 			}
 
-			// map of sender we already are seanding, so we don't double send
-			existingSenders := map[string]bool{}
-
-			for _, sender := range peerConnections[roomUUID][i].peerConnection.GetSenders() {
-				if sender.Track() == nil {
-					continue
+			if peerConnections[roomUUID][i].websocket.broken() ||
+				peerConnections[roomUUID][i].peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+				for _, track := range trackLocals[roomUUID] {
+					track.unsubscribe(peerConnections[roomUUID][i].id)
 				}
-
-				existingSenders[sender.Track().ID()] = true
-
-				// If we have a RTPSender that doesn't map to a existing track remove and signal
-				if _, ok := trackLocals[roomUUID][sender.Track().ID()]; !ok {
-					if err := peerConnections[roomUUID][i].peerConnection.RemoveTrack(sender); err != nil {
-						return true
-					}
+				left := participantLeft{
+					PeerID:   peerConnections[roomUUID][i].id,
+					Graceful: peerConnections[roomUUID][i].graceful.Load(),
 				}
-			}
-
-			// Don't receive videos we are sending, make sure we don't have loopback
-			for _, receiver := range peerConnections[roomUUID][i].peerConnection.GetReceivers() {
-				if receiver.Track() == nil {
-					continue
+				if data, err := json.Marshal(left); err == nil {
+					notifyObservers(roomUUID, websocketMessage{Event: "participant_left", Data: string(data)})
 				}
-
-				existingSenders[receiver.Track().ID()] = true
-			}
-
-			// Add all track we aren't sending yet to the PeerConnection
-			for trackID := range trackLocals[roomUUID] {
-				if _, ok := existingSenders[trackID]; !ok {
-					if _, err := peerConnections[roomUUID][i].peerConnection.AddTrack(trackLocals[roomUUID][trackID]); err != nil {
-						return true
-					}
+				lowerHandOnLeave(roomUUID, peerConnections[roomUUID][i].id)
+				peerConnections[roomUUID] = append(peerConnections[roomUUID][:i], peerConnections[roomUUID][i+1:]...)
+				if r, ok := conferences[roomUUID]; ok && len(peerConnections[roomUUID]) == 0 {
+					r.emptySince = time.Now()
 				}
+				updateP2P(roomUUID)
+				applyConsent(roomUUID)
+				return true // We modified the slice, start from the beginning
 			}
 
-			offer, err := peerConnections[roomUUID][i].peerConnection.CreateOffer(nil)
-			if err != nil {
-				return true
-			}
-
-			if err = peerConnections[roomUUID][i].peerConnection.SetLocalDescription(offer); err != nil {
-				return true
-			}
-
-			offerString, err := json.Marshal(offer)
-			if err != nil {
-				return true
-			}
-
-			if err = peerConnections[roomUUID][i].websocket.WriteJSON(&websocketMessage{
-				Event: "offer",
-				Data:  string(offerString),
-			}); err != nil {
+			if syncPeer(roomUUID, peerConnections[roomUUID][i]) {
 				return true
 			}
 		}
@@ -324,37 +1329,251 @@ func signalPeerConnections(roomUUID string) {
 	}
 }
 
-// Add to list of tracks and fire renegotation for all PeerConnections
-func addTrack(t *webrtc.TrackRemote, roomUUID string) *webrtc.TrackLocalStaticRTP {
+// syncPeer makes the peer's senders match the tracks it should receive and
+// sends it a fresh offer. It reports true when the room must be synced again.
+// listLock must be held
+func syncPeer(roomUUID string, p *peerConnectionState) (tryAgain bool) {
+	if p.offerThrottled(roomUUID) {
+		return false
+	}
+
+	// map of sender we already are seanding, so we don't double send
+	existingSenders := map[string]bool{}
+	forwarded := p.forwardedVideo(roomUUID)
+
+	for _, sender := range p.peerConnection.GetSenders() {
+		if sender.Track() == nil {
+			continue
+		}
+
+		existingSenders[sender.Track().ID()] = true
+
+		// If we have a RTPSender that doesn't map to a existing track remove and signal
+		if _, ok := trackLocals[roomUUID][sender.Track().ID()]; !ok {
+			if err := p.peerConnection.RemoveTrack(sender); err != nil {
+				logSampled("signal: remove track:", err)
+				return true
+			}
+		} else if !p.wants(trackLocals[roomUUID][sender.Track().ID()], forwarded) {
+			// Not wanted, or a track the peer took over after reconnecting
+			trackLocals[roomUUID][sender.Track().ID()].unsubscribe(p.id)
+			if err := p.peerConnection.RemoveTrack(sender); err != nil {
+				logSampled("signal: remove track:", err)
+				return true
+			}
+		}
+	}
+
+	// Add all track we aren't sending yet to the PeerConnection. A
+	// peer never gets its own tracks back, whatever their IDs
+	for trackID := range trackLocals[roomUUID] {
+		if !p.wants(trackLocals[roomUUID][trackID], forwarded) {
+			continue
+		}
+
+		if _, ok := existingSenders[trackID]; !ok {
+			subscription, err := trackLocals[roomUUID][trackID].subscribe(p)
+			if err != nil {
+				logSampled("signal: subscribe:", err)
+				return true
+			}
+
+			if _, err := p.peerConnection.AddTrack(subscription); err != nil {
+				logSampled("signal: add track:", err)
+				trackLocals[roomUUID][trackID].unsubscribe(p.id)
+				return true
+			}
+
+			if r, exist := conferences[roomUUID]; exist && r.config.ActiveSpeakerOnly &&
+				subscription.Kind() == webrtc.RTPCodecTypeVideo && trackLocals[roomUUID][trackID].peerID != r.speaker.active() {
+				subscription.pause()
+			}
+
+			if trackLocals[roomUUID][trackID].isHeld() {
+				subscription.pause()
+			}
+		}
+	}
+
+	offer, err := p.peerConnection.CreateOffer(nil)
+	if err != nil {
+		logSampled("signal: create offer:", err)
+		p.negotiationFailed()
+		return true
+	}
+
+	if err = p.peerConnection.SetLocalDescription(offer); err != nil {
+		logSampled("signal: set local description:", err)
+		p.negotiationFailed()
+		return true
+	}
+
+	sent, err := withBandwidthLimit(offer)
+	if err != nil {
+		logSampled("signal: bandwidth limit:", err)
+		p.negotiationFailed()
+		return true
+	}
+	p.negotiationFailures = 0
+
+	offerString, err := json.Marshal(sent)
+	if err != nil {
+		return true
+	}
+
+	if err = p.websocket.WriteJSON(&websocketMessage{
+		Event: "offer",
+		Data:  string(offerString),
+	}); err != nil {
+		logSampled("signal: send offer:", err)
+		return true
+	}
+	p.lastOfferAt = time.Now()
+	p.watchOffer(roomUUID)
+	p.traceOffer(roomUUID)
+
+	return false
+}
 
+// Add to list of tracks and fire renegotation for all PeerConnections
+func addTrack(track *localTrack, roomUUID string) (*localTrack, error) {
 	listLock.Lock()
-	defer func() {
+
+	r, exist := conferences[roomUUID]
+	if !exist {
 		listLock.Unlock()
-		signalPeerConnections(roomUUID)
-	}()
+		return nil, ErrRoomNotFound
+	}
 
-	// Create a new TrackLocal with the same codec as our incoming
-	trackLocal, err := webrtc.NewTrackLocalStaticRTP(t.Codec().RTPCodecCapability, t.ID(), t.StreamID())
-	if err != nil {
-		panic(err)
+	if !r.config.allows(track.Kind()) {
+		listLock.Unlock()
+		return nil, ErrTrackKindNotAllowed
+	}
+
+	publisher := findPeer(roomUUID, track.peerID)
+	if publisher != nil {
+		track.ownerSubject = publisher.identity.Subject
+		track.reconnectToken = publisher.reconnectToken
+	}
+
+	// A publisher coming back within the grace period takes its old track over
+	if orphan := findOrphan(roomUUID, track.remoteID, publisher); orphan != nil && adoptTrack(orphan, track, roomUUID) {
+		notifyTrack(roomUUID, "track_added", orphan)
+		listLock.Unlock()
+		requestSignal(roomUUID)
+
+		return orphan, nil
+	}
+
+	// The same peer publishing the same ID twice would replace its own track
+	if _, exist := trackLocals[roomUUID][track.ID()]; exist {
+		listLock.Unlock()
+		log.Printf("peer %s published track %s twice in room %s", track.peerID, track.remoteID, roomUUID)
+		return nil, ErrDuplicateTrack
+	}
+
+	// Server owned tracks, like announcements, have no publisher to count
+	if track.peerID != "" && publishersFull(roomUUID, track.peerID) {
+		listLock.Unlock()
+		return nil, ErrTooManyPublishers
 	}
 
-	if _, exist := trackLocals[roomUUID]; !exist {
-		trackLocals[roomUUID] = make(map[string]*webrtc.TrackLocalStaticRTP)
+	if tracksFull(roomUUID) {
+		listLock.Unlock()
+		return nil, ErrTooManyTracks
+	}
+
+	// Until somebody speaks the first video publisher holds the floor
+	if r.config.ActiveSpeakerOnly && track.Kind() == webrtc.RTPCodecTypeVideo {
+		r.speaker.setActiveIfNone(track.peerID)
+	}
+
+	// Tracks published while the host paused the peer start held
+	if publisher != nil && publisher.paused {
+		track.hold()
+	}
+
+	trackLocals[roomUUID][track.ID()] = track
+	publishedCodecs.inc(track.codec.MimeType)
+	notifyTrack(roomUUID, "track_added", track)
+
+	if r.recording != nil {
+		r.recording.start(track)
 	}
 
-	trackLocals[roomUUID][t.ID()] = trackLocal
-	return trackLocal
+	listLock.Unlock()
+	requestSignal(roomUUID)
+
+	return track, nil
+}
+
+// rejectTrack tells the publisher its track won't be forwarded. Peers over
+// the room's publisher limit get publish_denied, they can still watch
+func rejectTrack(c signalConn, t *webrtc.TrackRemote, reason error) {
+	event := "track_rejected"
+	if errors.Is(reason, ErrTooManyPublishers) {
+		event = "publish_denied"
+	}
+
+	data, err := json.Marshal(trackEvent{
+		TrackID: t.ID(),
+		Kind:    t.Kind().String(),
+		Reason:  reason.Error(),
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := c.WriteJSON(&websocketMessage{
+		Event: event,
+		Data:  string(data),
+	}); err != nil {
+		log.Println(err)
+	}
 }
 
 // Remove from list of tracks and fire renegotation for all PeerConnections
-func removeTrack(t *webrtc.TrackLocalStaticRTP, roomUUID string) {
+func removeTrack(t *localTrack, roomUUID string) {
 	listLock.Lock()
 
-	defer func() {
+	// A newer track with the same ID replaced it
+	if trackLocals[roomUUID][t.ID()] != t {
 		listLock.Unlock()
-		signalPeerConnections(roomUUID)
-	}()
+		return
+	}
 
+	if publisherGrace > 0 {
+		orphanTrack(t, roomUUID)
+		listLock.Unlock()
+		return
+	}
+
+	dropTrack(t, roomUUID)
+
+	listLock.Unlock()
+	requestSignal(roomUUID)
+}
+
+// dropTrack removes the track from the room. listLock must be held
+func dropTrack(t *localTrack, roomUUID string) {
 	delete(trackLocals[roomUUID], t.ID())
+	notifyTrack(roomUUID, "track_removed", t)
+	t.stopRecording()
+}
+
+// notifyTrack tells every peer of the room about a published or removed track
+// ahead of the renegotiation. listLock must be held
+func notifyTrack(roomUUID, event string, t *localTrack) {
+	data, err := json.Marshal(trackEvent{
+		TrackID: t.ID(),
+		Kind:    t.Kind().String(),
+		PeerID:  t.peerID,
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	broadcast(roomUUID, websocketMessage{Event: event, Data: string(data)}, nil)
 }
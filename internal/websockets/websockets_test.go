@@ -0,0 +1,1158 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/b4o4/conference-backend/internal/auth"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/pion/rtcp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer serves the join endpoint the way the router mounts it
+func newTestServer(t *testing.T) *httptest.Server {
+	return newTestServerAs(t, auth.Identity{})
+}
+
+// newTestServerAs serves the join endpoint to callers authenticated as
+// identity, single use tokens included
+func newTestServerAs(t *testing.T, identity auth.Identity) *httptest.Server {
+	t.Helper()
+
+	authenticated := func(next http.HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth.SingleUse(next).ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), identity)))
+		})
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/websocket/{uuid}/join", authenticated(Handler))
+	router.Handle("/websocket/multiplex", authenticated(MultiplexHandler))
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dialRoom opens the websocket of a join to roomUUID
+func dialRoom(t *testing.T, srv *httptest.Server, roomUUID string) *websocket.Conn {
+	t.Helper()
+	return dialRoomWith(t, srv, roomUUID, nil)
+}
+
+// dialRoomWith opens the websocket of a join to roomUUID with the query
+// parameters of the join, e.g. host
+func dialRoomWith(t *testing.T, srv *httptest.Server, roomUUID string, query url.Values) *websocket.Conn {
+	t.Helper()
+
+	target := "ws" + strings.TrimPrefix(srv.URL, "http") + "/websocket/" + roomUUID + "/join"
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ws.Close() })
+	return ws
+}
+
+// joinRoom dials roomUUID and returns the websocket with the welcome it got
+func joinRoom(t *testing.T, srv *httptest.Server, roomUUID string) (*websocket.Conn, welcomeMessage) {
+	t.Helper()
+
+	ws := dialRoom(t, srv, roomUUID)
+	message, ok := nextEvent(t, ws, "welcome", 5*time.Second)
+	if !ok {
+		t.Fatal("no welcome")
+	}
+
+	welcome := welcomeMessage{}
+	if err := json.Unmarshal([]byte(message.Data), &welcome); err != nil {
+		t.Fatal(err)
+	}
+	return ws, welcome
+}
+
+// newTestTrack is a track of codec published by peerID, without a publisher behind it
+func newTestTrack(peerID, trackID string, codec webrtc.RTPCodecCapability) *localTrack {
+	return &localTrack{
+		id:          trackKey(peerID, trackID),
+		remoteID:    trackID,
+		streamID:    peerID,
+		codec:       codec,
+		payloadType: 96,
+		codecs:      map[webrtc.PayloadType]webrtc.RTPCodecCapability{96: codec},
+		peerID:      peerID,
+		subscribers: make(map[string]*downTrack),
+	}
+}
+
+// addTestTrack registers a newTestTrack in the room, bypassing the checks of addTrack
+func addTestTrack(t *testing.T, roomUUID, peerID, trackID string, codec webrtc.RTPCodecCapability) *localTrack {
+	t.Helper()
+
+	track := newTestTrack(peerID, trackID, codec)
+
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	if _, ok := trackLocals[roomUUID]; !ok {
+		t.Fatalf("room %s is not registered", roomUUID)
+	}
+	trackLocals[roomUUID][track.id] = track
+	if r := conferences[roomUUID]; r.recording != nil {
+		r.recording.start(track)
+	}
+	return track
+}
+
+// bindTestSubscriber subscribes peerID to track and binds the downTrack the
+// way a negotiated PeerConnection would, returning what is sent to the peer
+func bindTestSubscriber(t *testing.T, track *localTrack, peerID string) chan queuedPacket {
+	t.Helper()
+
+	negotiated := make([]webrtc.RTPCodecParameters, 0, len(track.codecs))
+	for payloadType, codec := range track.codecs {
+		negotiated = append(negotiated, webrtc.RTPCodecParameters{RTPCodecCapability: codec, PayloadType: payloadType})
+	}
+	return bindTestSubscriberWith(t, track, peerID, negotiated)
+}
+
+// bindTestSubscriberWith is bindTestSubscriber for a peer that negotiated
+// the given codecs
+func bindTestSubscriberWith(t *testing.T, track *localTrack, peerID string, negotiated []webrtc.RTPCodecParameters) chan queuedPacket {
+	t.Helper()
+
+	d, err := track.subscribe(&peerConnectionState{id: peerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue := make(chan queuedPacket, downTrackQueueSize)
+	d.mu.Lock()
+	d.queue = queue
+	d.payloadTypes = track.payloadTypesFor(negotiated)
+	d.mu.Unlock()
+	return queue
+}
+
+// bindTestStream subscribes the peer to track and has the downTrack write to
+// stream from its own goroutine, as once bound to a PeerConnection
+func bindTestStream(t *testing.T, track *localTrack, subscriber *peerConnectionState, stream webrtc.TrackLocalWriter) *downTrack {
+	t.Helper()
+
+	d, err := track.subscribe(subscriber)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue := make(chan queuedPacket, downTrackQueueSize)
+	go d.writeLoop(queue, stream)
+
+	d.mu.Lock()
+	d.queue = queue
+	d.payloadTypes = map[webrtc.PayloadType]webrtc.PayloadType{track.payloadType: track.payloadType}
+	d.mu.Unlock()
+	t.Cleanup(func() { _ = d.Unbind(nil) })
+	return d
+}
+
+// forwarded empties queue and returns how many packets it held
+func forwarded(queue chan queuedPacket) int {
+	n := 0
+	for {
+		select {
+		case <-queue:
+			n++
+		default:
+			return n
+		}
+	}
+}
+
+// waitForPeers waits until the room holds n peers
+func waitForPeers(t *testing.T, roomUUID string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		listLock.RLock()
+		joined := len(peerConnections[roomUUID])
+		listLock.RUnlock()
+
+		if joined == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d peers in the room, want %d", joined, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// nextEvent skips messages until one of the event arrives, failing after timeout
+func nextEvent(t *testing.T, ws *websocket.Conn, event string, timeout time.Duration) (websocketMessage, bool) {
+	t.Helper()
+
+	_ = ws.SetReadDeadline(time.Now().Add(timeout))
+	defer ws.SetReadDeadline(time.Time{}) //nolint
+
+	for {
+		message := websocketMessage{}
+		if err := ws.ReadJSON(&message); err != nil {
+			return websocketMessage{}, false
+		}
+		if message.Event == event {
+			return message, true
+		}
+	}
+}
+
+// nextOffer waits for the next offer the server sends
+func nextOffer(t *testing.T, ws *websocket.Conn) webrtc.SessionDescription {
+	t.Helper()
+
+	message, ok := nextEvent(t, ws, "offer", 5*time.Second)
+	if !ok {
+		t.Fatal("no offer")
+	}
+
+	offer := webrtc.SessionDescription{}
+	if err := json.Unmarshal([]byte(message.Data), &offer); err != nil {
+		t.Fatal(err)
+	}
+	return offer
+}
+
+// parseSDP parses the SDP of a session description
+func parseSDP(t *testing.T, desc webrtc.SessionDescription) *sdp.SessionDescription {
+	t.Helper()
+
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(desc.SDP)); err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+// sendingKinds counts the media sections of an offer the server sends on, by kind
+func sendingKinds(t *testing.T, offer webrtc.SessionDescription) map[string]int {
+	t.Helper()
+
+	kinds := make(map[string]int)
+	for _, media := range parseSDP(t, offer).MediaDescriptions {
+		if _, sendOnly := media.Attribute("sendonly"); sendOnly {
+			kinds[media.MediaName.Media]++
+		} else if _, sendRecv := media.Attribute("sendrecv"); sendRecv {
+			kinds[media.MediaName.Media]++
+		}
+	}
+	return kinds
+}
+
+// answerOffer has client answer an offer the server sent on ws
+func answerOffer(t *testing.T, ws *websocket.Conn, client *webrtc.PeerConnection, offer webrtc.SessionDescription) {
+	t.Helper()
+
+	if err := client.SetRemoteDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := client.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ws.WriteJSON(websocketMessage{Event: "answer", Data: descriptionData(t, answer)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readUntilClose returns the events received before the server closed the websocket
+func readUntilClose(t *testing.T, ws *websocket.Conn) ([]string, *websocket.CloseError) {
+	t.Helper()
+
+	var events []string
+	for {
+		message := websocketMessage{}
+		err := ws.ReadJSON(&message)
+		if err == nil {
+			events = append(events, message.Event)
+			continue
+		}
+
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("expected a close frame, got %v", err)
+		}
+		return events, closeErr
+	}
+}
+
+func TestHandlerRejectsUnknownRoom(t *testing.T) {
+	srv := newTestServer(t)
+	ws := dialRoom(t, srv, "not-a-room")
+
+	events, closeErr := readUntilClose(t, ws)
+	if closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != ErrRoomNotFound.Error() {
+		t.Fatalf("got close %d %q, want %d %q", closeErr.Code, closeErr.Text, websocket.ClosePolicyViolation, ErrRoomNotFound)
+	}
+	if len(events) == 0 || events[len(events)-1] != "error" {
+		t.Fatalf("got events %v, want them to end with error", events)
+	}
+
+	listLock.RLock()
+	_, registered := conferences["not-a-room"]
+	listLock.RUnlock()
+	if registered {
+		t.Fatal("joining an unknown room registered it")
+	}
+}
+
+// errWriteFailed is what a fakeConn with failWrites set answers to writes
+var errWriteFailed = errors.New("write failed")
+
+// fakeConn is a peerSocket keeping what the server writes, failWrites makes
+// every write fail and flag the connection the way threadSafeWriter does
+type fakeConn struct {
+	mu         sync.Mutex
+	written    []websocketMessage
+	failWrites bool
+	failed     atomic.Bool
+	pings      atomic.Int32
+}
+
+var _ peerSocket = (*fakeConn)(nil)
+
+func (c *fakeConn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failWrites {
+		c.markFailed()
+		return errWriteFailed
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	message := websocketMessage{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return err
+	}
+	c.written = append(c.written, message)
+	return nil
+}
+
+func (c *fakeConn) ReadMessage() (int, []byte, error) {
+	return 0, nil, io.EOF
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) WriteControl(messageType int, _ []byte, _ time.Time) error {
+	if c.failWrites {
+		c.markFailed()
+		return errWriteFailed
+	}
+	if messageType == websocket.PingMessage {
+		c.pings.Add(1)
+	}
+	return nil
+}
+
+func (c *fakeConn) markFailed() {
+	c.failed.Store(true)
+}
+
+func (c *fakeConn) broken() bool {
+	return c.failed.Load()
+}
+
+// events lists the events written to the connection so far
+func (c *fakeConn) events() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var events []string
+	for _, message := range c.written {
+		events = append(events, message.Event)
+	}
+	return events
+}
+
+// message returns the last message of the event written to the connection
+func (c *fakeConn) message(event string) (websocketMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(c.written) - 1; i >= 0; i-- {
+		if c.written[i].Event == event {
+			return c.written[i], true
+		}
+	}
+	return websocketMessage{}, false
+}
+
+// addFakePeer registers a peer whose websocket is a fakeConn in the room,
+// without a PeerConnection
+func addFakePeer(t *testing.T, roomUUID, peerID string) *fakeConn {
+	t.Helper()
+
+	conn := &fakeConn{}
+	peer := &peerConnectionState{id: peerID, websocket: conn}
+
+	listLock.Lock()
+	peerConnections[roomUUID] = append(peerConnections[roomUUID], peer)
+	listLock.Unlock()
+	t.Cleanup(func() {
+		listLock.Lock()
+		defer listLock.Unlock()
+
+		for i, p := range peerConnections[roomUUID] {
+			if p == peer {
+				peerConnections[roomUUID] = append(peerConnections[roomUUID][:i], peerConnections[roomUUID][i+1:]...)
+				return
+			}
+		}
+	})
+
+	return conn
+}
+
+// newTestPeer is a peer on the server side, with no room and no websocket
+func newTestPeer(t *testing.T) *peerConnectionState {
+	t.Helper()
+
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{
+		BundlePolicy:  bundlePolicy,
+		RTCPMuxPolicy: rtcpMuxPolicy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closePeerConnection(peerConnection) })
+
+	return &peerConnectionState{id: "peer", peerConnection: peerConnection}
+}
+
+// newTestClient is the browser end of a negotiation
+func newTestClient(t *testing.T) *webrtc.PeerConnection {
+	t.Helper()
+
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closePeerConnection(peerConnection) })
+	return peerConnection
+}
+
+// connectTestPublisher connects a client publishing a VP8 track to a
+// PeerConnection of the server, and returns the server end with the
+// client's sender of the track, where feedback of the server arrives
+func connectTestPublisher(t *testing.T) (*webrtc.PeerConnection, *webrtc.TrackLocalStaticRTP, *webrtc.RTPSender) {
+	t.Helper()
+
+	client := newTestClient(t)
+	track, err := webrtc.NewTrackLocalStaticRTP(testVP8, "camera", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := client.AddTrack(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := api.NewPeerConnection(webrtc.Configuration{
+		BundlePolicy:  bundlePolicy,
+		RTCPMuxPolicy: rtcpMuxPolicy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closePeerConnection(server) })
+
+	// Without trickling, each side's description carries all its candidates
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(client)
+	if err := client.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+
+	if err := server.SetRemoteDescription(*client.LocalDescription()); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := server.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered = webrtc.GatheringCompletePromise(server)
+	if err := server.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+
+	if err := client.SetRemoteDescription(*server.LocalDescription()); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for server.ConnectionState() != webrtc.PeerConnectionStateConnected {
+		if time.Now().After(deadline) {
+			t.Fatalf("got connection state %s, want connected", server.ConnectionState())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return server, track, sender
+}
+
+// nextPLI waits for a picture loss indication to reach sender and returns the SSRC it is for
+func nextPLI(t *testing.T, sender *webrtc.RTPSender) (uint32, bool) {
+	t.Helper()
+
+	_ = sender.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer sender.SetReadDeadline(time.Time{}) //nolint
+
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return 0, false
+		}
+		for _, packet := range packets {
+			if pli, ok := packet.(*rtcp.PictureLossIndication); ok {
+				return pli.MediaSSRC, true
+			}
+		}
+	}
+}
+
+// descriptionData encodes a session description the way clients send it
+func descriptionData(t *testing.T, desc webrtc.SessionDescription) string {
+	t.Helper()
+
+	data, err := json.Marshal(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// clientOffer is an offer of a client sending video
+func clientOffer(t *testing.T) webrtc.SessionDescription {
+	t.Helper()
+
+	client := newTestClient(t)
+	if _, err := client.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offer
+}
+
+// serverOffer leaves the peer with a pending offer and returns the client's answer to it
+func serverOffer(t *testing.T, peer *peerConnectionState) webrtc.SessionDescription {
+	t.Helper()
+
+	if _, err := peer.peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := peer.peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.peerConnection.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t)
+	if err := client.SetRemoteDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := client.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	return answer
+}
+
+func TestHandleMessage(t *testing.T) {
+	hostCandidate := `{"candidate":"candidate:1 1 udp 2130706431 192.0.2.1 5000 typ host","sdpMid":"0","sdpMLineIndex":0}`
+
+	tests := []struct {
+		name string
+
+		// message builds the message, preparing the peer for it if needed
+		message func(t *testing.T, peer *peerConnectionState) websocketMessage
+
+		wantOK     bool
+		wantEvents []string
+		wantState  webrtc.SignalingState
+		wantQueued int
+	}{
+		{
+			name: "offer is answered",
+			message: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				return websocketMessage{Event: "offer", Data: descriptionData(t, clientOffer(t))}
+			},
+			wantOK:     true,
+			wantEvents: []string{"answer"},
+			wantState:  webrtc.SignalingStateStable,
+		},
+		{
+			name: "answer completes a pending offer",
+			message: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				return websocketMessage{Event: "answer", Data: descriptionData(t, serverOffer(t, peer))}
+			},
+			wantOK:    true,
+			wantState: webrtc.SignalingStateStable,
+		},
+		{
+			name: "answer without a pending offer is ignored",
+			message: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				other := newTestPeer(t)
+				return websocketMessage{Event: "answer", Data: descriptionData(t, serverOffer(t, other))}
+			},
+			wantOK:    true,
+			wantState: webrtc.SignalingStateStable,
+		},
+		{
+			name: "candidate before the offer is queued",
+			message: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				return websocketMessage{Event: "candidate", Data: hostCandidate}
+			},
+			wantOK:     true,
+			wantState:  webrtc.SignalingStateStable,
+			wantQueued: 1,
+		},
+		{
+			name: "end of candidates is accepted",
+			message: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				return websocketMessage{Event: "candidate", Data: `{"candidate":"a=end-of-candidates"}`}
+			},
+			wantOK:     true,
+			wantState:  webrtc.SignalingStateStable,
+			wantQueued: 1,
+		},
+		{
+			name: "bad offer JSON closes",
+			message: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				return websocketMessage{Event: "offer", Data: "{"}
+			},
+			wantState: webrtc.SignalingStateStable,
+		},
+		{
+			name: "bad answer JSON closes",
+			message: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				serverOffer(t, peer)
+				return websocketMessage{Event: "answer", Data: "not json"}
+			},
+			wantState: webrtc.SignalingStateHaveLocalOffer,
+		},
+		{
+			name: "bad candidate JSON closes",
+			message: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				return websocketMessage{Event: "candidate", Data: "[]"}
+			},
+			wantState: webrtc.SignalingStateStable,
+		},
+		{
+			name: "unknown events are ignored",
+			message: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				return websocketMessage{Event: "no_such_event"}
+			},
+			wantOK:    true,
+			wantState: webrtc.SignalingStateStable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{}
+			peer := newTestPeer(t)
+			message := tt.message(t, peer)
+
+			if ok := handleMessage(conn, peer, "", &message); ok != tt.wantOK {
+				t.Fatalf("handleMessage returned %v, want %v", ok, tt.wantOK)
+			}
+
+			if events := conn.events(); strings.Join(events, ",") != strings.Join(tt.wantEvents, ",") {
+				t.Errorf("got events %v, want %v", events, tt.wantEvents)
+			}
+
+			if state := peer.peerConnection.SignalingState(); state != tt.wantState {
+				t.Errorf("got signaling state %s, want %s", state, tt.wantState)
+			}
+
+			if queued := len(peer.pendingCandidates); queued != tt.wantQueued {
+				t.Errorf("got %d queued candidates, want %d", queued, tt.wantQueued)
+			}
+		})
+	}
+}
+
+func TestQueuedCandidatesAreApplied(t *testing.T) {
+	hostCandidate := `{"candidate":"candidate:1 1 udp 2130706431 192.0.2.1 5000 typ host","sdpMid":"0","sdpMLineIndex":0}`
+
+	tests := []struct {
+		name string
+
+		// description is the answer or the offer of the client, made once
+		// the candidate is queued
+		description func(t *testing.T, peer *peerConnectionState) websocketMessage
+	}{
+		{
+			name: "by the answer",
+			description: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				return websocketMessage{Event: "answer", Data: descriptionData(t, serverOffer(t, peer))}
+			},
+		},
+		{
+			name: "by a client offer",
+			description: func(t *testing.T, peer *peerConnectionState) websocketMessage {
+				return websocketMessage{Event: "offer", Data: descriptionData(t, clientOffer(t))}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{}
+			peer := newTestPeer(t)
+
+			candidate := websocketMessage{Event: "candidate", Data: hostCandidate}
+			if !handleMessage(conn, peer, "", &candidate) {
+				t.Fatal("the early candidate closed the connection")
+			}
+			if queued := len(peer.pendingCandidates); queued != 1 {
+				t.Fatalf("got %d queued candidates, want 1", queued)
+			}
+
+			description := tt.description(t, peer)
+			if !handleMessage(conn, peer, "", &description) {
+				t.Fatalf("the %s closed the connection", description.Event)
+			}
+			if queued := len(peer.pendingCandidates); queued != 0 {
+				t.Fatalf("got %d candidates still queued, want 0", queued)
+			}
+
+			// The ICE agent takes the applied candidate in asynchronously
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				for _, stat := range peer.peerConnection.GetStats() {
+					if candidate, ok := stat.(webrtc.ICECandidateStats); ok && candidate.IP == "192.0.2.1" && candidate.Port == 5000 {
+						return
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			t.Fatal("the queued candidate wasn't applied")
+		})
+	}
+}
+
+func TestHandlerRejectsTokenOfOtherRoom(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServerAs(t, auth.Identity{Room: otherUUID})
+	_, closeErr := readUntilClose(t, dialRoom(t, srv, roomUUID))
+	if closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != ErrTokenForOtherRoom.Error() {
+		t.Fatalf("got close %d %q, want %d %q", closeErr.Code, closeErr.Text, websocket.ClosePolicyViolation, ErrTokenForOtherRoom)
+	}
+
+	listLock.RLock()
+	joined := len(peerConnections[roomUUID])
+	listLock.RUnlock()
+	if joined != 0 {
+		t.Fatalf("got %d peers in the room, want none", joined)
+	}
+}
+
+func TestHandlerRefusesWhileDraining(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetDraining(true)
+	t.Cleanup(func() { SetDraining(false) })
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/websocket/" + roomUUID + "/join"
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Fatal("joined while draining")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got response %v, want %d", resp, http.StatusServiceUnavailable)
+	}
+}
+
+func TestOfferGlare(t *testing.T) {
+	conn := &fakeConn{}
+	peer := newTestPeer(t)
+
+	// The server's own offer is still unanswered when the client's arrives
+	answer := serverOffer(t, peer)
+
+	collided := websocketMessage{Event: "offer", Data: descriptionData(t, clientOffer(t))}
+	if !handleMessage(conn, peer, "", &collided) {
+		t.Fatal("a colliding offer closed the connection")
+	}
+	if events := conn.events(); len(events) != 0 {
+		t.Fatalf("got events %v, want the colliding offer ignored", events)
+	}
+	if state := peer.peerConnection.SignalingState(); state != webrtc.SignalingStateHaveLocalOffer {
+		t.Fatalf("got signaling state %s, want %s", state, webrtc.SignalingStateHaveLocalOffer)
+	}
+
+	// The client gives way, answers the server's offer and offers again
+	answered := websocketMessage{Event: "answer", Data: descriptionData(t, answer)}
+	if !handleMessage(conn, peer, "", &answered) {
+		t.Fatal("the answer closed the connection")
+	}
+
+	again := websocketMessage{Event: "offer", Data: descriptionData(t, clientOffer(t))}
+	if !handleMessage(conn, peer, "", &again) {
+		t.Fatal("the offer made after the collision closed the connection")
+	}
+	if events := conn.events(); len(events) != 1 || events[0] != "answer" {
+		t.Fatalf("got events %v, want [answer]", events)
+	}
+	if state := peer.peerConnection.SignalingState(); state != webrtc.SignalingStateStable {
+		t.Fatalf("got signaling state %s, want %s", state, webrtc.SignalingStateStable)
+	}
+}
+
+func TestSetRoomLocked(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, hostKey, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetRoomLocked(roomUUID, "not-the-host-key", true); !errors.Is(err, ErrNotHost) {
+		t.Fatalf("locking without the host key: got %v, want %v", err, ErrNotHost)
+	}
+	if err := SetRoomLocked(roomUUID, hostKey, true); err != nil {
+		t.Fatal(err)
+	}
+
+	events, closeErr := readUntilClose(t, dialRoom(t, srv, roomUUID))
+	if closeErr.Code != websocket.ClosePolicyViolation || len(events) == 0 || events[len(events)-1] != "room_locked" {
+		t.Fatalf("join while locked: got events %v and close %d, want room_locked", events, closeErr.Code)
+	}
+
+	dialRoomWith(t, srv, roomUUID, url.Values{"host": {hostKey}})
+	waitForPeers(t, roomUUID, 1)
+
+	if err := SetRoomLocked(roomUUID, hostKey, false); err != nil {
+		t.Fatal(err)
+	}
+	joinRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 2)
+}
+
+func TestNotifyTrack(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, _ := joinRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 1)
+
+	track := addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+	want := trackEvent{TrackID: track.ID(), Kind: "video", PeerID: "alice"}
+
+	listLock.Lock()
+	notifyTrack(roomUUID, "track_added", track)
+	dropTrack(track, roomUUID)
+	listLock.Unlock()
+
+	for _, event := range []string{"track_added", "track_removed"} {
+		message, ok := nextEvent(t, ws, event, 5*time.Second)
+		if !ok {
+			t.Fatalf("no %s", event)
+		}
+
+		got := trackEvent{}
+		if err := json.Unmarshal([]byte(message.Data), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", event, got, want)
+		}
+	}
+}
+
+func TestAddTrackInAudioOnlyRoom(t *testing.T) {
+	config := DefaultRoomConfig()
+	config.AllowVideo = false
+	roomUUID, _, err := AddRoomUUID("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := addTrack(newTestTrack("alice", "camera", testVP8), roomUUID); !errors.Is(err, ErrTrackKindNotAllowed) {
+		t.Fatalf("video track: got %v, want %v", err, ErrTrackKindNotAllowed)
+	}
+	if _, err := addTrack(newTestTrack("alice", "mic", testOpus), roomUUID); err != nil {
+		t.Fatalf("audio track: %v", err)
+	}
+
+	tracks, err := ListTracks(roomUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 1 || tracks[0].Kind != "audio" {
+		t.Fatalf("got tracks %+v, want the audio one only", tracks)
+	}
+}
+
+func TestWelcome(t *testing.T) {
+	previous := Version
+	Version = "1.2.3"
+	t.Cleanup(func() { Version = previous })
+
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	_, welcome := joinRoom(t, srv, roomUUID)
+	after := time.Now()
+
+	if welcome.Version != "1.2.3" {
+		t.Fatalf("got version %q, want %q", welcome.Version, "1.2.3")
+	}
+	if welcome.ServerTime.Before(before) || welcome.ServerTime.After(after) {
+		t.Fatalf("got server time %v, want between %v and %v", welcome.ServerTime, before, after)
+	}
+	if welcome.ConnectionID == "" {
+		t.Fatal("got no connection ID")
+	}
+}
+
+func TestSignalBeforeAnyTrack(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listLock.RLock()
+	peers, tracks := peerConnections[roomUUID], trackLocals[roomUUID]
+	listLock.RUnlock()
+	if peers == nil || tracks == nil {
+		t.Fatalf("got peers %v and tracks %v, want both set up with the room", peers, tracks)
+	}
+
+	first, second := dialRoom(t, srv, roomUUID), dialRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 2)
+
+	for i, ws := range []*websocket.Conn{first, second} {
+		if _, ok := nextEvent(t, ws, "offer", 5*time.Second); !ok {
+			t.Fatalf("peer %d got no offer", i+1)
+		}
+	}
+
+	signalPeerConnections(roomUUID)
+	waitForPeers(t, roomUUID, 2)
+}
+
+func TestCloseAll(t *testing.T) {
+	srv := newTestServer(t)
+	t.Cleanup(func() { SetDraining(false) })
+
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := dialRoom(t, srv, roomUUID), dialRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 2)
+
+	CloseAll()
+
+	for i, ws := range []*websocket.Conn{first, second} {
+		events, closeErr := readUntilClose(t, ws)
+		if len(events) == 0 || events[len(events)-1] != "server_shutdown" {
+			t.Fatalf("peer %d got %v, want server_shutdown before the close", i+1, events)
+		}
+		if closeErr.Code != websocket.CloseGoingAway {
+			t.Fatalf("peer %d got close code %d, want %d", i+1, closeErr.Code, websocket.CloseGoingAway)
+		}
+	}
+}
+
+func TestAudioOnlySubscription(t *testing.T) {
+	srv := newTestServer(t)
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+	addTestTrack(t, roomUUID, "alice", "microphone", testOpus)
+
+	ws := dialRoomWith(t, srv, roomUUID, url.Values{"audioOnly": {"true"}})
+	client := newTestClient(t)
+
+	offer := nextOffer(t, ws)
+	if kinds := sendingKinds(t, offer); kinds["audio"] != 1 || kinds["video"] != 0 {
+		t.Fatalf("joined audio only: got %v, want the audio track alone", kinds)
+	}
+	answerOffer(t, ws, client, offer)
+
+	for _, step := range []struct {
+		mode      string
+		wantVideo int
+	}{
+		{mode: receiveAll, wantVideo: 1},
+		{mode: receiveAudioOnly, wantVideo: 0},
+	} {
+		if err := ws.WriteJSON(websocketMessage{Event: "set_receive_mode", Data: `"` + step.mode + `"`}); err != nil {
+			t.Fatal(err)
+		}
+
+		offer := nextOffer(t, ws)
+		if kinds := sendingKinds(t, offer); kinds["audio"] != 1 || kinds["video"] != step.wantVideo {
+			t.Fatalf("switched to %s: got %v, want 1 audio and %d video", step.mode, kinds, step.wantVideo)
+		}
+		answerOffer(t, ws, client, offer)
+	}
+}
+
+func TestRenameRoom(t *testing.T) {
+	srv := newTestServer(t)
+
+	// Rooms outlive the test, the names must not clash with an earlier run
+	suffix := " " + uuid.NewString()[:8]
+
+	config := DefaultRoomConfig()
+	config.Name = "Standup" + suffix
+	roomUUID, hostKey, err := AddRoomUUID("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Name = "Retro" + suffix
+	if _, _, err := AddRoomUUID("", config); err != nil {
+		t.Fatal(err)
+	}
+	ws := dialRoom(t, srv, roomUUID)
+	waitForPeers(t, roomUUID, 1)
+
+	if err := RenameRoom(roomUUID, hostKey, "  Planning"+suffix+"  "); err != nil {
+		t.Fatal(err)
+	}
+	message, ok := nextEvent(t, ws, "room_renamed", 5*time.Second)
+	if !ok || message.Data != `"Planning`+suffix+`"` {
+		t.Fatalf("got %+v, want room_renamed to Planning", message)
+	}
+
+	tests := []struct {
+		name     string
+		roomUUID string
+		hostKey  string
+		newName  string
+		want     error
+	}{
+		{name: "same name again", roomUUID: roomUUID, hostKey: hostKey, newName: "Planning" + suffix},
+		{name: "name of another room", roomUUID: roomUUID, hostKey: hostKey, newName: "retro" + suffix, want: ErrRoomNameTaken},
+		{name: "not the host", roomUUID: roomUUID, hostKey: "guess", newName: "Mine", want: ErrNotHost},
+		{name: "unknown room", roomUUID: "no-such-room", hostKey: hostKey, newName: "Mine", want: ErrRoomNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RenameRoom(tt.roomUUID, tt.hostKey, tt.newName); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Creating a room under a taken name fails the same way, other tenants have names of their own
+	config.Name = "PLANNING" + suffix
+	if _, _, err := AddRoomUUID("", config); err != ErrRoomNameTaken {
+		t.Fatalf("creating a room named PLANNING: got %v, want %v", err, ErrRoomNameTaken)
+	}
+	if _, _, err := AddRoomUUID("other-tenant", config); err != nil {
+		t.Fatalf("creating PLANNING for another tenant: %v", err)
+	}
+}
+
+func TestListTracks(t *testing.T) {
+	roomUUID, _, err := AddRoomUUID("", DefaultRoomConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	camera := addTestTrack(t, roomUUID, "alice", "camera", testVP8)
+	mic := addTestTrack(t, roomUUID, "alice", "mic", testOpus)
+	screen := addTestTrack(t, roomUUID, "bob", "screen", testVP8)
+	bindTestSubscriber(t, camera, "bob")
+	bindTestSubscriber(t, camera, "carol")
+	bindTestSubscriber(t, mic, "bob")
+	screen.hold()
+
+	got, err := ListTracks(roomUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TrackInfo{
+		{TrackID: camera.ID(), Kind: "video", Codec: testVP8.MimeType, PeerID: "alice", Subscribers: 2},
+		{TrackID: mic.ID(), Kind: "audio", Codec: testOpus.MimeType, PeerID: "alice", Subscribers: 1},
+		{TrackID: screen.ID(), Kind: "video", Codec: testVP8.MimeType, PeerID: "bob", Held: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %+v, want %+v", got[i], want[i])
+		}
+	}
+
+	if _, err := ListTracks("no-such-room"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("unknown room: got %v, want %v", err, ErrRoomNotFound)
+	}
+}
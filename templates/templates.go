@@ -0,0 +1,7 @@
+// Package templates embeds the HTML pages so the binary doesn't depend on the working directory
+package templates
+
+import "embed"
+
+//go:embed *.html
+var FS embed.FS